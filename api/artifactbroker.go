@@ -0,0 +1,86 @@
+package api
+
+import "sync"
+
+// ArtifactBroker is an in-process publish/subscribe hub for artifact changes, keyed by artifact id.
+// Notifications carry no data: subscribers are expected to look up the artifact (and its content)
+// again once notified.
+type ArtifactBroker struct {
+	mu   sync.Mutex
+	subs map[int64]map[chan struct{}]bool
+	// Latest artifact version published to subscribers of each artifact, if known.
+	versions map[int64]int64
+}
+
+// NewArtifactBroker creates an ArtifactBroker without any subscribers.
+func NewArtifactBroker() *ArtifactBroker {
+	return &ArtifactBroker{subs: make(map[int64]map[chan struct{}]bool), versions: make(map[int64]int64)}
+}
+
+// Subscribe returns a channel which receives a value after each change to the given artifact, and a
+// function to cancel the subscription. Notifications are coalesced: at most one notification is
+// pending at a time, so a slow subscriber never blocks publishers.
+func (b *ArtifactBroker) Subscribe(artifactID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[artifactID] == nil {
+		b.subs[artifactID] = make(map[chan struct{}]bool)
+	}
+	b.subs[artifactID][ch] = true
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[artifactID], ch)
+		if len(b.subs[artifactID]) == 0 {
+			delete(b.subs, artifactID)
+			delete(b.versions, artifactID)
+		}
+	}
+}
+
+// Publish notifies all subscribers of the given artifact that it has changed.
+func (b *ArtifactBroker) Publish(artifactID int64) {
+	b.PublishVersion(artifactID, 0)
+}
+
+// PublishVersion notifies all subscribers of the given artifact that it has changed to given
+// version, unless that version (or a later one) was already published. The same change is usually
+// published twice, by the server making it and by the database (see database.ArtifactListener).
+// A version of 0 means the version is unknown, and is always published.
+func (b *ArtifactBroker) PublishVersion(artifactID int64, version int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[artifactID] == nil {
+		return
+	}
+	if version != 0 {
+		if version <= b.versions[artifactID] {
+			return
+		}
+		b.versions[artifactID] = version
+	}
+	for ch := range b.subs[artifactID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A notification is already pending.
+		}
+	}
+}
+
+// PublishAll notifies all subscribers, for when changes to artifacts may have gone unnoticed.
+func (b *ArtifactBroker) PublishAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, subs := range b.subs {
+		for ch := range subs {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
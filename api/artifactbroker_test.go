@@ -0,0 +1,90 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireNotified(t *testing.T, ch <-chan struct{}, notified bool) {
+	select {
+	case <-ch:
+		require.True(t, notified, "Unexpected notification")
+	default:
+		require.False(t, notified, "Missing notification")
+	}
+}
+
+func TestArtifactBroker(t *testing.T) {
+	b := NewArtifactBroker()
+
+	// Publishing without subscribers is a no-op.
+	b.Publish(1)
+
+	ch1, cancel1 := b.Subscribe(1)
+	ch1b, cancel1b := b.Subscribe(1)
+	ch2, cancel2 := b.Subscribe(2)
+	defer cancel2()
+
+	b.Publish(1)
+	requireNotified(t, ch1, true)
+	requireNotified(t, ch1b, true)
+	requireNotified(t, ch2, false)
+
+	// Notifications are coalesced, and publishers never block.
+	b.Publish(1)
+	b.Publish(1)
+	requireNotified(t, ch1, true)
+	requireNotified(t, ch1, false)
+	requireNotified(t, ch1b, true)
+
+	// Cancelled subscriptions aren't notified.
+	cancel1()
+	b.Publish(1)
+	requireNotified(t, ch1, false)
+	requireNotified(t, ch1b, true)
+
+	cancel1b()
+	require.Empty(t, b.subs[1])
+	require.Len(t, b.subs, 1)
+}
+
+func TestArtifactBrokerPublishAll(t *testing.T) {
+	b := NewArtifactBroker()
+	ch1, cancel1 := b.Subscribe(1)
+	defer cancel1()
+	ch2, cancel2 := b.Subscribe(2)
+	defer cancel2()
+
+	b.PublishAll()
+	requireNotified(t, ch1, true)
+	requireNotified(t, ch2, true)
+}
+
+func TestArtifactBrokerPublishVersion(t *testing.T) {
+	b := NewArtifactBroker()
+	ch, cancel := b.Subscribe(1)
+
+	b.PublishVersion(1, 3)
+	requireNotified(t, ch, true)
+
+	// The same change published again (e.g. by the database) is dropped, as are older versions.
+	b.PublishVersion(1, 3)
+	b.PublishVersion(1, 2)
+	requireNotified(t, ch, false)
+
+	b.PublishVersion(1, 4)
+	requireNotified(t, ch, true)
+
+	// Changes of unknown version are always published.
+	b.PublishVersion(1, 0)
+	requireNotified(t, ch, true)
+
+	// Versions are forgotten along with the last subscriber.
+	cancel()
+	require.Empty(t, b.versions)
+	ch, cancel = b.Subscribe(1)
+	defer cancel()
+	b.PublishVersion(1, 4)
+	requireNotified(t, ch, true)
+}
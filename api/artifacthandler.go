@@ -6,16 +6,21 @@ package api
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -24,9 +29,9 @@ import (
 	"github.com/dropbox/changes-artifacts/common/stats"
 	"github.com/dropbox/changes-artifacts/database"
 	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
 	"github.com/martini-contrib/render"
 	"github.com/moshee/airlift/contentdisposition"
-	"gopkg.in/amz.v1/s3"
 )
 
 const DEFAULT_DEADLINE = 30
@@ -37,16 +42,28 @@ const DuplicateArtifactNameFormat = "%s.dup.%s"
 // Maximum number of duplicate file name resolution attempts before failing with an internal error.
 const MaxDuplicateFileNameResolutionAttempts = 5
 
-// Maximum artifact size => 200 MB
-const MaxArtifactSizeBytes = 200 * 1024 * 1024
+// DefaultMaxArtifactSizeBytes is the default maximum artifact size => 200 MB
+const DefaultMaxArtifactSizeBytes = 200 * 1024 * 1024
+
+// MaxArtifactSizeBytes is the maximum size of a streamed artifact. Uploads are spooled to disk, so
+// this is bounded by disk space in UploadSpoolDir rather than memory. Set at startup.
+var MaxArtifactSizeBytes int64 = DefaultMaxArtifactSizeBytes
+
+// UploadSpoolDir is the directory where streamed uploads are spooled before being sent to the blob
+// store. If empty, the default directory for temporary files is used. Set at startup.
+var UploadSpoolDir = ""
 
 // Maximum number of bytes to fetch while returning chunked response.
 const MaxChunkedRequestBytes = 1000000
 
+// MaxChunkedWait is the longest a chunked content request waits for new content.
+const MaxChunkedWait = 1 * time.Minute
+
 // MaxUploadAttempts is the maximum number of attempts to upload an artifact to S3.
 const MaxUploadAttempts = 3
 
 var bytesUploadedCounter = stats.NewStat("bytes_uploaded")
+var artifactsDeletedCounter = stats.NewStat("artifacts_deleted")
 
 type createArtifactReq struct {
 	Name         string
@@ -63,6 +80,34 @@ type createLogChunkReq struct {
 	Bytes      []byte
 }
 
+// Query parameter holding the byte offset of a log chunk sent as a raw request body.
+const logChunkOffsetParam = "offset"
+
+// parseLogChunkReq reads a log chunk to be appended from the request. Chunks are sent either as the
+// raw request body (with Content-Type application/octet-stream), positioned at the byte offset in
+// the "offset" query parameter, or as a JSON encoded createLogChunkReq.
+func parseLogChunkReq(req *http.Request) (*createLogChunkReq, error) {
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/octet-stream" {
+		logChunkReq := new(createLogChunkReq)
+		if err := json.NewDecoder(req.Body).Decode(logChunkReq); err != nil {
+			return nil, err
+		}
+		return logChunkReq, nil
+	}
+
+	offset, err := strconv.ParseInt(req.URL.Query().Get(logChunkOffsetParam), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid or missing %s parameter: %s", logChunkOffsetParam, err)
+	}
+
+	content, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading log chunk: %s", err)
+	}
+
+	return &createLogChunkReq{ByteOffset: offset, Size: int64(len(content)), Bytes: content}, nil
+}
+
 // CreateArtifact creates a new artifact in a open bucket.
 //
 // If an artifact with the same name already exists in the same bucket, we attempt to rename the
@@ -92,16 +137,18 @@ func CreateArtifact(req createArtifactReq, bucket *model.Bucket, db database.Dat
 		artifact.DeadlineMins = req.DeadlineMins
 	}
 
-	if req.Chunked {
-		artifact.State = model.APPENDING
-	} else {
+	initialState := model.APPENDING
+	if !req.Chunked {
 		if req.Size == 0 {
 			return nil, NewHttpError(http.StatusBadRequest, "Cannot create a new upload artifact without size.")
 		} else if req.Size > MaxArtifactSizeBytes {
 			return nil, NewHttpError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Entity '%s' (size %d) is too large (limit %d)", req.Name, req.Size, MaxArtifactSizeBytes))
 		}
 		artifact.Size = req.Size
-		artifact.State = model.WAITING_FOR_UPLOAD
+		initialState = model.WAITING_FOR_UPLOAD
+	}
+	if err := artifact.TransitionTo(initialState); err != nil {
+		return nil, NewWrappedHttpError(http.StatusInternalServerError, err)
 	}
 
 	if req.RelativePath == "" {
@@ -157,21 +204,126 @@ func HandleCreateArtifact(ctx context.Context, r render.Render, req *http.Reques
 	}
 }
 
-func ListArtifacts(ctx context.Context, r render.Render, req *http.Request, db database.Database, bucket *model.Bucket) {
+// ListArtifacts lists artifacts in a bucket. The listing can be filtered, sorted and paginated with
+// the following query parameters:
+//
+//   - state: Only list artifacts in given state (for example, "UPLOADED").
+//   - namePrefix, relativePathPrefix: Only list artifacts whose name (or relative path) starts with
+//     given prefix.
+//   - nameGlob, relativePathGlob: Only list artifacts whose name (or relative path) matches given
+//     glob pattern ('*' matches any sequence of characters, '?' any single character).
+//   - sort: One of "id" (order of creation, default), "name", "size" or "dateCreated". Prefix with
+//     '-' for descending order.
+//   - limit: Page size, between 1 and MaxPageSize. If not set, all matching artifacts are listed in
+//     a single response, for compatibility with older clients.
+//   - cursor: Continue listing from the end of a previous page (see NextCursorHeader). The cursor
+//     must be used with the same sort order as the page it was returned with.
+func ListArtifacts(ctx context.Context, r render.Render, req *http.Request, res http.ResponseWriter, db database.Database, bucket *model.Bucket) {
 	if bucket == nil {
 		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No bucket specified")
 		return
 	}
 
-	artifacts, err := db.ListArtifactsInBucket(bucket.Id)
+	query := req.URL.Query()
+	filter, err := artifactFilterFromQuery(query)
 	if err != nil {
-		LogAndRespondWithError(ctx, r, http.StatusInternalServerError, err)
+		LogAndRespondWithError(ctx, r, http.StatusBadRequest, err)
 		return
 	}
+	filter.BucketId = bucket.Id
 
+	artifacts, dberr := db.ListArtifacts(filter)
+	if dberr != nil {
+		LogAndRespondWithError(ctx, r, http.StatusInternalServerError, dberr)
+		return
+	}
+
+	if filter.Limit > 0 && len(artifacts) == filter.Limit {
+		last := artifacts[len(artifacts)-1]
+		res.Header().Set(NextCursorHeader, encodeCursor(artifactListCursor{
+			Sort: query.Get("sort"),
+			ArtifactCursor: database.ArtifactCursor{
+				Name:        last.Name,
+				Size:        last.Size,
+				DateCreated: last.DateCreated,
+				Id:          last.Id,
+			},
+		}))
+	}
 	r.JSON(http.StatusOK, artifacts)
 }
 
+// artifactListCursor is the position in an artifact listing encoded in its cursor. The sort order
+// is included since a position is meaningless in a listing with a different order.
+type artifactListCursor struct {
+	Sort string
+	database.ArtifactCursor
+}
+
+// Values of the "sort" parameter of ListArtifacts.
+var artifactSortKeys = map[string]database.ArtifactSortKey{
+	"id":          database.SortArtifactsByID,
+	"name":        database.SortArtifactsByName,
+	"size":        database.SortArtifactsBySize,
+	"dateCreated": database.SortArtifactsByDateCreated,
+}
+
+func artifactFilterFromQuery(query url.Values) (database.ArtifactFilter, error) {
+	filter := database.ArtifactFilter{
+		NamePrefix:         query.Get("namePrefix"),
+		NameGlob:           query.Get("nameGlob"),
+		RelativePathPrefix: query.Get("relativePathPrefix"),
+		RelativePathGlob:   query.Get("relativePathGlob"),
+	}
+
+	if state := query.Get("state"); state != "" {
+		s, err := parseArtifactState(state)
+		if err != nil {
+			return filter, err
+		}
+		filter.State = s
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		key := sort
+		if key[0] == '-' {
+			key = key[1:]
+			filter.Descending = true
+		}
+		if filter.SortBy = artifactSortKeys[key]; filter.SortBy == "" {
+			return filter, fmt.Errorf("Invalid sort order %q", sort)
+		}
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		var position artifactListCursor
+		if err := decodeCursor(cursor, &position); err != nil {
+			return filter, err
+		}
+		if position.Sort != query.Get("sort") {
+			return filter, fmt.Errorf("Cursor %q does not match sort order %q", cursor, query.Get("sort"))
+		}
+		filter.Cursor = &position.ArtifactCursor
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		filter.Limit, err = pageSizeParam(limit)
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parseArtifactState parses an artifact state from its name (for example, "UPLOADED").
+func parseArtifactState(name string) (model.ArtifactState, error) {
+	for s := model.ERROR; s <= model.CLOSED_WITHOUT_DATA; s++ {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return model.UNKNOWN_ARTIFACT_STATE, fmt.Errorf("Invalid artifact state %q", name)
+}
+
 func HandleGetArtifact(ctx context.Context, r render.Render, artifact *model.Artifact) {
 	if artifact == nil {
 		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No bucket specified")
@@ -234,36 +386,197 @@ func AppendLogChunk(ctx context.Context, db database.Database, artifact *model.A
 		return NewHttpError(http.StatusBadRequest, "Overlapping ranges detected, expected offset: %d, actual offset: %d", nextByteOffset, logChunkReq.ByteOffset)
 	}
 
-	// Expand artifact size - redundant after above change.
-	if artifact.Size < logChunkReq.ByteOffset+logChunkReq.Size {
-		artifact.Size = logChunkReq.ByteOffset + logChunkReq.Size
-		if err := db.UpdateArtifact(artifact); err != nil {
-			return NewHttpError(http.StatusInternalServerError, err.Error())
+	// The artifact size and its logchunks must always agree, otherwise reads are truncated.
+	err := db.WithTx(func(tx database.Database) error {
+		// Expand artifact size - redundant after above change.
+		if artifact.Size < logChunkReq.ByteOffset+logChunkReq.Size {
+			artifact.Size = logChunkReq.ByteOffset + logChunkReq.Size
+			// Fails if the artifact was appended to or closed concurrently.
+			if err := tx.UpdateArtifact(artifact); err != nil {
+				return NewWrappedHttpError(errorStatus(err, http.StatusInternalServerError), err)
+			}
+		}
+
+		logChunk := &model.LogChunk{
+			ArtifactId:   artifact.Id,
+			ByteOffset:   logChunkReq.ByteOffset,
+			ContentBytes: contentBytes,
+			Size:         logChunkReq.Size,
+		}
+
+		if err := tx.InsertLogChunk(logChunk); err != nil {
+			return NewHttpError(http.StatusBadRequest, "Error updating log chunk: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return NewWrappedHttpError(errorStatus(err, http.StatusInternalServerError), err)
+	}
+	return nil
+}
+
+// MaxLogChunksPerBatch is the maximum number of log chunks which can be appended with a single
+// request (see AppendLogChunks).
+const MaxLogChunksPerBatch = 1000
+
+// Query parameter holding the comma separated sizes of the log chunks in a batch.
+const logChunkSizesParam = "sizes"
+
+type appendLogChunksReq struct {
+	// Byte offset of the first chunk. The other chunks follow it contiguously.
+	ByteOffset int64
+	Chunks     [][]byte
+}
+
+// parseLogChunksReq reads a batch of log chunks to be appended from the request. The request body
+// is the content of all chunks concatenated, the byte offset of the first chunk is in the "offset"
+// query parameter and the chunk sizes are in the "sizes" query parameter, comma separated.
+func parseLogChunksReq(req *http.Request) (*appendLogChunksReq, error) {
+	query := req.URL.Query()
+	offset, err := strconv.ParseInt(query.Get(logChunkOffsetParam), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid or missing %s parameter: %s", logChunkOffsetParam, err)
+	}
+
+	sizes := strings.Split(query.Get(logChunkSizesParam), ",")
+	if len(sizes) > MaxLogChunksPerBatch {
+		return nil, fmt.Errorf("Too many log chunks in batch: %d, at most %d are allowed", len(sizes), MaxLogChunksPerBatch)
+	}
+
+	content, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading log chunks: %s", err)
+	}
+
+	batch := &appendLogChunksReq{ByteOffset: offset, Chunks: make([][]byte, len(sizes))}
+	for i, sizeStr := range sizes {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("Invalid size %q of log chunk %d", sizeStr, i)
 		}
+		if size > len(content) {
+			return nil, fmt.Errorf("Log chunk sizes exceed content length")
+		}
+		batch.Chunks[i], content = content[:size], content[size:]
+	}
+
+	if len(content) != 0 {
+		return nil, fmt.Errorf("Content length does not match sum of log chunk sizes")
 	}
 
-	logChunk := &model.LogChunk{
-		ArtifactId:   artifact.Id,
-		ByteOffset:   logChunkReq.ByteOffset,
-		ContentBytes: contentBytes,
-		Size:         logChunkReq.Size,
+	return batch, nil
+}
+
+// AppendLogChunks appends a batch of contiguous log chunks to an artifact in a single transaction.
+// As with AppendLogChunk, the batch must start at the current end of the artifact, except when the
+// last batch appended is repeated, which is silently ignored.
+func AppendLogChunks(ctx context.Context, db database.Database, artifact *model.Artifact, batch *appendLogChunksReq) *HttpError {
+	if artifact.State != model.APPENDING {
+		return NewHttpError(http.StatusBadRequest, "Unexpected artifact state: %s", artifact.State)
 	}
 
-	if err := db.InsertLogChunk(logChunk); err != nil {
-		return NewHttpError(http.StatusBadRequest, "Error updating log chunk: %s", err)
+	if len(batch.Chunks) == 0 {
+		return NewHttpError(http.StatusBadRequest, "Empty log chunk batch")
+	}
+
+	logChunks := make([]model.LogChunk, len(batch.Chunks))
+	byteOffset := batch.ByteOffset
+	for i, chunk := range batch.Chunks {
+		if len(chunk) == 0 {
+			return NewHttpError(http.StatusBadRequest, "Empty log chunk %d in batch", i)
+		}
+		logChunks[i] = model.LogChunk{
+			ArtifactId:   artifact.Id,
+			ByteOffset:   byteOffset,
+			ContentBytes: chunk,
+			Size:         int64(len(chunk)),
+		}
+		byteOffset += int64(len(chunk))
+	}
+
+	if artifact.Size != batch.ByteOffset {
+		// As in AppendLogChunk, the client may be retrying a batch it didn't get an ACK for.
+		if artifact.Size == byteOffset && isRepeatedLogChunkBatch(db, artifact, logChunks) {
+			sentry.ReportMessage(ctx, fmt.Sprintf("Received duplicate batch of %d chunks for artifact %v at byte %d", len(logChunks), artifact.Id, batch.ByteOffset))
+			return nil
+		}
+
+		return NewHttpError(http.StatusBadRequest, "Overlapping ranges detected, expected offset: %d, actual offset: %d", artifact.Size, batch.ByteOffset)
+	}
+
+	err := db.WithTx(func(tx database.Database) error {
+		artifact.Size = byteOffset
+		// Fails if the artifact was appended to or closed concurrently.
+		if err := tx.UpdateArtifact(artifact); err != nil {
+			return err
+		}
+
+		if err := tx.InsertLogChunks(logChunks); err != nil {
+			return NewHttpError(http.StatusBadRequest, "Error inserting log chunks: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return NewWrappedHttpError(errorStatus(err, http.StatusInternalServerError), err)
 	}
 	return nil
 }
 
+// isRepeatedLogChunkBatch returns true if logChunks are the last log chunks of the artifact. This is
+// a best-effort check - DB errors are treated as a mismatch.
+func isRepeatedLogChunkBatch(db database.Database, artifact *model.Artifact, logChunks []model.LogChunk) bool {
+	stored, err := db.ListLogChunksInArtifact(artifact.Id, logChunks[0].ByteOffset, artifact.Size)
+	if err != nil {
+		return false
+	}
+
+	// The chunk preceding the batch may be listed as well, if it ends right where the batch starts.
+	if len(stored) > 0 && stored[0].ByteOffset < logChunks[0].ByteOffset {
+		stored = stored[1:]
+	}
+
+	if len(stored) != len(logChunks) {
+		return false
+	}
+	for i := range stored {
+		if stored[i].ByteOffset != logChunks[i].ByteOffset || !bytes.Equal(stored[i].ContentBytes, logChunks[i].ContentBytes) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleAppendLogChunks handles the HTTP request to append a batch of log chunks to an artifact. See
+// parseLogChunksReq for the request format, and AppendLogChunks for details.
+func HandleAppendLogChunks(ctx context.Context, r render.Render, req *http.Request, db database.Database, artifact *model.Artifact) {
+	if artifact == nil {
+		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No artifact specified")
+		return
+	}
+
+	batch, err := parseLogChunksReq(req)
+	if err != nil {
+		LogAndRespondWithError(ctx, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := AppendLogChunks(ctx, db, artifact, batch); err != nil {
+		LogAndRespondWithError(ctx, r, err.errCode, err)
+		return
+	}
+
+	r.JSON(http.StatusOK, artifact)
+}
+
 // PostArtifact updates content associated with an artifact.
 //
 // If the artifact is streamed (uploaded in one shot), PutArtifact is invoked to stream content
-// directly through to S3.
+// directly through to the blob store.
 //
 // If the artifact is chunked (appended chunk by chunk), verify that the position being written to
 // matches the current end of artifact, insert a new log chunk at that position and move the end of
-// file forward.
-func PostArtifact(ctx context.Context, r render.Render, req *http.Request, db database.Database, s3bucket *s3.Bucket, artifact *model.Artifact) {
+// file forward. See parseLogChunkReq for the accepted chunk encodings.
+func PostArtifact(ctx context.Context, r render.Render, req *http.Request, db database.Database, store storage.BlobStore, artifact *model.Artifact) {
 	if artifact == nil {
 		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No artifact specified")
 		return
@@ -275,10 +588,17 @@ func PostArtifact(ctx context.Context, r render.Render, req *http.Request, db da
 		contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64) // string, base, bits
 		if err != nil {
 			LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "Couldn't parse Content-Length as int64")
+			return
 		} else if contentLength != artifact.Size {
 			LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "Content-Length does not match artifact size")
-		} else if err = PutArtifact(ctx, artifact, db, s3bucket, PutArtifactReq{ContentLength: contentLengthStr, Body: req.Body}); err != nil {
-			LogAndRespondWithError(ctx, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		expectedSha256, err := parseSha256Digest(req.Header.Get(DigestHeader))
+		if err != nil {
+			LogAndRespondWithError(ctx, r, http.StatusBadRequest, err)
+		} else if err = PutArtifact(ctx, artifact, db, store, PutArtifactReq{ContentLength: contentLengthStr, Body: req.Body, ExpectedSha256: expectedSha256}); err != nil {
+			LogAndRespondWithError(ctx, r, errorStatus(err, http.StatusInternalServerError), err)
 		} else {
 			r.JSON(http.StatusOK, artifact)
 		}
@@ -293,9 +613,8 @@ func PostArtifact(ctx context.Context, r render.Render, req *http.Request, db da
 		return
 
 	case model.APPENDING:
-		// TODO: Treat contents as a JSON request containing a chunk.
-		logChunkReq := new(createLogChunkReq)
-		if err := json.NewDecoder(req.Body).Decode(logChunkReq); err != nil {
+		logChunkReq, err := parseLogChunkReq(req)
+		if err != nil {
 			LogAndRespondWithError(ctx, r, http.StatusBadRequest, err)
 			return
 		}
@@ -311,36 +630,49 @@ func PostArtifact(ctx context.Context, r render.Render, req *http.Request, db da
 	case model.APPEND_COMPLETE:
 		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "Artifact is closed for further appends")
 		return
+
+	default:
+		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "Artifact is closed for further writes, state is %s", artifact.State)
+		return
 	}
 }
 
-// CloseArtifact closes an artifact for further writes and begins process of merging and uploading
-// the artifact. This operation is only valid for artifacts which are being uploaded in chunks.
+// CloseArtifact closes an artifact for further writes. Chunked artifacts are moved to
+// APPEND_COMPLETE, after which they are merged and uploaded asynchronously by a MergeWorkerPool.
+// This operation is only valid for artifacts which are being uploaded in chunks.
 // In all other cases, an error is returned.
-func CloseArtifact(ctx context.Context, artifact *model.Artifact, db database.Database, s3bucket *s3.Bucket, failIfAlreadyClosed bool) error {
+func CloseArtifact(ctx context.Context, artifact *model.Artifact, db database.Database, failIfAlreadyClosed bool) error {
 	switch artifact.State {
 	case model.UPLOADED:
 		// Already closed. Nothing to do here.
 		fallthrough
 	case model.APPEND_COMPLETE:
+		fallthrough
+	case model.UPLOADING:
 		// This artifact will be eventually shipped to S3. No change required.
 		return nil
 
 	case model.APPENDING:
-		artifact.State = model.APPEND_COMPLETE
-		if err := db.UpdateArtifact(artifact); err != nil {
-			return err
+		if artifact.Size == 0 {
+			// Nothing was appended, so there is nothing to merge.
+			return updateArtifactState(db, artifact, model.CLOSED_WITHOUT_DATA)
 		}
-
-		return MergeLogChunks(ctx, artifact, db, s3bucket)
+		return updateArtifactState(db, artifact, model.APPEND_COMPLETE)
 
 	case model.WAITING_FOR_UPLOAD:
 		// Streaming artifact was not uploaded
-		artifact.State = model.CLOSED_WITHOUT_DATA
-		if err := db.UpdateArtifact(artifact); err != nil {
-			return err
-		}
+		return updateArtifactState(db, artifact, model.CLOSED_WITHOUT_DATA)
 
+	case model.CLOSED_WITHOUT_DATA:
+		fallthrough
+	case model.DEADLINE_EXCEEDED:
+		fallthrough
+	case model.ERROR:
+		// Already finalized without (complete) content. This is expected while closing a bucket, which
+		// closes all of its artifacts.
+		if failIfAlreadyClosed {
+			return fmt.Errorf("Artifact is already closed: %s", artifact.State)
+		}
 		return nil
 
 	default:
@@ -348,83 +680,132 @@ func CloseArtifact(ctx context.Context, artifact *model.Artifact, db database.Da
 	}
 }
 
-// Merges all of the individual chunks into a single object and stores it on s3.
-// The log chunks are stored in the database, while the object is uploaded to s3.
-func MergeLogChunks(ctx context.Context, artifact *model.Artifact, db database.Database, s3bucket *s3.Bucket) error {
-	switch artifact.State {
-	case model.APPEND_COMPLETE:
-		// TODO: Reimplement using GorpDatabase
-		// If the file is empty, don't bother creating an object on S3.
-		if artifact.Size == 0 {
-			artifact.State = model.CLOSED_WITHOUT_DATA
-			artifact.S3URL = ""
+// updateArtifactState moves the artifact to given state (see model.Artifact.TransitionTo) and saves
+// it to the database.
+func updateArtifactState(db database.Database, artifact *model.Artifact, state model.ArtifactState) error {
+	if err := artifact.TransitionTo(state); err != nil {
+		return err
+	}
 
-			// Conversion between *DatabaseEror and error is tricky. If we don't do this, a nil
-			// *DatabaseError can become a non-nil error.
-			return db.UpdateArtifact(artifact).GetError()
-		}
+	// Conversion between *DatabaseEror and error is tricky. If we don't do this, a nil
+	// *DatabaseError can become a non-nil error.
+	if err := db.UpdateArtifact(artifact); err != nil {
+		return err
+	}
+	return nil
+}
 
-		// XXX Do we need to commit here or is this handled transparently?
-		artifact.State = model.UPLOADING
-		if err := db.UpdateArtifact(artifact); err != nil {
-			return err
-		}
+// uploadLogChunks merges the log chunks of an artifact which has been claimed for upload (and is
+// in UPLOADING state), uploads the result to the blob store and marks the artifact UPLOADED.
+func uploadLogChunks(ctx context.Context, artifact *model.Artifact, db database.Database, store storage.BlobStore) error {
+	fileName := artifact.DefaultS3URL()
 
-		fileName := artifact.DefaultS3URL()
+	r := newLogChunkReaderWithReadahead(artifact, db)
 
-		r := newLogChunkReaderWithReadahead(artifact, db)
+	stopHeartbeat := startUploadHeartbeat(db, artifact)
+	digest, err := uploadArtifactToS3(store, fileName, artifact.Size, r)
+	stopHeartbeat()
+	if err != nil {
+		return err
+	}
 
-		if err := uploadArtifactToS3(s3bucket, fileName, artifact.Size, r); err != nil {
-			return err
-		}
+	if err := artifact.TransitionTo(model.UPLOADED); err != nil {
+		return err
+	}
+	artifact.S3URL = fileName
+	artifact.Sha256 = digest
 
-		// XXX This is a long operation and should probably be asynchronous from the
-		// actual HTTP request, and the client should poll to check when its uploaded.
-		artifact.State = model.UPLOADED
-		artifact.S3URL = fileName
-		if err := db.UpdateArtifact(artifact); err != nil {
+	// Logchunks are deleted along with marking the artifact UPLOADED. If either fails, the artifact
+	// is left UPLOADING, and the merge is eventually retried (see StaleArtifactRecoverer).
+	return db.WithTx(func(tx database.Database) error {
+		if err := tx.UpdateArtifact(artifact); err != nil {
 			return err
 		}
 
-		// From this point onwards, we will not send back any errors back to the user. If we are
-		// unable to delete logchunks, we log it to Sentry instead.
-		if _, err := db.DeleteLogChunksForArtifact(artifact.Id); err != nil {
-			sentry.ReportError(ctx, err)
-			return nil
+		if _, err := tx.DeleteLogChunksForArtifact(artifact.Id); err != nil {
+			return err
 		}
-
 		return nil
+	})
+}
 
-	case model.WAITING_FOR_UPLOAD:
-		fallthrough
-	case model.ERROR:
-		fallthrough
-	case model.APPENDING:
-		fallthrough
-	case model.UPLOADED:
-		fallthrough
-	case model.UPLOADING:
-		return fmt.Errorf("Artifact can only be merged when in APPEND_COMPLETE state, but state is %s", artifact.State)
-	default:
-		return fmt.Errorf("Illegal artifact state! State code is %d", artifact.State)
+// ListArtifactEvents responds with the state transitions of an artifact, oldest first.
+func ListArtifactEvents(ctx context.Context, r render.Render, db database.Database, artifact *model.Artifact) {
+	if artifact == nil {
+		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No artifact specified")
+		return
 	}
+
+	events, err := db.ListArtifactEvents(artifact.Id)
+	if err != nil {
+		LogAndRespondWithError(ctx, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	r.JSON(http.StatusOK, events)
 }
 
 // HandleCloseArtifact handles the HTTP request to close an artifact. See CloseArtifact for details.
-func HandleCloseArtifact(ctx context.Context, r render.Render, db database.Database, s3bucket *s3.Bucket, artifact *model.Artifact) {
+func HandleCloseArtifact(ctx context.Context, r render.Render, db database.Database, artifact *model.Artifact) {
 	if artifact == nil {
 		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No artifact specified")
 		return
 	}
 
-	if err := CloseArtifact(ctx, artifact, db, s3bucket, true); err != nil {
-		LogAndRespondWithError(ctx, r, http.StatusBadRequest, err)
+	if err := CloseArtifact(ctx, artifact, db, true); err != nil {
+		LogAndRespondWithError(ctx, r, errorStatus(err, http.StatusBadRequest), err)
+		return
+	}
+
+	r.JSON(http.StatusOK, map[string]interface{}{})
+}
+
+// HandleDeleteArtifact handles the HTTP request to delete an artifact. See DeleteArtifact for
+// details.
+func HandleDeleteArtifact(ctx context.Context, r render.Render, db database.Database, store storage.BlobStore, artifact *model.Artifact) {
+	if artifact == nil {
+		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No artifact specified")
+		return
+	}
+
+	if err := DeleteArtifact(ctx, artifact, db, store); err != nil {
+		LogAndRespondWithError(ctx, r, err.errCode, err)
 		return
 	}
 
 	r.JSON(http.StatusOK, map[string]interface{}{})
 }
 
+// DeleteArtifact deletes an artifact along with its content (log chunks and blob store object).
+// Artifacts which are in the middle of being merged and uploaded can't be deleted, since the
+// upload would recreate the blob store object after it was deleted.
+//
+// Content is deleted before the artifact itself, so if deletion fails part way, the artifact is
+// still around and deletion can be retried.
+func DeleteArtifact(ctx context.Context, artifact *model.Artifact, db database.Database, store storage.BlobStore) *HttpError {
+	switch artifact.State {
+	case model.APPEND_COMPLETE, model.UPLOADING:
+		return NewHttpError(http.StatusConflict, "Artifact %s/%s is being uploaded and can't be deleted right now", artifact.BucketId, artifact.Name)
+	}
+
+	if artifact.S3URL != "" {
+		if err := store.Delete(artifact.S3URL); err != nil {
+			return NewHttpError(http.StatusInternalServerError, "Error deleting content of artifact %s/%s: %s", artifact.BucketId, artifact.Name, err)
+		}
+	}
+
+	if _, err := db.DeleteLogChunksForArtifact(artifact.Id); err != nil {
+		return NewWrappedHttpError(http.StatusInternalServerError, err)
+	}
+
+	if err := db.DeleteArtifact(artifact.Id); err != nil && !err.EntityNotFound() {
+		return NewWrappedHttpError(http.StatusInternalServerError, err)
+	}
+
+	artifactsDeletedCounter.Add(1)
+	return nil
+}
+
 func intParam(v url.Values, paramName string, fallback int64) int64 {
 	if value := v.Get(paramName); value != "" {
 		if intVal, err := strconv.Atoi(value); err == nil {
@@ -473,6 +854,35 @@ func getByteRangeFromRequest(req *http.Request, artifact *model.Artifact) (int64
 	return byteRangeBegin, byteRangeEnd, nil
 }
 
+// waitForArtifactChange waits until the state or size of an artifact changes, for at most wait or
+// until closed is signalled, and returns the artifact as of then.
+func waitForArtifactChange(db database.Database, broker *ArtifactBroker, artifact *model.Artifact, wait time.Duration, closed <-chan bool) (*model.Artifact, *database.DatabaseError) {
+	changed, cancel := broker.Subscribe(artifact.Id)
+	defer cancel()
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	// The artifact may have changed before the subscription began, so check it first.
+	for {
+		latest, err := db.GetArtifactByName(artifact.BucketId, artifact.Name)
+		if err != nil {
+			return nil, err
+		}
+		if latest.State != artifact.State || latest.Size != artifact.Size {
+			return latest, nil
+		}
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return latest, nil
+		case <-closed:
+			return latest, nil
+		}
+	}
+}
+
 // GetArtifactContentChunks lists artifact contents in a chunked form. Useful to poll for updates to
 // chunked artifacts. All artifact types are supported and chunks can be requested from arbitrary
 // locations within artifacts.
@@ -485,12 +895,41 @@ func getByteRangeFromRequest(req *http.Request, artifact *model.Artifact) (int64
 // limit  -> number of bytes to be fetched (defaults to 100KB)
 //
 // Negative values for any query parameter will cause it to be set to 0 (default)
-func GetArtifactContentChunks(ctx context.Context, r render.Render, req *http.Request, res http.ResponseWriter, db database.Database, s3bucket *s3.Bucket, artifact *model.Artifact) {
+//
+// Clients which have already read all content of an APPENDING artifact can long-poll for more, by
+// passing a duration (such as "30s", capped at MaxChunkedWait) in the wait query parameter. The
+// response is then delayed until the artifact changes or the duration elapses.
+func GetArtifactContentChunks(ctx context.Context, r render.Render, req *http.Request, res http.ResponseWriter, db database.Database, store storage.BlobStore, broker *ArtifactBroker, artifact *model.Artifact) {
 	if artifact == nil {
 		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No artifact specified")
 		return
 	}
 
+	if waitStr := req.URL.Query().Get("wait"); waitStr != "" {
+		wait, err := time.ParseDuration(waitStr)
+		if err != nil || wait < 0 {
+			RespondWithErrorf(ctx, r, http.StatusBadRequest, "Invalid wait duration %q", waitStr)
+			return
+		}
+
+		if wait > MaxChunkedWait {
+			wait = MaxChunkedWait
+		}
+
+		if artifact.State == model.APPENDING && intParam(req.URL.Query(), "offset", 0) == artifact.Size {
+			var closed <-chan bool
+			if cn, ok := res.(http.CloseNotifier); ok {
+				closed = cn.CloseNotify()
+			}
+
+			var dbErr *database.DatabaseError
+			if artifact, dbErr = waitForArtifactChange(db, broker, artifact, wait, closed); dbErr != nil {
+				LogAndRespondWithError(ctx, r, http.StatusInternalServerError, dbErr)
+				return
+			}
+		}
+	}
+
 	type Chunk struct {
 		ID     int64  `json:"id"`
 		Offset int64  `json:"offset"`
@@ -512,6 +951,13 @@ func GetArtifactContentChunks(ctx context.Context, r render.Render, req *http.Re
 		return
 	}
 
+	// Chunks of uploaded artifacts never change, so clients can cache them. Each byte range is a
+	// different response, with its own ETag.
+	if artifact.State == model.UPLOADED && setUploadedArtifactCacheHeaders(req, res, artifact, uploadedArtifactRangeETag(artifact, byteRangeBegin, byteRangeEnd)) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	switch artifact.State {
 	case model.UPLOADING:
 		// No data to report right now. Wait till upload to S3 completes.
@@ -521,25 +967,15 @@ func GetArtifactContentChunks(ctx context.Context, r render.Render, req *http.Re
 		r.JSON(http.StatusOK, &Result{Chunks: []Chunk{}, NextOffset: byteRangeBegin})
 		return
 	case model.UPLOADED:
-		// Fetch from S3
-		url := s3bucket.SignedURL(artifact.S3URL, time.Now().Add(30*time.Minute))
-		rq, err := http.NewRequest("GET", url, nil)
+		// Fetch from blob store
+		rc, err := storage.GetVersionRange(store, artifactBlob(artifact), byteRangeBegin, byteRangeEnd)
 		if err != nil {
 			LogAndRespondWithError(ctx, r, http.StatusInternalServerError, err)
 			return
 		}
-		rq.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", byteRangeBegin, byteRangeEnd))
-		resp, err := http.DefaultClient.Do(rq)
-		if err != nil {
-			LogAndRespondWithError(ctx, r, http.StatusInternalServerError, err)
-			return
-		}
-		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-			LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, fmt.Sprintf("Bad status code %d", resp.StatusCode))
-			return
-		}
+		defer rc.Close()
 		var buf bytes.Buffer
-		n, err := buf.ReadFrom(resp.Body)
+		n, err := buf.ReadFrom(rc)
 		if err != nil {
 			LogAndRespondWithError(ctx, r, http.StatusInternalServerError, err)
 			return
@@ -578,7 +1014,7 @@ func GetArtifactContentChunks(ctx context.Context, r render.Render, req *http.Re
 	}
 }
 
-func GetArtifactContent(ctx context.Context, r render.Render, req *http.Request, res http.ResponseWriter, db database.Database, s3bucket *s3.Bucket, artifact *model.Artifact) {
+func GetArtifactContent(ctx context.Context, r render.Render, req *http.Request, res http.ResponseWriter, db database.Database, store storage.BlobStore, artifact *model.Artifact) {
 	if artifact == nil {
 		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No artifact specified")
 		return
@@ -586,32 +1022,24 @@ func GetArtifactContent(ctx context.Context, r render.Render, req *http.Request,
 
 	switch artifact.State {
 	case model.UPLOADED:
-		// Fetch from S3
-		url := s3bucket.SignedURL(artifact.S3URL, time.Now().Add(30*time.Minute))
-		rq, err := http.NewRequest("GET", url, nil)
-		if byteRanges := req.Header.Get("Range"); byteRanges != "" {
-			// If request contains Range: headers, pass them right through to S3.
-			// TODO(anupc): Validation? We're sending user input through to the data store.
-			rq.Header.Add("Range", byteRanges)
-		}
-		resp, err := http.DefaultClient.Do(rq)
-		if err != nil {
-			LogAndRespondWithError(ctx, r, http.StatusInternalServerError, err)
-			return
-		}
-		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-			LogAndRespondWithErrorf(ctx, r, http.StatusInternalServerError, fmt.Sprintf("Bad status code %d recieved from S3", resp.StatusCode))
-			return
-		}
+		// Fetch from blob store. Range requests are handled by http.ServeContent, which only fetches
+		// the requested byte ranges from the blob store.
 		contentdisposition.SetFilename(res, filepath.Base(artifact.RelativePath))
-		res.Header().Add("Content-Length", strconv.Itoa(int(artifact.Size)))
-		if n, err := io.CopyN(res, resp.Body, artifact.Size); err != nil {
-			sentry.ReportError(ctx, fmt.Errorf("Error transferring artifact (for artifact %s/%s, bytes (%d/%d) read): %s", artifact.BucketId, artifact.Name, n, artifact.Size, err))
-			return
+		if artifact.Sha256 != "" {
+			res.Header().Set(DigestHeader, formatSha256Digest(artifact.Sha256))
+		}
+		// Content of uploaded artifacts never changes. http.ServeContent handles conditional requests
+		// against the ETag and modification time.
+		res.Header().Set("ETag", uploadedArtifactETag(artifact))
+		br := newBlobReader(store, artifactBlob(artifact))
+		defer br.Close()
+		http.ServeContent(res, req, filepath.Base(artifact.RelativePath), artifact.DateUpdated, br)
+		if br.err != nil {
+			sentry.ReportError(ctx, fmt.Errorf("Error transferring artifact (for artifact %s/%s, bytes (%d/%d) read): %s", artifact.BucketId, artifact.Name, br.offset, artifact.Size, br.err))
 		}
 		return
 	case model.UPLOADING:
-		// Not done uploading to S3 yet. Error.
+		// Not done uploading to blob store yet. Error.
 		LogAndRespondWithErrorf(ctx, r, http.StatusNotFound, "Waiting for content to complete uploading")
 		return
 	case model.APPENDING:
@@ -630,40 +1058,50 @@ func GetArtifactContent(ctx context.Context, r render.Render, req *http.Request,
 	}
 }
 
-func uploadArtifactToS3(bucket *s3.Bucket, artifactName string, artifactSize int64, contentReader io.ReadSeeker) error {
+// uploadArtifactToS3 uploads content to the blob store, retrying the whole upload up to
+// MaxUploadAttempts times. Large objects are uploaded to S3 in parts, and individual parts are
+// retried by the store itself, so a whole-object retry only happens if a part repeatedly fails.
+//
+// Returns the hex encoded SHA-256 digest of the uploaded content.
+func uploadArtifactToS3(store storage.BlobStore, artifactName string, artifactSize int64, contentReader io.ReadSeeker) (string, error) {
 	attempts := 0
 
 	for {
 		attempts++
 		// Rewind Seeker to beginning, required if we had already read a few bytes from it before.
 		if _, err := contentReader.Seek(0, os.SEEK_SET); err != nil {
-			return err
+			return "", err
 		}
 
-		if err := bucket.PutReader(artifactName, contentReader, artifactSize, "binary/octet-stream", s3.PublicRead); err != nil {
+		h := sha256.New()
+		if err := store.Put(artifactName, io.TeeReader(contentReader, h), artifactSize); err != nil {
 			if attempts < MaxUploadAttempts {
 				log.Printf("[Attempt %d/%d] Error uploading to S3: %s", attempts, MaxUploadAttempts, err)
 				continue
 			}
-			return fmt.Errorf("Error uploading to S3: %s", err)
+			return "", fmt.Errorf("Error uploading to S3: %s", err)
 		}
 
 		bytesUploadedCounter.Add(artifactSize)
-		return nil
+		return hex.EncodeToString(h.Sum(nil)), nil
 	}
 
-	return nil // This should never happen - only here to satisfy the compiler
+	return "", nil // This should never happen - only here to satisfy the compiler
 }
 
 type PutArtifactReq struct {
 	ContentLength string
 	Body          io.Reader
+	// Hex encoded SHA-256 digest of the content, if supplied by the client. If set, the upload is
+	// rejected unless the content matches.
+	ExpectedSha256 string
 }
 
-// PutArtifact writes a streamed artifact to S3. The entire file contents are streamed directly
-// through to S3. If S3 is not accessible, we don't make any attempt to buffer on disk and fail
-// immediately.
-func PutArtifact(ctx context.Context, artifact *model.Artifact, db database.Database, bucket *s3.Bucket, req PutArtifactReq) error {
+// PutArtifact writes a streamed artifact to the blob store. The file contents are first spooled to
+// a temporary file in UploadSpoolDir (so that memory use doesn't depend on artifact size, and the
+// upload can be retried), and then sent to the blob store. If the blob store is not accessible, we
+// fail immediately.
+func PutArtifact(ctx context.Context, artifact *model.Artifact, db database.Database, store storage.BlobStore, req PutArtifactReq) error {
 	if artifact.State != model.WAITING_FOR_UPLOAD {
 		return fmt.Errorf("Expected artifact to be in state WAITING_FOR_UPLOAD: %s", artifact.State)
 	}
@@ -690,20 +1128,23 @@ func PutArtifact(ctx context.Context, artifact *model.Artifact, db database.Data
 		return fmt.Errorf("Content length %d does not match expected file size %d", fileSize, artifact.Size)
 	}
 
-	artifact.State = model.UPLOADING
-	if err := db.UpdateArtifact(artifact); err != nil {
+	if err := updateArtifactState(db, artifact, model.UPLOADING); err != nil {
 		return err
 	}
 
+	// Spooling and uploading can take a long time.
+	stopHeartbeat := startUploadHeartbeat(db, artifact)
+	defer stopHeartbeat()
+
 	cleanupAndReturn := func(err error) error {
 		// TODO: Is there a better way to detect and handle errors?
 		// Use a channel to signify upload completion. In defer, check if the channel is empty. If
 		// yes, mark error. Else ignore.
 		if err != nil {
+			stopHeartbeat()
 			// TODO: s/ERROR/WAITING_FOR_UPLOAD/ ?
 			sentry.ReportError(ctx, err)
-			artifact.State = model.ERROR
-			err2 := db.UpdateArtifact(artifact)
+			err2 := updateArtifactState(db, artifact, model.ERROR)
 			if err2 != nil {
 				log.Printf("Error while handling error: %s", err2.Error())
 			}
@@ -713,19 +1154,38 @@ func PutArtifact(ctx context.Context, artifact *model.Artifact, db database.Data
 		return nil
 	}
 
-	b := new(bytes.Buffer)
-	// Note: Storing entire contents of uploaded artifact in memory can cause OOMS.
-	if n, err := io.CopyN(b, req.Body, artifact.Size); err != nil {
+	spool, err := ioutil.TempFile(UploadSpoolDir, "artifact-upload-")
+	if err != nil {
+		return cleanupAndReturn(fmt.Errorf("Error creating spool file (for artifact %s/%s): %s", artifact.BucketId, artifact.Name, err))
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	h := sha256.New()
+	if n, err := io.CopyN(io.MultiWriter(spool, h), req.Body, artifact.Size); err != nil {
 		return cleanupAndReturn(fmt.Errorf("Error reading from request body (for artifact %s/%s, bytes (%d/%d) read): %s", artifact.BucketId, artifact.Name, n, artifact.Size, err))
 	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	if req.ExpectedSha256 != "" && req.ExpectedSha256 != digest {
+		artifact.ErrorReason = "Uploaded content does not match expected SHA-256 digest"
+		return cleanupAndReturn(NewHttpError(http.StatusBadRequest, "SHA-256 digest of uploaded content %s does not match expected digest %s (for artifact %s/%s)", digest, req.ExpectedSha256, artifact.BucketId, artifact.Name))
+	}
+
 	fileName := artifact.DefaultS3URL()
 
-	if err := uploadArtifactToS3(bucket, fileName, artifact.Size, bytes.NewReader(b.Bytes())); err != nil {
+	if _, err := uploadArtifactToS3(store, fileName, artifact.Size, spool); err != nil {
 		return cleanupAndReturn(err)
 	}
 
-	artifact.State = model.UPLOADED
+	stopHeartbeat()
+	if err := artifact.TransitionTo(model.UPLOADED); err != nil {
+		return err
+	}
 	artifact.S3URL = fileName
+	artifact.Sha256 = digest
 	if err := db.UpdateArtifact(artifact); err != nil {
 		return err
 	}
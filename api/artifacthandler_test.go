@@ -3,9 +3,14 @@ package api
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -16,10 +21,14 @@ import (
 	"github.com/dropbox/changes-artifacts/common/sentry"
 	"github.com/dropbox/changes-artifacts/database"
 	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
+// SHA-256 digest of "0123456789", the content uploaded by most tests.
+const testContentSha256 = "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"
+
 func getS3Bucket(t *testing.T, url string, doCreate bool) *s3.Bucket {
 	s3Client := s3.New(aws.Auth{AccessKey: "abc", SecretKey: "123"}, aws.Region{
 		Name:                 "fake-artifacts-test-region",
@@ -38,14 +47,14 @@ func getS3Bucket(t *testing.T, url string, doCreate bool) *s3.Bucket {
 	return s3Bucket
 }
 
-func fakeS3ServerWithBucket(t *testing.T, f http.HandlerFunc) (*httptest.Server, *s3.Bucket) {
+func fakeS3ServerWithBucket(t *testing.T, f http.HandlerFunc) (*httptest.Server, storage.BlobStore) {
 	ts := httptest.NewServer(http.HandlerFunc(f))
 	t.Logf("Fake S3 Server up at %s\n", ts.URL)
 
-	return ts, getS3Bucket(t, ts.URL, false)
+	return ts, storage.NewS3BlobStore(getS3Bucket(t, ts.URL, false))
 }
 
-func testS3ServerWithBucket(t *testing.T) (*s3test.Server, *s3.Bucket) {
+func testS3ServerWithBucket(t *testing.T) (*s3test.Server, storage.BlobStore) {
 	s3Server, err := s3test.NewServer(&s3test.Config{Send409Conflict: true})
 	if err != nil {
 		t.Fatalf("Error bringing up fake s3 server: %s\n", err)
@@ -53,7 +62,14 @@ func testS3ServerWithBucket(t *testing.T) (*s3test.Server, *s3.Bucket) {
 
 	t.Logf("S3 Test Server up at %s\n", s3Server.URL())
 
-	return s3Server, getS3Bucket(t, s3Server.URL(), true)
+	return s3Server, storage.NewS3BlobStore(getS3Bucket(t, s3Server.URL(), true))
+}
+
+// mockTxs makes the mock database run WithTx callbacks directly on itself.
+func mockTxs(mockdb *database.MockDatabase) {
+	mockdb.On("WithTx", mock.AnythingOfType("func(database.Database) error")).Return(func(f func(database.Database) error) error {
+		return f(mockdb)
+	})
 }
 
 func TestCreateArtifact(t *testing.T) {
@@ -241,8 +257,88 @@ func getLogChunkToAppend() *createLogChunkReq {
 	}
 }
 
+func TestArtifactFilterFromQuery(t *testing.T) {
+	filter, err := artifactFilterFromQuery(url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, database.ArtifactFilter{}, filter)
+
+	cursor := encodeCursor(artifactListCursor{Sort: "-size", ArtifactCursor: database.ArtifactCursor{Size: 10, Id: 3}})
+	filter, err = artifactFilterFromQuery(url.Values{
+		"state":              {"UPLOADED"},
+		"namePrefix":         {"test_"},
+		"nameGlob":           {"*.xml"},
+		"relativePathPrefix": {"out/"},
+		"relativePathGlob":   {"out/?/*"},
+		"sort":               {"-size"},
+		"cursor":             {cursor},
+		"limit":              {"10"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, database.ArtifactFilter{
+		State:              model.UPLOADED,
+		NamePrefix:         "test_",
+		NameGlob:           "*.xml",
+		RelativePathPrefix: "out/",
+		RelativePathGlob:   "out/?/*",
+		SortBy:             database.SortArtifactsBySize,
+		Descending:         true,
+		Cursor:             &database.ArtifactCursor{Size: 10, Id: 3},
+		Limit:              10,
+	}, filter)
+
+	filter, err = artifactFilterFromQuery(url.Values{"sort": {"dateCreated"}})
+	require.NoError(t, err)
+	require.Equal(t, database.SortArtifactsByDateCreated, filter.SortBy)
+	require.False(t, filter.Descending)
+
+	for _, query := range []url.Values{
+		{"state": {"BOGUS"}},
+		{"sort": {"bogus"}},
+		{"sort": {"-"}},
+		{"cursor": {"not a cursor"}},
+		// Cursor from a listing with a different sort order.
+		{"cursor": {cursor}, "sort": {"size"}},
+		{"limit": {"0"}},
+		{"limit": {fmt.Sprint(MaxPageSize + 1)}},
+	} {
+		_, err = artifactFilterFromQuery(query)
+		require.Error(t, err, "Query %v should be rejected", query)
+	}
+}
+
+func TestParseLogChunkReq(t *testing.T) {
+	newReq := func(url string, contentType string, body string) *http.Request {
+		req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+
+	// Raw chunk
+	logChunkReq, err := parseLogChunkReq(newReq("/buckets/b/artifacts/a?offset=10", "application/octet-stream", "\x00\xffab"))
+	require.NoError(t, err)
+	require.Equal(t, &createLogChunkReq{ByteOffset: 10, Size: 4, Bytes: []byte("\x00\xffab")}, logChunkReq)
+
+	// Raw chunk without a valid offset
+	_, err = parseLogChunkReq(newReq("/buckets/b/artifacts/a", "application/octet-stream", "ab"))
+	require.Error(t, err)
+	_, err = parseLogChunkReq(newReq("/buckets/b/artifacts/a?offset=x", "application/octet-stream", "ab"))
+	require.Error(t, err)
+
+	// JSON encoded chunk, with or without Content-Type
+	for _, contentType := range []string{"application/json", ""} {
+		logChunkReq, err = parseLogChunkReq(newReq("/buckets/b/artifacts/a", contentType, `{"byteoffset": 10, "size": 2, "bytes": "YWI="}`))
+		require.NoError(t, err)
+		require.Equal(t, &createLogChunkReq{ByteOffset: 10, Size: 2, Bytes: []byte("ab")}, logChunkReq)
+	}
+
+	_, err = parseLogChunkReq(newReq("/buckets/b/artifacts/a", "application/json", "ab"))
+	require.Error(t, err)
+}
+
 func TestAppendLogChunk(t *testing.T) {
 	mockdb := &database.MockDatabase{}
+	mockTxs(mockdb)
 
 	// Already completed artifact
 	require.Error(t, AppendLogChunk(context.Background(), mockdb, &model.Artifact{
@@ -289,11 +385,23 @@ func TestAppendLogChunk(t *testing.T) {
 	}, getLogChunkToAppend()))
 
 	mockdb.On("UpdateArtifact", mock.AnythingOfType("*model.Artifact")).Return(database.MockDatabaseError()).Once()
-	require.Error(t, AppendLogChunk(context.Background(), mockdb, &model.Artifact{
+	err := AppendLogChunk(context.Background(), mockdb, &model.Artifact{
 		State: model.APPENDING,
 		Id:    10,
 		Size:  0,
-	}, getLogChunkToAppend()))
+	}, getLogChunkToAppend())
+	require.Error(t, err)
+	require.Equal(t, http.StatusInternalServerError, err.errCode)
+
+	// Artifact was appended to or closed concurrently.
+	mockdb.On("UpdateArtifact", mock.AnythingOfType("*model.Artifact")).Return(database.NewConflictError("Conflict")).Once()
+	err = AppendLogChunk(context.Background(), mockdb, &model.Artifact{
+		State: model.APPENDING,
+		Id:    10,
+		Size:  0,
+	}, getLogChunkToAppend())
+	require.Error(t, err)
+	require.Equal(t, http.StatusConflict, err.errCode)
 
 	mockdb.On("UpdateArtifact", mock.AnythingOfType("*model.Artifact")).Return(nil).Once()
 	mockdb.On("InsertLogChunk", getExpectedLogChunkToBeWritten()).Return(database.MockDatabaseError()).Once()
@@ -314,6 +422,84 @@ func TestAppendLogChunk(t *testing.T) {
 	mockdb.AssertExpectations(t)
 }
 
+func TestParseLogChunksReq(t *testing.T) {
+	newReq := func(url string, body string) *http.Request {
+		req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		return req
+	}
+
+	batch, err := parseLogChunksReq(newReq("/chunks?offset=10&sizes=2,1,3", "abcdef"))
+	require.NoError(t, err)
+	require.Equal(t, &appendLogChunksReq{ByteOffset: 10, Chunks: [][]byte{[]byte("ab"), []byte("c"), []byte("def")}}, batch)
+
+	// Sizes must be positive and add up to the content length.
+	for _, url := range []string{
+		"/chunks?sizes=2,1,3",
+		"/chunks?offset=10",
+		"/chunks?offset=10&sizes=2,x,3",
+		"/chunks?offset=10&sizes=2,0,4",
+		"/chunks?offset=10&sizes=2,1",
+		"/chunks?offset=10&sizes=2,1,4",
+	} {
+		_, err := parseLogChunksReq(newReq(url, "abcdef"))
+		require.Error(t, err, url)
+	}
+
+	// Too many chunks
+	sizes := strings.TrimSuffix(strings.Repeat("1,", MaxLogChunksPerBatch+1), ",")
+	_, err = parseLogChunksReq(newReq("/chunks?offset=0&sizes="+sizes, strings.Repeat("a", MaxLogChunksPerBatch+1)))
+	require.Error(t, err)
+}
+
+func TestAppendLogChunks(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockTxs(mockdb)
+	batch := &appendLogChunksReq{ByteOffset: 2, Chunks: [][]byte{[]byte("cd"), []byte("e")}}
+	expectedLogChunks := []model.LogChunk{
+		{ArtifactId: 10, ByteOffset: 2, Size: 2, ContentBytes: []byte("cd")},
+		{ArtifactId: 10, ByteOffset: 4, Size: 1, ContentBytes: []byte("e")},
+	}
+
+	// Invalid artifact state or batch
+	require.Error(t, AppendLogChunks(context.Background(), mockdb, &model.Artifact{State: model.APPEND_COMPLETE, Size: 2}, batch))
+	require.Error(t, AppendLogChunks(context.Background(), mockdb, &model.Artifact{State: model.APPENDING, Size: 2}, &appendLogChunksReq{ByteOffset: 2}))
+	require.Error(t, AppendLogChunks(context.Background(), mockdb, &model.Artifact{State: model.APPENDING, Size: 2}, &appendLogChunksReq{ByteOffset: 2, Chunks: [][]byte{[]byte("cd"), []byte{}}}))
+
+	// Batch doesn't start at the end of the artifact.
+	require.Error(t, AppendLogChunks(context.Background(), mockdb, &model.Artifact{Id: 10, State: model.APPENDING, Size: 3}, batch))
+
+	// Last batch was repeated.
+	mockdb.On("ListLogChunksInArtifact", int64(10), int64(2), int64(5)).Return(append([]model.LogChunk{
+		{ArtifactId: 10, ByteOffset: 0, Size: 2, ContentBytes: []byte("ab")},
+	}, expectedLogChunks...), nil).Once()
+	require.Nil(t, AppendLogChunks(context.Background(), mockdb, &model.Artifact{Id: 10, State: model.APPENDING, Size: 5}, batch))
+
+	// Different content at the same position
+	mockdb.On("ListLogChunksInArtifact", int64(10), int64(2), int64(5)).Return([]model.LogChunk{
+		{ArtifactId: 10, ByteOffset: 2, Size: 3, ContentBytes: []byte("cde")},
+	}, nil).Once()
+	require.Error(t, AppendLogChunks(context.Background(), mockdb, &model.Artifact{Id: 10, State: model.APPENDING, Size: 5}, batch))
+
+	// Artifact was appended to concurrently.
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 10, State: model.APPENDING, Size: 5}).Return(database.NewConflictError("Conflict")).Once()
+	err := AppendLogChunks(context.Background(), mockdb, &model.Artifact{Id: 10, State: model.APPENDING, Size: 2}, batch)
+	require.Error(t, err)
+	require.Equal(t, http.StatusConflict, err.errCode)
+
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 10, State: model.APPENDING, Size: 5}).Return(nil).Once()
+	mockdb.On("InsertLogChunks", expectedLogChunks).Return(database.MockDatabaseError()).Once()
+	require.Error(t, AppendLogChunks(context.Background(), mockdb, &model.Artifact{Id: 10, State: model.APPENDING, Size: 2}, batch))
+
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 10, State: model.APPENDING, Size: 5}).Return(nil).Once()
+	mockdb.On("InsertLogChunks", expectedLogChunks).Return(nil).Once()
+	artifact := &model.Artifact{Id: 10, State: model.APPENDING, Size: 2}
+	require.Nil(t, AppendLogChunks(context.Background(), mockdb, artifact, batch))
+	require.Equal(t, int64(5), artifact.Size)
+
+	mockdb.AssertExpectations(t)
+}
+
 func TestPutArtifactErrorChecks(t *testing.T) {
 	// Chunked artifacts
 	require.Error(t, PutArtifact(context.Background(), &model.Artifact{State: model.APPENDING}, nil, nil, PutArtifactReq{}))
@@ -353,6 +539,7 @@ func TestPutArtifactToS3Successfully(t *testing.T) {
 	// Then change to UPLOADED state.
 	mockdb.On("UpdateArtifact", &model.Artifact{
 		State:    model.UPLOADED,
+		Sha256:   testContentSha256,
 		Size:     10,
 		S3URL:    "/TestPutArtifact__bucketName/TestPutArtifact__artifactName",
 		Name:     "TestPutArtifact__artifactName",
@@ -408,6 +595,123 @@ func TestPutArtifactShortWrite(t *testing.T) {
 	s3Server.Quit()
 }
 
+func TestPutArtifactRemovesSpoolFile(t *testing.T) {
+	spoolDir, err := ioutil.TempDir("", "artifacts-spool-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(spoolDir)
+
+	defer func(dir string) { UploadSpoolDir = dir }(UploadSpoolDir)
+	UploadSpoolDir = spoolDir
+
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	mockdb := &database.MockDatabase{}
+	mockdb.On("UpdateArtifact", mock.Anything).Return(nil)
+
+	require.NoError(t, PutArtifact(context.Background(), &model.Artifact{
+		State:    model.WAITING_FOR_UPLOAD,
+		Size:     10,
+		Name:     "artifactName",
+		BucketId: "bucketName",
+	}, mockdb, store, PutArtifactReq{
+		ContentLength: "10",
+		Body:          bytes.NewBufferString("0123456789"),
+	}))
+
+	files, err := ioutil.ReadDir(spoolDir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	// Short write
+	require.Error(t, PutArtifact(context.Background(), &model.Artifact{
+		State:    model.WAITING_FOR_UPLOAD,
+		Size:     10,
+		Name:     "shortArtifactName",
+		BucketId: "bucketName",
+	}, mockdb, store, PutArtifactReq{
+		ContentLength: "10",
+		Body:          bytes.NewBufferString("012345678"),
+	}))
+
+	files, err = ioutil.ReadDir(spoolDir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestPutArtifactWithExpectedDigest(t *testing.T) {
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	mockdb := &database.MockDatabase{}
+	mockdb.On("UpdateArtifact", mock.Anything).Return(nil)
+
+	artifact := &model.Artifact{
+		State:    model.WAITING_FOR_UPLOAD,
+		Size:     10,
+		Name:     "artifactName",
+		BucketId: "bucketName",
+	}
+	require.NoError(t, PutArtifact(context.Background(), artifact, mockdb, store, PutArtifactReq{
+		ContentLength:  "10",
+		Body:           bytes.NewBufferString("0123456789"),
+		ExpectedSha256: testContentSha256,
+	}))
+	require.Equal(t, model.UPLOADED, artifact.State)
+	require.Equal(t, testContentSha256, artifact.Sha256)
+
+	// Content doesn't match expected digest
+	artifact = &model.Artifact{
+		State:    model.WAITING_FOR_UPLOAD,
+		Size:     10,
+		Name:     "corruptArtifactName",
+		BucketId: "bucketName",
+	}
+	err := PutArtifact(context.Background(), artifact, mockdb, store, PutArtifactReq{
+		ContentLength:  "10",
+		Body:           bytes.NewBufferString("012345678X"),
+		ExpectedSha256: testContentSha256,
+	})
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadRequest, err.(*HttpError).errCode)
+	require.Equal(t, model.ERROR, artifact.State)
+	require.Equal(t, "", artifact.Sha256)
+
+	// Nothing was uploaded
+	_, err = store.Get(artifact.DefaultS3URL())
+	require.Equal(t, storage.ErrNotExist, err)
+}
+
+func TestGetArtifactContentDigestHeaders(t *testing.T) {
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	require.NoError(t, store.Put("/bucketName/artifactName", bytes.NewBufferString("0123456789"), 10))
+	artifact := &model.Artifact{
+		State:        model.UPLOADED,
+		Size:         10,
+		Name:         "artifactName",
+		BucketId:     "bucketName",
+		S3URL:        "/bucketName/artifactName",
+		RelativePath: "artifactName",
+		Sha256:       testContentSha256,
+	}
+
+	req, _ := http.NewRequest("GET", "/content", nil)
+	w := httptest.NewRecorder()
+	GetArtifactContent(context.Background(), nil, req, w, nil, store, artifact)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "0123456789", w.Body.String())
+	require.Equal(t, `"`+testContentSha256+`"`, w.Header().Get("ETag"))
+	require.Equal(t, "SHA-256=hNiYd/DUBB77a/kaFvAkjy/Vc+avBcGflr7bn4gveII=", w.Header().Get(DigestHeader))
+
+	// Conditional request for content which hasn't changed
+	req.Header.Set("If-None-Match", `"`+testContentSha256+`"`)
+	w = httptest.NewRecorder()
+	GetArtifactContent(context.Background(), nil, req, w, nil, store, artifact)
+	require.Equal(t, http.StatusNotModified, w.Code)
+}
+
 func TestPutArtifactToS3WithS3Errors(t *testing.T) {
 	mockdb := &database.MockDatabase{}
 
@@ -459,6 +763,7 @@ func TestPutArtifactToS3WithRetries(t *testing.T) {
 	// Then change to UPLOADED state.
 	mockdb.On("UpdateArtifact", &model.Artifact{
 		State:    model.UPLOADED,
+		Sha256:   testContentSha256,
 		Size:     10,
 		Name:     "TestPutArtifact__artifactName",
 		BucketId: "TestPutArtifact__bucketName",
@@ -491,82 +796,70 @@ func TestPutArtifactToS3WithRetries(t *testing.T) {
 	s3Server.Close()
 }
 
-func TestMergeLogChunks(t *testing.T) {
+func TestClaimArtifactForMerge(t *testing.T) {
 	mockdb := &database.MockDatabase{}
 
-	// Merging log chunks not valid in following states
-	require.Error(t, MergeLogChunks(nil, &model.Artifact{State: model.WAITING_FOR_UPLOAD}, mockdb, nil))
-	require.Error(t, MergeLogChunks(nil, &model.Artifact{State: model.APPENDING}, mockdb, nil))
-	require.Error(t, MergeLogChunks(nil, &model.Artifact{State: model.UPLOADED}, mockdb, nil))
-	require.Error(t, MergeLogChunks(nil, &model.Artifact{State: model.UPLOADING}, mockdb, nil))
+	// Empty artifacts are closed without being merged.
+	mockdb.On("CompareAndSwapArtifactState", int64(1), model.APPEND_COMPLETE, model.CLOSED_WITHOUT_DATA).Return(int64(2), nil).Once()
+	artifact := &model.Artifact{Id: 1, State: model.APPEND_COMPLETE}
+	claimed, err := claimArtifactForMerge(mockdb, artifact)
+	require.True(t, claimed)
+	require.NoError(t, err)
+	require.Equal(t, &model.Artifact{Id: 1, State: model.CLOSED_WITHOUT_DATA, Version: 2}, artifact)
+	// Nothing left to do.
+	require.NoError(t, mergeClaimedArtifact(nil, artifact, mockdb, nil))
+
+	// DB error while claiming.
+	mockdb.On("CompareAndSwapArtifactState", int64(2), model.APPEND_COMPLETE, model.UPLOADING).Return(int64(0), database.MockDatabaseError()).Once()
+	claimed, err = claimArtifactForMerge(mockdb, &model.Artifact{Id: 2, State: model.APPEND_COMPLETE, Size: 10})
+	require.False(t, claimed)
+	require.Error(t, err)
+
+	// Artifact no longer in APPEND_COMPLETE state (for example, claimed by another worker).
+	mockdb.On("CompareAndSwapArtifactState", int64(3), model.APPEND_COMPLETE, model.UPLOADING).Return(int64(0), database.NewConflictError("Conflict")).Once()
+	artifact = &model.Artifact{Id: 3, State: model.APPEND_COMPLETE, Size: 10}
+	claimed, err = claimArtifactForMerge(mockdb, artifact)
+	require.False(t, claimed)
+	require.NoError(t, err)
+	require.Equal(t, model.APPEND_COMPLETE, artifact.State)
 
-	// Closing an empty artifact with no errors
-	mockdb.On("UpdateArtifact", &model.Artifact{
-		State: model.CLOSED_WITHOUT_DATA,
-	}).Return(nil).Once()
-	require.NoError(t, MergeLogChunks(nil, &model.Artifact{State: model.APPEND_COMPLETE, Size: 0}, mockdb, nil))
-
-	// Closing an empty artifact with db errors
-	mockdb.On("UpdateArtifact", &model.Artifact{
-		State: model.CLOSED_WITHOUT_DATA,
-	}).Return(database.MockDatabaseError()).Once()
-	require.Error(t, MergeLogChunks(nil, &model.Artifact{State: model.APPEND_COMPLETE, Size: 0}, mockdb, nil))
+	mockdb.AssertExpectations(t)
+}
 
-	// ----- BEGIN Closing an artifact with some log chunks
-	// DB Error while updating artifact
-	mockdb.On("UpdateArtifact", &model.Artifact{
-		State: model.UPLOADING,
-		Size:  10,
-	}).Return(database.MockDatabaseError()).Once()
-	require.Error(t, MergeLogChunks(nil, &model.Artifact{State: model.APPEND_COMPLETE, Size: 10}, mockdb, nil))
+func TestMergeClaimedArtifact(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockTxs(mockdb)
 
 	{
 		// DB Error while fetching logchunks
-		mockdb.On("UpdateArtifact", &model.Artifact{
-			Id:    2,
-			State: model.UPLOADING,
-			Size:  10,
-		}).Return(nil).Once()
 		mockdb.On("ListLogChunksInArtifact", int64(2), int64(0), int64(10)).Return(nil, database.MockDatabaseError()).Times(MaxUploadAttempts)
 		s3Server, s3Bucket := testS3ServerWithBucket(t)
-		require.Error(t, MergeLogChunks(nil, &model.Artifact{Id: 2, State: model.APPEND_COMPLETE, Size: 10}, mockdb, s3Bucket))
+		require.Error(t, mergeClaimedArtifact(nil, &model.Artifact{Id: 2, State: model.UPLOADING, Size: 10}, mockdb, s3Bucket))
 		s3Server.Quit()
 	}
 
 	{
 		// Stitching chunks succeeds, but uploading to S3 fails
-		mockdb.On("UpdateArtifact", &model.Artifact{
-			Id:       2,
-			State:    model.UPLOADING,
-			Size:     10,
-			Name:     "TestMergeLogChunks__artifactName",
-			BucketId: "TestMergeLogChunks__bucketName",
-		}).Return(nil).Once()
 		mockdb.On("ListLogChunksInArtifact", int64(2), int64(0), int64(10)).Return([]model.LogChunk{
 			model.LogChunk{ByteOffset: 0, Size: 5, ContentBytes: []byte("01234")},
 			model.LogChunk{ByteOffset: 5, Size: 5, ContentBytes: []byte("56789")},
 		}, nil).Once()
 		s3Server, s3Bucket := testS3ServerWithBucket(t)
 		s3Server.Quit()
-		require.Error(t, MergeLogChunks(sentry.CreateAndInstallSentryClient(context.TODO(), "", ""),
+		require.Error(t, mergeClaimedArtifact(sentry.CreateAndInstallSentryClient(context.TODO(), "", ""),
 			&model.Artifact{
 				Id:       2,
-				State:    model.APPEND_COMPLETE,
+				State:    model.UPLOADING,
 				Size:     10,
-				Name:     "TestMergeLogChunks__artifactName",
-				BucketId: "TestMergeLogChunks__bucketName",
+				Name:     "TestMergeClaimedArtifact__artifactName",
+				BucketId: "TestMergeClaimedArtifact__bucketName",
 			}, mockdb, s3Bucket))
 	}
 
-	// Stitching chunks and uploading to S3 successfully (but deleting logchunks fail)
+	// Stitching chunks and uploading to S3 successfully, but deleting logchunks fails. The artifact
+	// must not be marked UPLOADED, so that the merge is retried.
 	mockdb = &database.MockDatabase{}
-	mockdb.On("UpdateArtifact", &model.Artifact{
-		Id:       2,
-		State:    model.UPLOADING,
-		Size:     10,
-		Name:     "TestMergeLogChunks__artifactName",
-		BucketId: "TestMergeLogChunks__bucketName",
-	}).Return(nil).Once()
+	mockTxs(mockdb)
 	mockdb.On("ListLogChunksInArtifact", int64(2), int64(0), int64(10)).Return([]model.LogChunk{
 		model.LogChunk{ByteOffset: 0, Size: 5, ContentBytes: []byte("01234")},
 		model.LogChunk{ByteOffset: 5, Size: 5, ContentBytes: []byte("56789")},
@@ -574,31 +867,25 @@ func TestMergeLogChunks(t *testing.T) {
 	mockdb.On("UpdateArtifact", &model.Artifact{
 		Id:       2,
 		State:    model.UPLOADED,
-		S3URL:    "/TestMergeLogChunks__bucketName/TestMergeLogChunks__artifactName",
-		Name:     "TestMergeLogChunks__artifactName",
-		BucketId: "TestMergeLogChunks__bucketName",
+		Sha256:   testContentSha256,
+		S3URL:    "/TestMergeClaimedArtifact__bucketName/TestMergeClaimedArtifact__artifactName",
+		Name:     "TestMergeClaimedArtifact__artifactName",
+		BucketId: "TestMergeClaimedArtifact__bucketName",
 		Size:     10,
 	}).Return(nil).Once()
 	mockdb.On("DeleteLogChunksForArtifact", int64(2)).Return(int64(0), database.MockDatabaseError()).Once()
 	s3Server, s3Bucket := testS3ServerWithBucket(t)
-	require.NoError(t, MergeLogChunks(sentry.CreateAndInstallSentryClient(context.TODO(), "", ""),
+	require.Error(t, mergeClaimedArtifact(sentry.CreateAndInstallSentryClient(context.TODO(), "", ""),
 		&model.Artifact{
 			Id:       2,
-			State:    model.APPEND_COMPLETE,
+			State:    model.UPLOADING,
 			Size:     10,
-			Name:     "TestMergeLogChunks__artifactName",
-			BucketId: "TestMergeLogChunks__bucketName",
+			Name:     "TestMergeClaimedArtifact__artifactName",
+			BucketId: "TestMergeClaimedArtifact__bucketName",
 		}, mockdb, s3Bucket))
 	s3Server.Quit()
 
 	// Stitching chunks and uploading to S3 successfully
-	mockdb.On("UpdateArtifact", &model.Artifact{
-		Id:       3,
-		State:    model.UPLOADING,
-		Size:     10,
-		Name:     "TestMergeLogChunks__artifactName",
-		BucketId: "TestMergeLogChunks__bucketName",
-	}).Return(nil).Once()
 	mockdb.On("ListLogChunksInArtifact", int64(3), int64(0), int64(10)).Return([]model.LogChunk{
 		model.LogChunk{ByteOffset: 0, Size: 5, ContentBytes: []byte("01234")},
 		model.LogChunk{ByteOffset: 5, Size: 5, ContentBytes: []byte("56789")},
@@ -607,19 +894,135 @@ func TestMergeLogChunks(t *testing.T) {
 	mockdb.On("UpdateArtifact", &model.Artifact{
 		Id:       3,
 		State:    model.UPLOADED,
-		S3URL:    "/TestMergeLogChunks__bucketName/TestMergeLogChunks__artifactName",
-		Name:     "TestMergeLogChunks__artifactName",
-		BucketId: "TestMergeLogChunks__bucketName",
+		Sha256:   testContentSha256,
+		S3URL:    "/TestMergeClaimedArtifact__bucketName/TestMergeClaimedArtifact__artifactName",
+		Name:     "TestMergeClaimedArtifact__artifactName",
+		BucketId: "TestMergeClaimedArtifact__bucketName",
 		Size:     10,
 	}).Return(nil).Once()
 	s3Server, s3Bucket = testS3ServerWithBucket(t)
-	require.NoError(t, MergeLogChunks(nil, &model.Artifact{
+	require.NoError(t, mergeClaimedArtifact(nil, &model.Artifact{
 		Id:       3,
-		State:    model.APPEND_COMPLETE,
+		State:    model.UPLOADING,
 		Size:     10,
-		Name:     "TestMergeLogChunks__artifactName",
-		BucketId: "TestMergeLogChunks__bucketName",
+		Name:     "TestMergeClaimedArtifact__artifactName",
+		BucketId: "TestMergeClaimedArtifact__bucketName",
 	}, mockdb, s3Bucket))
 	s3Server.Quit()
-	// ----- END Closing an artifact with some log chunks
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestCloseArtifact(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+
+	// Chunked artifacts are closed for merging, unless they are empty.
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 1, State: model.APPEND_COMPLETE, Size: 10}).Return(nil).Once()
+	require.NoError(t, CloseArtifact(nil, &model.Artifact{Id: 1, State: model.APPENDING, Size: 10}, mockdb, true))
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 2, State: model.CLOSED_WITHOUT_DATA}).Return(nil).Once()
+	require.NoError(t, CloseArtifact(nil, &model.Artifact{Id: 2, State: model.APPENDING}, mockdb, true))
+
+	// Streamed artifact which was never uploaded.
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 3, State: model.CLOSED_WITHOUT_DATA, Size: 10}).Return(nil).Once()
+	require.NoError(t, CloseArtifact(nil, &model.Artifact{Id: 3, State: model.WAITING_FOR_UPLOAD, Size: 10}, mockdb, true))
+
+	// DB error
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 4, State: model.APPEND_COMPLETE, Size: 10}).Return(database.MockDatabaseError()).Once()
+	require.Error(t, CloseArtifact(nil, &model.Artifact{Id: 4, State: model.APPENDING, Size: 10}, mockdb, true))
+
+	// Artifacts finalized without content can only be closed again while closing their bucket.
+	for _, state := range []model.ArtifactState{model.ERROR, model.DEADLINE_EXCEEDED, model.CLOSED_WITHOUT_DATA} {
+		require.Error(t, CloseArtifact(nil, &model.Artifact{State: state}, mockdb, true))
+		require.NoError(t, CloseArtifact(nil, &model.Artifact{State: state}, mockdb, false))
+	}
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestDeleteArtifact(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	// Artifacts being merged or uploaded can't be deleted.
+	for _, state := range []model.ArtifactState{model.APPEND_COMPLETE, model.UPLOADING} {
+		err := DeleteArtifact(context.Background(), &model.Artifact{Id: 1, State: state}, mockdb, store)
+		require.Error(t, err)
+		require.Equal(t, http.StatusConflict, err.errCode)
+	}
+
+	// Uploaded artifact
+	require.NoError(t, store.Put("/bucket/artifact", bytes.NewBufferString("0123456789"), 10))
+	mockdb.On("DeleteLogChunksForArtifact", int64(1)).Return(int64(0), nil).Once()
+	mockdb.On("DeleteArtifact", int64(1)).Return(nil).Once()
+	require.Nil(t, DeleteArtifact(context.Background(), &model.Artifact{Id: 1, State: model.UPLOADED, S3URL: "/bucket/artifact"}, mockdb, store))
+	_, err := store.Get("/bucket/artifact")
+	require.Equal(t, storage.ErrNotExist, err)
+
+	// Chunked artifact still being appended to
+	mockdb.On("DeleteLogChunksForArtifact", int64(2)).Return(int64(3), nil).Once()
+	mockdb.On("DeleteArtifact", int64(2)).Return(nil).Once()
+	require.Nil(t, DeleteArtifact(context.Background(), &model.Artifact{Id: 2, State: model.APPENDING}, mockdb, store))
+
+	// Artifact deleted concurrently
+	mockdb.On("DeleteLogChunksForArtifact", int64(3)).Return(int64(0), nil).Once()
+	mockdb.On("DeleteArtifact", int64(3)).Return(database.NewEntityNotFoundError("ENF")).Once()
+	require.Nil(t, DeleteArtifact(context.Background(), &model.Artifact{Id: 3, State: model.UPLOADED, S3URL: "/bucket/artifact"}, mockdb, store))
+
+	// Database errors
+	mockdb.On("DeleteLogChunksForArtifact", int64(4)).Return(int64(0), database.MockDatabaseError()).Once()
+	require.Error(t, DeleteArtifact(context.Background(), &model.Artifact{Id: 4, State: model.ERROR}, mockdb, store))
+
+	mockdb.On("DeleteLogChunksForArtifact", int64(5)).Return(int64(0), nil).Once()
+	mockdb.On("DeleteArtifact", int64(5)).Return(database.MockDatabaseError()).Once()
+	require.Error(t, DeleteArtifact(context.Background(), &model.Artifact{Id: 5, State: model.ERROR}, mockdb, store))
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestWaitForArtifactChange(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	broker := NewArtifactBroker()
+	artifact := &model.Artifact{Id: 10, Name: "aName", BucketId: "bName", State: model.APPENDING, Size: 5}
+	appended := &model.Artifact{Id: 10, Name: "aName", BucketId: "bName", State: model.APPENDING, Size: 8}
+
+	// Changed before waiting began
+	mockdb.On("GetArtifactByName", "bName", "aName").Return(appended, nil).Once()
+	latest, err := waitForArtifactChange(mockdb, broker, artifact, time.Minute, nil)
+	require.Nil(t, err)
+	require.Equal(t, appended, latest)
+	mockdb.AssertExpectations(t)
+
+	// Changed while waiting
+	mockdb.On("GetArtifactByName", "bName", "aName").Return(artifact, nil).Run(func(mock.Arguments) {
+		broker.Publish(10)
+	}).Once()
+	mockdb.On("GetArtifactByName", "bName", "aName").Return(appended, nil).Once()
+	latest, err = waitForArtifactChange(mockdb, broker, artifact, time.Minute, nil)
+	require.Nil(t, err)
+	require.Equal(t, appended, latest)
+	mockdb.AssertExpectations(t)
+
+	// Unchanged
+	mockdb.On("GetArtifactByName", "bName", "aName").Return(artifact, nil).Once()
+	latest, err = waitForArtifactChange(mockdb, broker, artifact, time.Millisecond, nil)
+	require.Nil(t, err)
+	require.Equal(t, artifact, latest)
+	mockdb.AssertExpectations(t)
+
+	// Client went away
+	closed := make(chan bool, 1)
+	closed <- true
+	mockdb.On("GetArtifactByName", "bName", "aName").Return(artifact, nil).Once()
+	latest, err = waitForArtifactChange(mockdb, broker, artifact, time.Minute, closed)
+	require.Nil(t, err)
+	require.Equal(t, artifact, latest)
+	mockdb.AssertExpectations(t)
+
+	// DB error
+	mockdb.On("GetArtifactByName", "bName", "aName").Return(nil, database.MockDatabaseError()).Once()
+	_, err = waitForArtifactChange(mockdb, broker, artifact, time.Minute, nil)
+	require.NotNil(t, err)
+	mockdb.AssertExpectations(t)
+	require.Empty(t, broker.subs)
 }
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/common/stats"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/martini-contrib/render"
+)
+
+// Number of random bytes in a generated API token.
+const apiTokenBytes = 32
+
+var authFailedCounter = stats.NewStat("auth_failed")
+
+// BucketAccess is the kind of access to a bucket a request needs.
+type BucketAccess int
+
+const (
+	// Read the bucket and its artifacts.
+	ReadAccess BucketAccess = iota
+
+	// Create, append to and close artifacts in the bucket.
+	UploadAccess
+
+	// Any other change to the bucket or its artifacts, such as closing or deleting them.
+	OwnerAccess
+)
+
+// Authorizer authenticates requests with API tokens (see model.APIToken) or upload tokens (see
+// UploadTokenSigner), passed in the Authorization header as "Bearer <token>", and checks whether
+// they may read or modify buckets.
+//
+// If the Authorizer is disabled, all requests are allowed.
+type Authorizer struct {
+	db           database.Database
+	enabled      bool
+	publicReads  bool
+	uploadTokens *UploadTokenSigner
+}
+
+// NewAuthorizer creates an Authorizer. If publicReads is set, unauthenticated requests may still
+// read buckets and artifacts. Upload tokens are only accepted if uploadTokens is not nil.
+func NewAuthorizer(db database.Database, enabled bool, publicReads bool, uploadTokens *UploadTokenSigner) *Authorizer {
+	return &Authorizer{db: db, enabled: enabled, publicReads: publicReads, uploadTokens: uploadTokens}
+}
+
+// Enabled returns false if all requests are allowed.
+func (a *Authorizer) Enabled() bool {
+	return a.enabled
+}
+
+// AuthorizeRead checks whether the request may read buckets and artifacts, which any valid API
+// token may do (or any request at all, if reads are public).
+func (a *Authorizer) AuthorizeRead(req *http.Request) *HttpError {
+	if !a.enabled || a.publicReads {
+		return nil
+	}
+
+	token, err := bearerToken(req)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.authenticate(token)
+	return err
+}
+
+// AuthorizeOwner checks whether the request may create or modify buckets (and their artifacts) of
+// given owner.
+func (a *Authorizer) AuthorizeOwner(req *http.Request, owner string) *HttpError {
+	if !a.enabled {
+		return nil
+	}
+
+	token, err := bearerToken(req)
+	if err != nil {
+		return err
+	}
+
+	apiToken, err := a.authenticate(token)
+	if err != nil {
+		return err
+	}
+
+	if !apiToken.AllowsOwner(owner) {
+		authFailedCounter.Add(1)
+		return NewHttpError(http.StatusForbidden, "API token %d may not modify buckets of owner %s", apiToken.Id, owner)
+	}
+	return nil
+}
+
+// AuthorizeBucket checks whether the request may access bucket as requested. Besides API tokens
+// granted the bucket owner, upload tokens for the bucket are accepted for ReadAccess and
+// UploadAccess.
+func (a *Authorizer) AuthorizeBucket(req *http.Request, bucket *model.Bucket, access BucketAccess) *HttpError {
+	if !a.enabled {
+		return nil
+	}
+
+	token, err := bearerToken(req)
+	if err != nil {
+		return err
+	}
+
+	if !isUploadToken(token) {
+		if access == ReadAccess {
+			return a.AuthorizeRead(req)
+		}
+		return a.AuthorizeOwner(req, bucket.Owner)
+	}
+
+	if a.uploadTokens == nil {
+		authFailedCounter.Add(1)
+		return NewHttpError(http.StatusUnauthorized, "Upload tokens are not enabled on this server")
+	}
+	if err := a.uploadTokens.Verify(token, bucket.Id); err != nil {
+		authFailedCounter.Add(1)
+		return NewWrappedHttpError(http.StatusUnauthorized, err)
+	}
+	if access == OwnerAccess {
+		authFailedCounter.Add(1)
+		return NewHttpError(http.StatusForbidden, "Upload tokens may only create, append to and close artifacts")
+	}
+	return nil
+}
+
+// bearerToken returns the token in the Authorization header of the request, or an empty string if
+// there is none.
+func bearerToken(req *http.Request) (string, *HttpError) {
+	header := req.Header.Get("Authorization")
+	if header == "" {
+		return "", nil
+	}
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		authFailedCounter.Add(1)
+		return "", NewHttpError(http.StatusUnauthorized, "Unsupported authorization scheme, expected bearer token")
+	}
+	return strings.TrimSpace(parts[1]), nil
+}
+
+// authenticate looks up an API token.
+func (a *Authorizer) authenticate(token string) (*model.APIToken, *HttpError) {
+	if token == "" {
+		authFailedCounter.Add(1)
+		return nil, NewHttpError(http.StatusUnauthorized, "API token required")
+	}
+
+	if isUploadToken(token) {
+		authFailedCounter.Add(1)
+		return nil, NewHttpError(http.StatusForbidden, "Upload tokens may only be used within their bucket")
+	}
+
+	apiToken, err := a.db.GetAPITokenByHash(hashAPIToken(token))
+	if err != nil && err.EntityNotFound() {
+		authFailedCounter.Add(1)
+		return nil, NewHttpError(http.StatusUnauthorized, "Invalid API token")
+	}
+	if err != nil {
+		return nil, NewWrappedHttpError(http.StatusInternalServerError, err)
+	}
+
+	return apiToken, nil
+}
+
+// RespondWithAuthError responds with an error returned by an Authorizer. Only internal errors are
+// reported to Sentry, since rejected requests are expected.
+func RespondWithAuthError(ctx context.Context, r render.Render, err *HttpError) {
+	if err.errCode >= http.StatusInternalServerError {
+		LogAndRespondWithError(ctx, r, err.errCode, err)
+	} else {
+		RespondWithError(ctx, r, err.errCode, err)
+	}
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken issues a new API token granted given bucket owners (model.AllOwners grants all
+// owners). The returned token must be handed over to the client right away: only its hash is
+// stored, so it can't be recovered later.
+func CreateAPIToken(db database.Database, clk common.Clock, owners []string, description string) (string, *model.APIToken, error) {
+	if len(owners) == 0 {
+		return "", nil, errors.New("API token must be granted at least one owner")
+	}
+
+	b := make([]byte, apiTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret := hex.EncodeToString(b)
+
+	token := &model.APIToken{
+		TokenHash:   hashAPIToken(secret),
+		Description: description,
+		DateCreated: clk.Now(),
+		Owners:      owners,
+	}
+	if err := db.InsertAPIToken(token); err != nil {
+		return "", nil, err
+	}
+
+	return secret, token, nil
+}
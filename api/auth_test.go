@@ -0,0 +1,154 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func requestWithAuthorization(t *testing.T, authorization string) *http.Request {
+	req, err := http.NewRequest("POST", "/buckets/", nil)
+	require.NoError(t, err)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return req
+}
+
+func requireHttpErrorCode(t *testing.T, code int, err *HttpError) {
+	require.NotNil(t, err)
+	require.Equal(t, code, err.errCode, "Unexpected error: %s", err)
+}
+
+func TestAuthorizerDisabled(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	authz := NewAuthorizer(mockdb, false, false, nil)
+
+	// No DB lookups when authorization is disabled.
+	require.False(t, authz.Enabled())
+	require.Nil(t, authz.AuthorizeRead(requestWithAuthorization(t, "")))
+	require.Nil(t, authz.AuthorizeOwner(requestWithAuthorization(t, ""), "owner"))
+	mockdb.AssertExpectations(t)
+}
+
+func TestAuthorizeOwner(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	authz := NewAuthorizer(mockdb, true, false, nil)
+	require.True(t, authz.Enabled())
+
+	token := &model.APIToken{Id: 1, Owners: []string{"owner1", "owner2"}}
+	mockdb.On("GetAPITokenByHash", hashAPIToken("secret")).Return(token, nil)
+	mockdb.On("GetAPITokenByHash", hashAPIToken("bogus")).Return(nil, database.NewEntityNotFoundError("ENF"))
+	mockdb.On("GetAPITokenByHash", hashAPIToken("dberror")).Return(nil, database.MockDatabaseError())
+
+	require.Nil(t, authz.AuthorizeOwner(requestWithAuthorization(t, "Bearer secret"), "owner1"))
+	require.Nil(t, authz.AuthorizeOwner(requestWithAuthorization(t, "bearer secret"), "owner2"))
+	requireHttpErrorCode(t, http.StatusForbidden, authz.AuthorizeOwner(requestWithAuthorization(t, "Bearer secret"), "owner3"))
+
+	requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeOwner(requestWithAuthorization(t, ""), "owner1"))
+	requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeOwner(requestWithAuthorization(t, "Basic secret"), "owner1"))
+	requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeOwner(requestWithAuthorization(t, "Bearer"), "owner1"))
+	requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeOwner(requestWithAuthorization(t, "Bearer bogus"), "owner1"))
+	requireHttpErrorCode(t, http.StatusInternalServerError, authz.AuthorizeOwner(requestWithAuthorization(t, "Bearer dberror"), "owner1"))
+
+	// Tokens granted all owners.
+	mockdb.On("GetAPITokenByHash", hashAPIToken("admin")).Return(&model.APIToken{Id: 2, Owners: []string{model.AllOwners}}, nil)
+	require.Nil(t, authz.AuthorizeOwner(requestWithAuthorization(t, "Bearer admin"), "owner3"))
+}
+
+func TestAuthorizeRead(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockdb.On("GetAPITokenByHash", hashAPIToken("secret")).Return(&model.APIToken{Id: 1, Owners: []string{"owner1"}}, nil)
+	mockdb.On("GetAPITokenByHash", hashAPIToken("bogus")).Return(nil, database.NewEntityNotFoundError("ENF"))
+
+	// Any valid token may read.
+	authz := NewAuthorizer(mockdb, true, false, nil)
+	require.Nil(t, authz.AuthorizeRead(requestWithAuthorization(t, "Bearer secret")))
+	requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeRead(requestWithAuthorization(t, "Bearer bogus")))
+	requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeRead(requestWithAuthorization(t, "")))
+
+	// Public reads don't need a token, but writes still do.
+	authz = NewAuthorizer(mockdb, true, true, nil)
+	require.Nil(t, authz.AuthorizeRead(requestWithAuthorization(t, "")))
+	requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeOwner(requestWithAuthorization(t, ""), "owner1"))
+}
+
+func TestCreateAPIToken(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+
+	_, _, err := CreateAPIToken(mockdb, mockClock, []string{}, "no owners")
+	require.Error(t, err)
+
+	mockdb.On("InsertAPIToken", mock.AnythingOfType("*model.APIToken")).Return(database.MockDatabaseError()).Once()
+	_, _, err = CreateAPIToken(mockdb, mockClock, []string{"owner"}, "db error")
+	require.Error(t, err)
+
+	mockdb.On("InsertAPIToken", mock.AnythingOfType("*model.APIToken")).Return(nil).Once()
+	secret, token, err := CreateAPIToken(mockdb, mockClock, []string{"owner1", "owner2"}, "test token")
+	require.NoError(t, err)
+	require.NotEmpty(t, secret)
+	require.Equal(t, hashAPIToken(secret), token.TokenHash)
+	require.NotEqual(t, secret, token.TokenHash)
+	require.Equal(t, []string{"owner1", "owner2"}, token.Owners)
+	require.Equal(t, "test token", token.Description)
+	require.Equal(t, mockClock.Now(), token.DateCreated)
+
+	// Tokens are random.
+	mockdb.On("InsertAPIToken", mock.AnythingOfType("*model.APIToken")).Return(nil).Once()
+	otherSecret, _, err := CreateAPIToken(mockdb, mockClock, []string{"owner1"}, "other token")
+	require.NoError(t, err)
+	require.NotEqual(t, secret, otherSecret)
+	mockdb.AssertExpectations(t)
+}
+
+func TestAuthorizeBucket(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockdb.On("GetAPITokenByHash", hashAPIToken("secret")).Return(&model.APIToken{Id: 1, Owners: []string{"owner1"}}, nil)
+
+	signer := NewUploadTokenSigner([]byte("key"), common.NewFrozenClock())
+	authz := NewAuthorizer(mockdb, true, false, signer)
+
+	bucket := &model.Bucket{Id: "bkt", Owner: "owner1"}
+	otherBucket := &model.Bucket{Id: "other", Owner: "owner2"}
+	uploadToken, _ := signer.Mint("bkt", time.Hour)
+
+	// API tokens need to be granted the bucket owner to modify it.
+	for _, access := range []BucketAccess{ReadAccess, UploadAccess, OwnerAccess} {
+		require.Nil(t, authz.AuthorizeBucket(requestWithAuthorization(t, "Bearer secret"), bucket, access))
+		requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeBucket(requestWithAuthorization(t, ""), bucket, access))
+	}
+	require.Nil(t, authz.AuthorizeBucket(requestWithAuthorization(t, "Bearer secret"), otherBucket, ReadAccess))
+	requireHttpErrorCode(t, http.StatusForbidden, authz.AuthorizeBucket(requestWithAuthorization(t, "Bearer secret"), otherBucket, UploadAccess))
+
+	// Upload tokens can read and upload, but nothing else.
+	require.Nil(t, authz.AuthorizeBucket(requestWithAuthorization(t, "Bearer "+uploadToken), bucket, ReadAccess))
+	require.Nil(t, authz.AuthorizeBucket(requestWithAuthorization(t, "Bearer "+uploadToken), bucket, UploadAccess))
+	requireHttpErrorCode(t, http.StatusForbidden, authz.AuthorizeBucket(requestWithAuthorization(t, "Bearer "+uploadToken), bucket, OwnerAccess))
+
+	// Upload tokens are limited to their bucket.
+	for _, access := range []BucketAccess{ReadAccess, UploadAccess, OwnerAccess} {
+		requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeBucket(requestWithAuthorization(t, "Bearer "+uploadToken), otherBucket, access))
+	}
+	requireHttpErrorCode(t, http.StatusForbidden, authz.AuthorizeRead(requestWithAuthorization(t, "Bearer "+uploadToken)))
+	requireHttpErrorCode(t, http.StatusForbidden, authz.AuthorizeOwner(requestWithAuthorization(t, "Bearer "+uploadToken), "owner1"))
+
+	// Upload tokens are rejected if not enabled.
+	authz = NewAuthorizer(mockdb, true, false, nil)
+	requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeBucket(requestWithAuthorization(t, "Bearer "+uploadToken), bucket, UploadAccess))
+
+	// Public reads are allowed without a token, but not with an invalid upload token.
+	authz = NewAuthorizer(mockdb, true, true, signer)
+	require.Nil(t, authz.AuthorizeBucket(requestWithAuthorization(t, ""), bucket, ReadAccess))
+	requireHttpErrorCode(t, http.StatusUnauthorized, authz.AuthorizeBucket(requestWithAuthorization(t, "Bearer "+uploadToken), otherBucket, ReadAccess))
+
+	// Everything is allowed when authorization is disabled.
+	authz = NewAuthorizer(mockdb, false, false, nil)
+	require.Nil(t, authz.AuthorizeBucket(requestWithAuthorization(t, ""), bucket, OwnerAccess))
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
+)
+
+// blobReader presents an io.ReadSeeker interface over a blob in the blob store, so that it can be
+// served using http.ServeContent. Content is fetched lazily: a ranged read from the current offset
+// to the end of the blob is started on the first Read() after a Seek(), so that seeking around
+// (as http.ServeContent does to find the size and serve Range requests) doesn't fetch any content.
+type blobReader struct {
+	store  storage.BlobStore
+	blob   storage.BlobVersion
+	offset int64
+	rc     io.ReadCloser
+	err    error // Last error (other than io.EOF) seen while reading from the blob store.
+}
+
+func newBlobReader(store storage.BlobStore, blob storage.BlobVersion) *blobReader {
+	return &blobReader{store: store, blob: blob}
+}
+
+// artifactBlob identifies the blob holding content of an UPLOADED artifact. Artifact ids are never
+// reused, so the blob can be cached by artifact id and content digest (or row version, for
+// artifacts uploaded without a digest) even though its name is reused across artifacts.
+func artifactBlob(artifact *model.Artifact) storage.BlobVersion {
+	id := fmt.Sprintf("artifact-%d-v%d", artifact.Id, artifact.Version)
+	if artifact.Sha256 != "" {
+		id = fmt.Sprintf("artifact-%d-%s", artifact.Id, artifact.Sha256)
+	}
+	return storage.BlobVersion{Name: artifact.S3URL, ID: id, Size: artifact.Size}
+}
+
+func (br *blobReader) Read(p []byte) (int, error) {
+	if br.offset >= br.blob.Size {
+		return 0, io.EOF
+	}
+
+	if br.rc == nil {
+		rc, err := storage.GetVersionRange(br.store, br.blob, br.offset, br.blob.Size-1)
+		if err != nil {
+			br.err = err
+			return 0, err
+		}
+		br.rc = rc
+	}
+
+	n, err := br.rc.Read(p)
+	br.offset += int64(n)
+	if err != nil && err != io.EOF {
+		br.err = err
+	}
+	return n, err
+}
+
+func (br *blobReader) Seek(offset int64, whence int) (int64, error) {
+	newOffset := offset
+	if whence == os.SEEK_CUR {
+		newOffset += br.offset
+	} else if whence == os.SEEK_END {
+		newOffset += br.blob.Size
+	}
+
+	if newOffset < 0 || newOffset > br.blob.Size {
+		return br.offset, errInvalidSeek
+	}
+
+	if newOffset != br.offset {
+		br.Close()
+		br.offset = newOffset
+	}
+	return br.offset, nil
+}
+
+// Close releases any ongoing read from the blob store.
+func (br *blobReader) Close() error {
+	if br.rc == nil {
+		return nil
+	}
+
+	err := br.rc.Close()
+	br.rc = nil
+	return err
+}
+
+var _ io.ReadSeeker = (*blobReader)(nil)
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func testLocalBlobStore(t *testing.T) (storage.BlobStore, func()) {
+	dir, err := ioutil.TempDir("", "artifacts-api-test")
+	require.NoError(t, err)
+
+	store, err := storage.NewLocalBlobStore(dir)
+	require.NoError(t, err)
+
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func TestBlobReader(t *testing.T) {
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	require.NoError(t, store.Put("/bucket/artifact", bytes.NewBufferString("0123456789"), 10))
+
+	br := newBlobReader(store, storage.BlobVersion{Name: "/bucket/artifact", Size: 10})
+	defer br.Close()
+
+	p := make([]byte, 4)
+	n, err := br.Read(p)
+	require.NoError(t, err)
+	require.Equal(t, "0123", string(p[:n]))
+
+	// Seek to end and back, as http.ServeContent does.
+	offset, err := br.Seek(0, os.SEEK_END)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), offset)
+	_, err = br.Read(p)
+	require.Error(t, err)
+
+	offset, err = br.Seek(-3, os.SEEK_CUR)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), offset)
+	content, err := ioutil.ReadAll(br)
+	require.NoError(t, err)
+	require.Equal(t, "789", string(content))
+
+	_, err = br.Seek(11, os.SEEK_SET)
+	require.Error(t, err)
+	_, err = br.Seek(-1, os.SEEK_SET)
+	require.Error(t, err)
+
+	// Missing blob
+	br = newBlobReader(store, storage.BlobVersion{Name: "/bucket/missing", Size: 10})
+	_, err = br.Read(p)
+	require.Equal(t, storage.ErrNotExist, err)
+}
+
+func TestBlobReaderServeContent(t *testing.T) {
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	require.NoError(t, store.Put("/bucket/artifact", bytes.NewBufferString("0123456789"), 10))
+
+	req, _ := http.NewRequest("GET", "/content", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	w := httptest.NewRecorder()
+	br := newBlobReader(store, storage.BlobVersion{Name: "/bucket/artifact", Size: 10})
+	http.ServeContent(w, req, "artifact.txt", time.Time{}, br)
+	br.Close()
+
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.Equal(t, "2345", w.Body.String())
+	require.NoError(t, br.err)
+}
+
+func TestArtifactBlob(t *testing.T) {
+	artifact := &model.Artifact{Id: 10, Name: "a", BucketId: "b", Size: 5, S3URL: "/b/a", Version: 3}
+	blob := artifactBlob(artifact)
+	require.Equal(t, storage.BlobVersion{Name: "/b/a", ID: "artifact-10-v3", Size: 5}, blob)
+
+	artifact.Sha256 = "abcd"
+	require.Equal(t, "artifact-10-abcd", artifactBlob(artifact).ID)
+
+	// An artifact created again with the same name is a different blob version.
+	artifact.Id = 11
+	require.NotEqual(t, blob.ID, artifactBlob(artifact).ID)
+	require.Equal(t, blob.Name, artifactBlob(artifact).Name)
+}
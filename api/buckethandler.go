@@ -4,14 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"time"
 
 	"golang.org/x/net/context"
 
 	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/common/stats"
 	"github.com/dropbox/changes-artifacts/database"
 	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
 	"github.com/martini-contrib/render"
-	"gopkg.in/amz.v1/s3"
 )
 
 type HttpError struct {
@@ -37,15 +40,129 @@ func NewWrappedHttpError(code int, err error) *HttpError {
 // Ensure that HttpError implements error
 var _ error = new(HttpError)
 
-func ListBuckets(ctx context.Context, r render.Render, db database.Database) {
-	if buckets, err := db.ListBuckets(); err != nil {
+// errorStatus returns the HTTP status code to respond with for err. Entities modified concurrently
+// (or moved to a state they can't reach) result in a conflict, and *HttpErrors carry their own
+// status code. All other errors result in fallback.
+func errorStatus(err error, fallback int) int {
+	switch e := err.(type) {
+	case *HttpError:
+		return e.errCode
+	case *database.DatabaseError:
+		if e.Conflict() {
+			return http.StatusConflict
+		}
+	case *model.IllegalTransitionError:
+		return http.StatusConflict
+	}
+	return fallback
+}
+
+// DefaultBucketDeadlineMins is the deadline used for buckets created without an explicit deadline.
+// Zero (the default) means such buckets have no deadline. Set at startup.
+var DefaultBucketDeadlineMins uint
+
+var bucketsDeletedCounter = stats.NewStat("buckets_deleted")
+
+// ListBuckets lists buckets, oldest first, one page at a time. The listing can be filtered and
+// ordered with the following query parameters:
+//
+// - owner: Only list buckets with given owner.
+// - state: Only list buckets in given state (for example, "OPEN").
+// - createdAfter, createdBefore: Only list buckets created in given time range (RFC 3339).
+// - sort: "dateCreated" (the default) or "-dateCreated" to list newest buckets first.
+// - limit: Page size, between 1 and MaxPageSize (default DefaultPageSize).
+// - cursor: Continue listing from the end of a previous page (see NextCursorHeader).
+func ListBuckets(ctx context.Context, r render.Render, req *http.Request, res http.ResponseWriter, db database.Database) {
+	filter, err := bucketFilterFromQuery(req.URL.Query())
+	if err != nil {
 		LogAndRespondWithError(ctx, r, http.StatusBadRequest, err)
-	} else {
-		r.JSON(http.StatusOK, buckets)
+		return
+	}
+
+	buckets, dberr := db.ListBuckets(filter)
+	if dberr != nil {
+		LogAndRespondWithError(ctx, r, http.StatusInternalServerError, dberr)
+		return
+	}
+
+	if len(buckets) == filter.Limit {
+		last := buckets[len(buckets)-1]
+		res.Header().Set(NextCursorHeader, encodeCursor(bucketListCursor{
+			Sort:         req.URL.Query().Get("sort"),
+			BucketCursor: database.BucketCursor{DateCreated: last.DateCreated, Id: last.Id},
+		}))
 	}
+	r.JSON(http.StatusOK, buckets)
+}
+
+// bucketListCursor is the position in a bucket listing encoded in its cursor. The sort order is
+// included since a position is meaningless in a listing with a different order.
+type bucketListCursor struct {
+	Sort string
+	database.BucketCursor
 }
 
-func CreateBucket(db database.Database, clk common.Clock, bucketId string, owner string) (*model.Bucket, *HttpError) {
+func bucketFilterFromQuery(query url.Values) (database.BucketFilter, error) {
+	filter := database.BucketFilter{Owner: query.Get("owner")}
+
+	if state := query.Get("state"); state != "" {
+		s, err := parseBucketState(state)
+		if err != nil {
+			return filter, err
+		}
+		filter.State = s
+	}
+
+	for param, t := range map[string]*time.Time{
+		"createdAfter":  &filter.CreatedAfter,
+		"createdBefore": &filter.CreatedBefore,
+	} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s %q, expected RFC 3339 time", param, value)
+			}
+			*t = parsed
+		}
+	}
+
+	switch sort := query.Get("sort"); sort {
+	case "", "dateCreated":
+	case "-dateCreated":
+		filter.Descending = true
+	default:
+		return filter, fmt.Errorf("Invalid sort order %q", sort)
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		var position bucketListCursor
+		if err := decodeCursor(cursor, &position); err != nil {
+			return filter, err
+		}
+		if position.Sort != query.Get("sort") {
+			return filter, fmt.Errorf("Cursor %q does not match sort order %q", cursor, query.Get("sort"))
+		}
+		filter.Cursor = &position.BucketCursor
+	}
+
+	limit, err := pageSizeParam(query.Get("limit"))
+	filter.Limit = limit
+	return filter, err
+}
+
+// parseBucketState parses a bucket state from its name (for example, "OPEN").
+func parseBucketState(name string) (model.BucketState, error) {
+	for s := model.OPEN; s <= model.TIMEDOUT; s++ {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return model.UNKNOWN, fmt.Errorf("Invalid bucket state %q", name)
+}
+
+// CreateBucket creates a new open bucket. If deadlineMins is zero, DefaultBucketDeadlineMins is used
+// (which may also be zero, in which case the bucket has no deadline).
+func CreateBucket(db database.Database, clk common.Clock, bucketId string, owner string, deadlineMins uint) (*model.Bucket, *HttpError) {
 	if bucketId == "" {
 		return nil, NewHttpError(http.StatusBadRequest, "Bucket ID not provided")
 	}
@@ -67,16 +184,24 @@ func CreateBucket(db database.Database, clk common.Clock, bucketId string, owner
 	bucket.DateCreated = clk.Now()
 	bucket.State = model.OPEN
 	bucket.Owner = owner
+	if deadlineMins == 0 {
+		bucket.DeadlineMins = DefaultBucketDeadlineMins
+	} else {
+		bucket.DeadlineMins = deadlineMins
+	}
 	if err := db.InsertBucket(&bucket); err != nil {
 		return nil, NewWrappedHttpError(http.StatusBadRequest, err)
 	}
 	return &bucket, nil
 }
 
-func HandleCreateBucket(ctx context.Context, r render.Render, req *http.Request, db database.Database, clk common.Clock) {
+// HandleCreateBucket handles the HTTP request to create a bucket, if the request is authorized to
+// create buckets of the requested owner.
+func HandleCreateBucket(ctx context.Context, r render.Render, req *http.Request, db database.Database, clk common.Clock, authz *Authorizer) {
 	var createBucketReq struct {
-		ID    string
-		Owner string
+		ID           string
+		Owner        string
+		DeadlineMins uint
 	}
 
 	if err := json.NewDecoder(req.Body).Decode(&createBucketReq); err != nil {
@@ -84,7 +209,12 @@ func HandleCreateBucket(ctx context.Context, r render.Render, req *http.Request,
 		return
 	}
 
-	if bucket, err := CreateBucket(db, clk, createBucketReq.ID, createBucketReq.Owner); err != nil {
+	if err := authz.AuthorizeOwner(req, createBucketReq.Owner); err != nil {
+		RespondWithAuthError(ctx, r, err)
+		return
+	}
+
+	if bucket, err := CreateBucket(db, clk, createBucketReq.ID, createBucketReq.Owner, createBucketReq.DeadlineMins); err != nil {
 		LogAndRespondWithError(ctx, r, err.errCode, err)
 	} else {
 		r.JSON(http.StatusOK, bucket)
@@ -101,14 +231,14 @@ func HandleGetBucket(ctx context.Context, r render.Render, bucket *model.Bucket)
 }
 
 // HandleCloseBucket handles the HTTP request to close a bucket. See CloseBucket for details.
-func HandleCloseBucket(ctx context.Context, r render.Render, db database.Database, bucket *model.Bucket, s3Bucket *s3.Bucket, clk common.Clock) {
+func HandleCloseBucket(ctx context.Context, r render.Render, db database.Database, bucket *model.Bucket, clk common.Clock) {
 	if bucket == nil {
 		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No bucket specified")
 		return
 	}
 
-	if err := CloseBucket(ctx, bucket, db, s3Bucket, clk); err != nil {
-		LogAndRespondWithError(ctx, r, http.StatusBadRequest, err)
+	if err := CloseBucket(ctx, bucket, db, clk); err != nil {
+		LogAndRespondWithError(ctx, r, errorStatus(err, http.StatusBadRequest), err)
 	} else {
 		r.JSON(http.StatusOK, bucket)
 	}
@@ -117,12 +247,24 @@ func HandleCloseBucket(ctx context.Context, r render.Render, db database.Databas
 
 // CloseBucket closes a bucket, preventing further updates. All artifacts associated with the bucket
 // are also marked closed. If the bucket is already closed, an error is returned.
-func CloseBucket(ctx context.Context, bucket *model.Bucket, db database.Database, s3Bucket *s3.Bucket, clk common.Clock) error {
+func CloseBucket(ctx context.Context, bucket *model.Bucket, db database.Database, clk common.Clock) error {
+	return closeBucket(ctx, bucket, db, clk, model.CLOSED)
+}
+
+// TimeoutBucket forcibly closes a bucket which was not closed before its deadline. Apart from the
+// final bucket state (TIMEDOUT instead of CLOSED), this is identical to CloseBucket.
+func TimeoutBucket(ctx context.Context, bucket *model.Bucket, db database.Database, clk common.Clock) error {
+	return closeBucket(ctx, bucket, db, clk, model.TIMEDOUT)
+}
+
+func closeBucket(ctx context.Context, bucket *model.Bucket, db database.Database, clk common.Clock, finalState model.BucketState) error {
 	if bucket.State != model.OPEN {
 		return fmt.Errorf("Bucket is already closed")
 	}
 
-	bucket.State = model.CLOSED
+	// The bucket is closed before its artifacts, so that no artifacts can be added to it in the
+	// meantime, and so that an artifact which can't be closed doesn't keep the bucket open.
+	bucket.State = finalState
 	bucket.DateClosed = clk.Now()
 	if err := db.UpdateBucket(bucket); err != nil {
 		return err
@@ -131,8 +273,8 @@ func CloseBucket(ctx context.Context, bucket *model.Bucket, db database.Database
 	if artifacts, err := db.ListArtifactsInBucket(bucket.Id); err != nil {
 		return err
 	} else {
-		for _, artifact := range artifacts {
-			if err := CloseArtifact(ctx, &artifact, db, s3Bucket, false); err != nil {
+		for i := range artifacts {
+			if err := closeArtifactInBucket(ctx, &artifacts[i], db); err != nil {
 				return err
 			}
 		}
@@ -140,3 +282,76 @@ func CloseBucket(ctx context.Context, bucket *model.Bucket, db database.Database
 
 	return nil
 }
+
+// MaxCloseArtifactAttempts is the number of times closing an artifact of a bucket being closed is
+// attempted, if the artifact keeps being updated concurrently.
+const MaxCloseArtifactAttempts = 3
+
+// closeArtifactInBucket closes an artifact of a closed bucket. If the artifact was updated
+// concurrently (for example, by an append), it is reloaded and closing it is retried. Artifacts
+// deleted concurrently are skipped.
+func closeArtifactInBucket(ctx context.Context, artifact *model.Artifact, db database.Database) error {
+	for attempt := 1; ; attempt++ {
+		err := CloseArtifact(ctx, artifact, db, false)
+		if dbErr, ok := err.(*database.DatabaseError); !ok || !dbErr.Conflict() || attempt == MaxCloseArtifactAttempts {
+			return err
+		}
+
+		latest, getErr := db.GetArtifactByName(artifact.BucketId, artifact.Name)
+		if getErr != nil && getErr.EntityNotFound() {
+			return nil
+		}
+		if getErr != nil {
+			return getErr
+		}
+		if latest.Id != artifact.Id {
+			// Deleted concurrently, and replaced before the bucket was closed.
+			return nil
+		}
+		artifact = latest
+	}
+}
+
+// HandleDeleteBucket handles the HTTP request to delete a bucket. See DeleteBucket for details.
+func HandleDeleteBucket(ctx context.Context, r render.Render, db database.Database, store storage.BlobStore, bucket *model.Bucket) {
+	if bucket == nil {
+		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No bucket specified")
+		return
+	}
+
+	if err := DeleteBucket(ctx, bucket, db, store); err != nil {
+		LogAndRespondWithError(ctx, r, err.errCode, err)
+		return
+	}
+
+	r.JSON(http.StatusOK, map[string]interface{}{})
+}
+
+// DeleteBucket deletes a bucket and all artifacts in it (see DeleteArtifact). If any artifact is
+// being uploaded, nothing is deleted.
+func DeleteBucket(ctx context.Context, bucket *model.Bucket, db database.Database, store storage.BlobStore) *HttpError {
+	artifacts, err := db.ListArtifactsInBucket(bucket.Id)
+	if err != nil {
+		return NewWrappedHttpError(http.StatusInternalServerError, err)
+	}
+
+	for _, artifact := range artifacts {
+		switch artifact.State {
+		case model.APPEND_COMPLETE, model.UPLOADING:
+			return NewHttpError(http.StatusConflict, "Artifact %s/%s is being uploaded, bucket can't be deleted right now", artifact.BucketId, artifact.Name)
+		}
+	}
+
+	for i := range artifacts {
+		if err := DeleteArtifact(ctx, &artifacts[i], db, store); err != nil {
+			return err
+		}
+	}
+
+	if err := db.DeleteBucket(bucket.Id); err != nil && !err.EntityNotFound() {
+		return NewWrappedHttpError(http.StatusInternalServerError, err)
+	}
+
+	bucketsDeletedCounter.Add(1)
+	return nil
+}
@@ -1,12 +1,17 @@
 package api
 
 import (
+	"bytes"
 	"fmt"
+	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/dropbox/changes-artifacts/common"
 	"github.com/dropbox/changes-artifacts/database"
 	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -21,34 +26,53 @@ func TestCreateBucket(t *testing.T) {
 	var err error
 
 	// Bad request
-	_, err = CreateBucket(mockdb, mockClock, "", "owner")
+	_, err = CreateBucket(mockdb, mockClock, "", "owner", 0)
 	require.Error(t, err)
 
-	_, err = CreateBucket(mockdb, mockClock, "id", "")
+	_, err = CreateBucket(mockdb, mockClock, "id", "", 0)
 	require.Error(t, err)
 
 	// DB error
 	mockdb.On("GetBucket", "id").Return(nil, database.WrapInternalDatabaseError(fmt.Errorf("Internal Error"))).Once()
-	_, err = CreateBucket(mockdb, mockClock, "id", "owner")
+	_, err = CreateBucket(mockdb, mockClock, "id", "owner", 0)
 	require.Error(t, err)
 
 	// Entity exists
 	mockdb.On("GetBucket", "id").Return(&model.Bucket{}, nil).Once()
-	_, err = CreateBucket(mockdb, mockClock, "id", "owner")
+	_, err = CreateBucket(mockdb, mockClock, "id", "owner", 0)
 	require.Error(t, err)
 
 	// DB error while creating bucket
 	mockdb.On("GetBucket", "id").Return(nil, database.NewEntityNotFoundError("ENF")).Once()
 	mockdb.On("InsertBucket", mock.AnythingOfType("*model.Bucket")).Return(database.WrapInternalDatabaseError(fmt.Errorf("INT"))).Once()
-	_, err = CreateBucket(mockdb, mockClock, "id", "owner")
+	_, err = CreateBucket(mockdb, mockClock, "id", "owner", 0)
 	require.Error(t, err)
 
 	// Successfully created bucket
 	mockdb.On("GetBucket", "id").Return(nil, database.NewEntityNotFoundError("ENF")).Once()
 	mockdb.On("InsertBucket", mock.AnythingOfType("*model.Bucket")).Return(nil).Once()
-	bucket, err := CreateBucket(mockdb, mockClock, "id", "owner")
+	bucket, err := CreateBucket(mockdb, mockClock, "id", "owner", 0)
 	require.NoError(t, err)
 	require.NotNil(t, bucket)
+	// Buckets without an explicit deadline never time out by default.
+	require.Equal(t, uint(0), bucket.DeadlineMins)
+
+	// Successfully created bucket with server default deadline
+	DefaultBucketDeadlineMins = 24 * 60
+	defer func() { DefaultBucketDeadlineMins = 0 }()
+	mockdb.On("GetBucket", "id").Return(nil, database.NewEntityNotFoundError("ENF")).Once()
+	mockdb.On("InsertBucket", mock.AnythingOfType("*model.Bucket")).Return(nil).Once()
+	bucket, err = CreateBucket(mockdb, mockClock, "id", "owner", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint(24*60), bucket.DeadlineMins)
+
+	// Successfully created bucket with explicit deadline
+	mockdb.On("GetBucket", "id").Return(nil, database.NewEntityNotFoundError("ENF")).Once()
+	mockdb.On("InsertBucket", mock.AnythingOfType("*model.Bucket")).Return(nil).Once()
+	bucket, err = CreateBucket(mockdb, mockClock, "id", "owner", 30)
+	require.NoError(t, err)
+	require.NotNil(t, bucket)
+	require.Equal(t, uint(30), bucket.DeadlineMins)
 
 	mockdb.AssertExpectations(t)
 }
@@ -61,25 +85,25 @@ func TestCloseBucket(t *testing.T) {
 
 	// If bucket is not currently open, return failure
 	bucket := &model.Bucket{State: model.CLOSED}
-	require.Error(t, CloseBucket(nil, bucket, mockdb, nil, nil))
+	require.Error(t, CloseBucket(nil, bucket, mockdb, nil))
 
 	bucket_id := "bucket_id_1"
 
 	// If DB throws error in any step, return failure
 	bucket = &model.Bucket{State: model.OPEN, Id: bucket_id}
 	mockdb.On("UpdateBucket", bucket).Return(database.WrapInternalDatabaseError(fmt.Errorf("foo"))).Once()
-	require.Error(t, CloseBucket(nil, bucket, mockdb, nil, mockClock))
+	require.Error(t, CloseBucket(nil, bucket, mockdb, mockClock))
 
 	bucket = &model.Bucket{State: model.OPEN, Id: bucket_id}
 	mockdb.On("UpdateBucket", bucket).Return(nil).Once()
 	mockdb.On("ListArtifactsInBucket", bucket.Id).Return(nil, database.WrapInternalDatabaseError(fmt.Errorf("err"))).Once()
-	require.Error(t, CloseBucket(nil, bucket, mockdb, nil, mockClock))
+	require.Error(t, CloseBucket(nil, bucket, mockdb, mockClock))
 
 	// Closing bucket with no artifacts successfully. Verify bucket state and dateclosed.
 	bucket = &model.Bucket{State: model.OPEN, Id: bucket_id}
 	mockdb.On("UpdateBucket", bucket).Return(nil).Once()
 	mockdb.On("ListArtifactsInBucket", bucket.Id).Return([]model.Artifact{}, nil).Once()
-	require.NoError(t, CloseBucket(nil, bucket, mockdb, nil, mockClock))
+	require.NoError(t, CloseBucket(nil, bucket, mockdb, mockClock))
 	require.Equal(t, model.CLOSED, bucket.State)
 	require.Equal(t, mockClock.Now(), bucket.DateClosed)
 
@@ -89,9 +113,200 @@ func TestCloseBucket(t *testing.T) {
 	mockdb.On("UpdateBucket", bucket).Return(nil).Once()
 	mockdb.On("ListArtifactsInBucket", bucket.Id).Return([]model.Artifact{artifact}, nil).Once()
 
-	require.NoError(t, CloseBucket(nil, bucket, mockdb, nil, mockClock))
+	require.NoError(t, CloseBucket(nil, bucket, mockdb, mockClock))
 	require.Equal(t, model.CLOSED, bucket.State)
 	require.Equal(t, mockClock.Now(), bucket.DateClosed)
 
+	// Artifacts being merged and uploaded don't need to be closed.
+	bucket = &model.Bucket{State: model.OPEN, Id: bucket_id}
+	mockdb.On("UpdateBucket", bucket).Return(nil).Once()
+	mockdb.On("ListArtifactsInBucket", bucket.Id).Return([]model.Artifact{
+		{Id: 20, State: model.UPLOADING},
+		{Id: 21, State: model.APPEND_COMPLETE},
+	}, nil).Once()
+
+	require.NoError(t, CloseBucket(nil, bucket, mockdb, mockClock))
+	require.Equal(t, model.CLOSED, bucket.State)
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestCloseBucketRetriesConflictingArtifacts(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+
+	bucket := &model.Bucket{State: model.OPEN, Id: "bucket_id_1"}
+	mockdb.On("UpdateBucket", bucket).Return(nil).Once()
+	mockdb.On("ListArtifactsInBucket", bucket.Id).Return([]model.Artifact{
+		{Id: 20, BucketId: bucket.Id, Name: "appended", State: model.APPENDING, Size: 10, Version: 1},
+		{Id: 21, BucketId: bucket.Id, Name: "deleted", State: model.APPENDING, Size: 10, Version: 1},
+		{Id: 22, BucketId: bucket.Id, Name: "waiting", State: model.WAITING_FOR_UPLOAD, Version: 1},
+	}, nil).Once()
+
+	// An append changed the first artifact before it could be closed. It is closed once reloaded.
+	mockdb.On("UpdateArtifact", mock.MatchedBy(func(a *model.Artifact) bool {
+		return a.Id == 20 && a.Version == 1
+	})).Return(database.NewConflictError("Conflict")).Once()
+	mockdb.On("GetArtifactByName", bucket.Id, "appended").Return(&model.Artifact{
+		Id: 20, BucketId: bucket.Id, Name: "appended", State: model.APPENDING, Size: 20, Version: 2,
+	}, nil).Once()
+	mockdb.On("UpdateArtifact", &model.Artifact{
+		Id: 20, BucketId: bucket.Id, Name: "appended", State: model.APPEND_COMPLETE, Size: 20, Version: 2,
+	}).Return(nil).Once()
+
+	// The second artifact was deleted, and is skipped.
+	mockdb.On("UpdateArtifact", mock.MatchedBy(func(a *model.Artifact) bool {
+		return a.Id == 21
+	})).Return(database.NewConflictError("Conflict")).Once()
+	mockdb.On("GetArtifactByName", bucket.Id, "deleted").Return(nil, database.NewEntityNotFoundError("ENF")).Once()
+
+	mockdb.On("UpdateArtifact", mock.MatchedBy(func(a *model.Artifact) bool {
+		return a.Id == 22 && a.State == model.CLOSED_WITHOUT_DATA
+	})).Return(nil).Once()
+
+	require.NoError(t, CloseBucket(nil, bucket, mockdb, mockClock))
+	require.Equal(t, model.CLOSED, bucket.State)
+
+	// An artifact which keeps changing is only retried a limited number of times.
+	bucket = &model.Bucket{State: model.OPEN, Id: "bucket_id_2"}
+	mockdb.On("UpdateBucket", bucket).Return(nil).Once()
+	mockdb.On("ListArtifactsInBucket", bucket.Id).Return([]model.Artifact{
+		{Id: 30, BucketId: bucket.Id, Name: "busy", State: model.APPENDING, Size: 10},
+	}, nil).Once()
+	mockdb.On("UpdateArtifact", mock.MatchedBy(func(a *model.Artifact) bool {
+		return a.Id == 30
+	})).Return(database.NewConflictError("Conflict")).Times(MaxCloseArtifactAttempts)
+	mockdb.On("GetArtifactByName", bucket.Id, "busy").Return(func(string, string) *model.Artifact {
+		return &model.Artifact{Id: 30, BucketId: bucket.Id, Name: "busy", State: model.APPENDING, Size: 10}
+	}, nil).Times(MaxCloseArtifactAttempts - 1)
+
+	require.Error(t, CloseBucket(nil, bucket, mockdb, mockClock))
+	// The bucket is closed all the same.
+	require.Equal(t, model.CLOSED, bucket.State)
+
 	mockdb.AssertExpectations(t)
 }
+
+func TestTimeoutBucket(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+
+	// If bucket is not currently open, return failure
+	require.Error(t, TimeoutBucket(nil, &model.Bucket{State: model.CLOSED}, mockdb, mockClock))
+	require.Error(t, TimeoutBucket(nil, &model.Bucket{State: model.TIMEDOUT}, mockdb, mockClock))
+
+	// Artifacts which were already finalized are left as is.
+	bucket := &model.Bucket{State: model.OPEN, Id: "bucket_id_1"}
+	mockdb.On("UpdateBucket", bucket).Return(nil).Once()
+	mockdb.On("ListArtifactsInBucket", bucket.Id).Return([]model.Artifact{
+		{Id: 20, State: model.UPLOADED},
+		{Id: 21, State: model.DEADLINE_EXCEEDED},
+		{Id: 22, State: model.CLOSED_WITHOUT_DATA},
+	}, nil).Once()
+
+	require.NoError(t, TimeoutBucket(nil, bucket, mockdb, mockClock))
+	require.Equal(t, model.TIMEDOUT, bucket.State)
+	require.Equal(t, mockClock.Now(), bucket.DateClosed)
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestDeleteBucket(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	bucket := &model.Bucket{State: model.CLOSED, Id: "bucket_id_1"}
+
+	// Nothing is deleted if an artifact is being uploaded.
+	mockdb.On("ListArtifactsInBucket", bucket.Id).Return([]model.Artifact{
+		{Id: 20, State: model.UPLOADED, S3URL: "/bucket_id_1/artifact"},
+		{Id: 21, State: model.UPLOADING},
+	}, nil).Once()
+	err := DeleteBucket(nil, bucket, mockdb, store)
+	require.Error(t, err)
+	require.Equal(t, http.StatusConflict, err.errCode)
+
+	require.NoError(t, store.Put("/bucket_id_1/artifact", bytes.NewBufferString("0123456789"), 10))
+	mockdb.On("ListArtifactsInBucket", bucket.Id).Return([]model.Artifact{
+		{Id: 20, State: model.UPLOADED, S3URL: "/bucket_id_1/artifact"},
+		{Id: 21, State: model.CLOSED_WITHOUT_DATA},
+	}, nil).Once()
+	mockdb.On("DeleteLogChunksForArtifact", int64(20)).Return(int64(0), nil).Once()
+	mockdb.On("DeleteArtifact", int64(20)).Return(nil).Once()
+	mockdb.On("DeleteLogChunksForArtifact", int64(21)).Return(int64(0), nil).Once()
+	mockdb.On("DeleteArtifact", int64(21)).Return(nil).Once()
+	mockdb.On("DeleteBucket", bucket.Id).Return(nil).Once()
+	require.Nil(t, DeleteBucket(nil, bucket, mockdb, store))
+
+	_, getErr := store.Get("/bucket_id_1/artifact")
+	require.Equal(t, storage.ErrNotExist, getErr)
+
+	// Database errors
+	mockdb.On("ListArtifactsInBucket", bucket.Id).Return(nil, database.MockDatabaseError()).Once()
+	err = DeleteBucket(nil, bucket, mockdb, store)
+	require.Error(t, err)
+	require.Equal(t, http.StatusInternalServerError, err.errCode)
+
+	mockdb.On("ListArtifactsInBucket", bucket.Id).Return([]model.Artifact{}, nil).Once()
+	mockdb.On("DeleteBucket", bucket.Id).Return(database.MockDatabaseError()).Once()
+	require.Error(t, DeleteBucket(nil, bucket, mockdb, store))
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestBucketFilterFromQuery(t *testing.T) {
+	filter, err := bucketFilterFromQuery(url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, database.BucketFilter{Limit: DefaultPageSize}, filter)
+
+	cursor := database.BucketCursor{DateCreated: time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC), Id: "b1"}
+	filter, err = bucketFilterFromQuery(url.Values{
+		"owner":         {"owner"},
+		"state":         {"CLOSED"},
+		"createdAfter":  {"2016-01-01T00:00:00Z"},
+		"createdBefore": {"2016-02-01T00:00:00Z"},
+		"cursor":        {encodeCursor(cursor)},
+		"limit":         {"10"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "owner", filter.Owner)
+	require.Equal(t, model.CLOSED, filter.State)
+	require.Equal(t, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), filter.CreatedAfter.UTC())
+	require.Equal(t, time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC), filter.CreatedBefore.UTC())
+	require.Equal(t, cursor.Id, filter.Cursor.Id)
+	require.True(t, cursor.DateCreated.Equal(filter.Cursor.DateCreated))
+	require.Equal(t, 10, filter.Limit)
+	require.False(t, filter.Descending)
+
+	filter, err = bucketFilterFromQuery(url.Values{
+		"sort":   {"-dateCreated"},
+		"cursor": {encodeCursor(bucketListCursor{Sort: "-dateCreated", BucketCursor: cursor})},
+	})
+	require.NoError(t, err)
+	require.True(t, filter.Descending)
+	require.Equal(t, cursor.Id, filter.Cursor.Id)
+
+	for _, query := range []url.Values{
+		{"sort": {"name"}},
+		{"sort": {"-dateCreated"}, "cursor": {encodeCursor(cursor)}},
+		{"state": {"BOGUS"}},
+		{"createdAfter": {"yesterday"}},
+		{"createdBefore": {"2016-01-01"}},
+		{"cursor": {"not a cursor"}},
+		{"limit": {"0"}},
+		{"limit": {fmt.Sprint(MaxPageSize + 1)}},
+		{"limit": {"ten"}},
+	} {
+		_, err = bucketFilterFromQuery(query)
+		require.Error(t, err, "Query %v should be rejected", query)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	require.Equal(t, http.StatusConflict, errorStatus(database.NewConflictError("Conflict"), http.StatusBadRequest))
+	require.Equal(t, http.StatusConflict, errorStatus(&model.IllegalTransitionError{From: model.UPLOADED, To: model.APPENDING}, http.StatusBadRequest))
+	require.Equal(t, http.StatusNotFound, errorStatus(NewHttpError(http.StatusNotFound, "Not found"), http.StatusBadRequest))
+	require.Equal(t, http.StatusBadRequest, errorStatus(database.MockDatabaseError(), http.StatusBadRequest))
+	require.Equal(t, http.StatusInternalServerError, errorStatus(fmt.Errorf("Error"), http.StatusInternalServerError))
+}
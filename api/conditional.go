@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dropbox/changes-artifacts/model"
+)
+
+// uploadedArtifactETag returns a strong ETag for the content of an UPLOADED artifact, which never
+// changes. The content digest is used if known, and the artifact id otherwise.
+func uploadedArtifactETag(artifact *model.Artifact) string {
+	if artifact.Sha256 != "" {
+		return `"` + artifact.Sha256 + `"`
+	}
+	return fmt.Sprintf(`"artifact-%d"`, artifact.Id)
+}
+
+// uploadedArtifactRangeETag returns a strong ETag for bytes begin through end of the content of an
+// UPLOADED artifact, which differs from the ETag of any other byte range.
+func uploadedArtifactRangeETag(artifact *model.Artifact, begin int64, end int64) string {
+	etag := uploadedArtifactETag(artifact)
+	return fmt.Sprintf(`%s-%d-%d"`, strings.TrimSuffix(etag, `"`), begin, end)
+}
+
+// setUploadedArtifactCacheHeaders sets the ETag (to etag) and Last-Modified headers for content of
+// an UPLOADED artifact, and returns true if the client already has that content according to the
+// conditional headers of req (in which case nothing else needs to be sent).
+func setUploadedArtifactCacheHeaders(req *http.Request, res http.ResponseWriter, artifact *model.Artifact, etag string) bool {
+	res.Header().Set("ETag", etag)
+	if !artifact.DateUpdated.IsZero() {
+		res.Header().Set("Last-Modified", artifact.DateUpdated.UTC().Format(http.TimeFormat))
+	}
+	return notModified(req, etag, artifact.DateUpdated)
+}
+
+// notModified returns true if the conditional headers of req show that the client already has the
+// version of a resource identified by etag, last modified at modtime. As in RFC 7232,
+// If-Modified-Since is ignored if If-None-Match is present.
+func notModified(req *http.Request, etag string, modtime time.Time) bool {
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			// Weak comparison, as appropriate for GET requests.
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := req.Header.Get("If-Modified-Since"); ifModifiedSince != "" && !modtime.IsZero() {
+		// HTTP dates have a resolution of one second.
+		if t, err := http.ParseTime(ifModifiedSince); err == nil && !modtime.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+	return false
+}
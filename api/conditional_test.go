@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotModified(t *testing.T) {
+	modtime := time.Date(2016, 1, 2, 3, 4, 5, 600, time.UTC)
+	newReq := func(header string, value string) *http.Request {
+		req, _ := http.NewRequest("GET", "/content", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		return req
+	}
+
+	require.False(t, notModified(newReq("", ""), `"abc"`, modtime))
+
+	require.True(t, notModified(newReq("If-None-Match", `"abc"`), `"abc"`, modtime))
+	require.True(t, notModified(newReq("If-None-Match", `"xyz", W/"abc"`), `"abc"`, modtime))
+	require.True(t, notModified(newReq("If-None-Match", "*"), `"abc"`, modtime))
+	require.False(t, notModified(newReq("If-None-Match", `"xyz"`), `"abc"`, modtime))
+
+	require.True(t, notModified(newReq("If-Modified-Since", "Sat, 02 Jan 2016 03:04:05 GMT"), `"abc"`, modtime))
+	require.True(t, notModified(newReq("If-Modified-Since", "Sun, 03 Jan 2016 00:00:00 GMT"), `"abc"`, modtime))
+	require.False(t, notModified(newReq("If-Modified-Since", "Sat, 02 Jan 2016 03:04:04 GMT"), `"abc"`, modtime))
+	require.False(t, notModified(newReq("If-Modified-Since", "Sat, 02 Jan 2016 03:04:05 GMT"), `"abc"`, time.Time{}))
+	require.False(t, notModified(newReq("If-Modified-Since", "garbage"), `"abc"`, modtime))
+
+	// If-Modified-Since is ignored when If-None-Match is present.
+	req := newReq("If-None-Match", `"xyz"`)
+	req.Header.Set("If-Modified-Since", "Sun, 03 Jan 2016 00:00:00 GMT")
+	require.False(t, notModified(req, `"abc"`, modtime))
+}
+
+func TestUploadedArtifactETag(t *testing.T) {
+	require.Equal(t, `"`+testContentSha256+`"`, uploadedArtifactETag(&model.Artifact{Id: 10, Sha256: testContentSha256}))
+	require.Equal(t, `"artifact-10"`, uploadedArtifactETag(&model.Artifact{Id: 10}))
+}
+
+func TestUploadedArtifactConditionalRequests(t *testing.T) {
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	require.NoError(t, store.Put("/bucketName/artifactName", bytes.NewBufferString("0123456789"), 10))
+	artifact := &model.Artifact{
+		Id:           10,
+		State:        model.UPLOADED,
+		Size:         10,
+		Name:         "artifactName",
+		BucketId:     "bucketName",
+		S3URL:        "/bucketName/artifactName",
+		RelativePath: "artifactName",
+		DateUpdated:  time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	req, _ := http.NewRequest("GET", "/content", nil)
+	w := httptest.NewRecorder()
+	GetArtifactContent(context.Background(), nil, req, w, nil, store, artifact)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `"artifact-10"`, w.Header().Get("ETag"))
+	require.Equal(t, "Sat, 02 Jan 2016 03:04:05 GMT", w.Header().Get("Last-Modified"))
+
+	req.Header.Set("If-Modified-Since", "Sat, 02 Jan 2016 03:04:05 GMT")
+	w = httptest.NewRecorder()
+	GetArtifactContent(context.Background(), nil, req, w, nil, store, artifact)
+	require.Equal(t, http.StatusNotModified, w.Code)
+
+	// Chunks of the artifact have an ETag for each byte range.
+	req, _ = http.NewRequest("GET", "/chunked?offset=2", nil)
+	req.Header.Set("If-None-Match", `"artifact-10-2-9"`)
+	w = httptest.NewRecorder()
+	GetArtifactContentChunks(context.Background(), nil, req, w, nil, store, nil, artifact)
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Equal(t, `"artifact-10-2-9"`, w.Header().Get("ETag"))
+	require.Equal(t, "Sat, 02 Jan 2016 03:04:05 GMT", w.Header().Get("Last-Modified"))
+	require.Empty(t, w.Body.String())
+
+	// ETags of the whole artifact, or of other byte ranges, don't match.
+	for _, etag := range []string{`"artifact-10"`, `"artifact-10-0-9"`} {
+		req.Header.Set("If-None-Match", etag)
+		require.False(t, setUploadedArtifactCacheHeaders(req, httptest.NewRecorder(), artifact, uploadedArtifactRangeETag(artifact, 2, 9)), "ETag %s should not match", etag)
+	}
+}
+
+func TestUploadedArtifactRangeETag(t *testing.T) {
+	artifact := &model.Artifact{Id: 10}
+	require.Equal(t, `"artifact-10-0-99"`, uploadedArtifactRangeETag(artifact, 0, 99))
+	artifact.Sha256 = "abcd"
+	require.Equal(t, `"abcd-100-199"`, uploadedArtifactRangeETag(artifact, 100, 199))
+}
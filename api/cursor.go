@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// NextCursorHeader is the response header carrying the cursor for the next page of a paginated
+// listing. It is not set on the last page. To fetch the next page, repeat the request with the
+// cursor in the "cursor" query parameter.
+const NextCursorHeader = "X-Next-Cursor"
+
+// DefaultPageSize is the number of entries in a page of a paginated listing, if not specified.
+const DefaultPageSize = 25
+
+// MaxPageSize is the maximum number of entries in a page of a paginated listing.
+const MaxPageSize = 1000
+
+// encodeCursor encodes a position in a listing as an opaque string which can be handed to clients.
+func encodeCursor(position interface{}) string {
+	b, err := json.Marshal(position)
+	if err != nil {
+		// Positions are plain structs, this should never happen.
+		panic(err)
+	}
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// decodeCursor decodes a cursor generated by encodeCursor into position.
+func decodeCursor(cursor string, position interface{}) error {
+	b, err := base64.URLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(b, position)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid cursor %q", cursor)
+	}
+	return nil
+}
+
+// pageSizeParam parses the "limit" query parameter, which must be between 1 and MaxPageSize.
+func pageSizeParam(value string) (int, error) {
+	if value == "" {
+		return DefaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > MaxPageSize {
+		return 0, fmt.Errorf("Invalid limit %q, must be between 1 and %d", value, MaxPageSize)
+	}
+	return limit, nil
+}
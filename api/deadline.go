@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/common/sentry"
+	"github.com/dropbox/changes-artifacts/common/stats"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+)
+
+// DefaultDeadlineCheckInterval is the default interval between two scans for expired buckets and
+// artifacts.
+const DefaultDeadlineCheckInterval = 1 * time.Minute
+
+var bucketsTimedOutCounter = stats.NewStat("buckets_timedout")
+var artifactsExpiredCounter = stats.NewStat("artifacts_deadline_exceeded")
+var artifactsSalvagedCounter = stats.NewStat("artifacts_deadline_salvaged")
+
+// DeadlineEnforcer periodically scans for buckets and artifacts which were not completed within
+// their deadline (DeadlineMins after creation) and finalizes them, so that abandoned buckets and
+// artifacts (and their logchunks) don't linger in the database forever.
+type DeadlineEnforcer struct {
+	ctx  context.Context
+	db   database.Database
+	clk  common.Clock
+	task *common.PeriodicTask
+}
+
+// NewDeadlineEnforcer creates a DeadlineEnforcer which checks for expired buckets and artifacts
+// every interval once started.
+func NewDeadlineEnforcer(ctx context.Context, db database.Database, clk common.Clock, interval time.Duration) *DeadlineEnforcer {
+	de := &DeadlineEnforcer{ctx: ctx, db: db, clk: clk}
+	de.task = common.NewPeriodicTask(clk, interval, de.run)
+	return de
+}
+
+// Start begins periodic deadline checks in the background.
+func (de *DeadlineEnforcer) Start() {
+	de.task.Start()
+}
+
+// Stop terminates periodic deadline checks.
+func (de *DeadlineEnforcer) Stop() {
+	de.task.Stop()
+}
+
+func (de *DeadlineEnforcer) run() {
+	if err := de.EnforceDeadlines(); err != nil {
+		sentry.ReportError(de.ctx, err)
+	}
+}
+
+// EnforceDeadlines times out all open buckets which have exceeded their deadline, and then
+// finalizes all artifacts which have exceeded their deadline. Errors while finalizing individual
+// buckets or artifacts are reported and do not prevent processing remaining ones.
+func (de *DeadlineEnforcer) EnforceDeadlines() error {
+	now := de.clk.Now()
+
+	buckets, err := de.db.ListBucketsPastDeadline(now)
+	if err != nil {
+		return err
+	}
+
+	for i := range buckets {
+		if err := TimeoutBucket(de.ctx, &buckets[i], de.db, de.clk); err != nil {
+			sentry.ReportError(de.ctx, fmt.Errorf("Error timing out bucket %s: %s", buckets[i].Id, err))
+			continue
+		}
+		bucketsTimedOutCounter.Add(1)
+	}
+
+	artifacts, err := de.db.ListArtifactsPastDeadline(now)
+	if err != nil {
+		return err
+	}
+
+	for i := range artifacts {
+		if err := ExpireArtifact(de.ctx, &artifacts[i], de.db); err != nil {
+			sentry.ReportError(de.ctx, fmt.Errorf("Error expiring artifact %s/%s: %s", artifacts[i].BucketId, artifacts[i].Name, err))
+		}
+	}
+
+	return nil
+}
+
+// ExpireArtifact finalizes an artifact which has exceeded its deadline.
+//
+// If a chunked artifact has received any content, it is closed (and later merged) as is, so that
+// the partial log is still available. All other artifacts are moved to DEADLINE_EXCEEDED.
+func ExpireArtifact(ctx context.Context, artifact *model.Artifact, db database.Database) error {
+	switch artifact.State {
+	case model.APPENDING:
+		if artifact.Size > 0 {
+			artifactsSalvagedCounter.Add(1)
+			return CloseArtifact(ctx, artifact, db, false)
+		}
+	case model.WAITING_FOR_UPLOAD:
+	default:
+		return fmt.Errorf("Unexpected artifact state for expiry: %s", artifact.State)
+	}
+
+	if err := updateArtifactState(db, artifact, model.DEADLINE_EXCEEDED); err != nil {
+		return err
+	}
+
+	artifactsExpiredCounter.Add(1)
+	return nil
+}
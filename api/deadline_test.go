@@ -0,0 +1,100 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpireArtifact(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+
+	// Only artifacts open for writes can expire.
+	require.Error(t, ExpireArtifact(context.Background(), &model.Artifact{State: model.UPLOADED}, mockdb))
+	require.Error(t, ExpireArtifact(context.Background(), &model.Artifact{State: model.APPEND_COMPLETE}, mockdb))
+
+	// Streamed artifact which never got uploaded.
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 1, State: model.DEADLINE_EXCEEDED, Size: 10}).Return(nil).Once()
+	require.NoError(t, ExpireArtifact(context.Background(), &model.Artifact{Id: 1, State: model.WAITING_FOR_UPLOAD, Size: 10}, mockdb))
+
+	// Chunked artifact without any content.
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 2, State: model.DEADLINE_EXCEEDED}).Return(nil).Once()
+	require.NoError(t, ExpireArtifact(context.Background(), &model.Artifact{Id: 2, State: model.APPENDING}, mockdb))
+
+	// DB error
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 3, State: model.DEADLINE_EXCEEDED}).Return(database.MockDatabaseError()).Once()
+	require.Error(t, ExpireArtifact(context.Background(), &model.Artifact{Id: 3, State: model.APPENDING}, mockdb))
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestExpireArtifactWithContent(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+
+	// Chunked artifact with some content is closed (to be merged later) instead of being discarded.
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 4, State: model.APPEND_COMPLETE, Size: 5}).Return(nil).Once()
+	require.NoError(t, ExpireArtifact(context.Background(), &model.Artifact{Id: 4, State: model.APPENDING, Size: 5}, mockdb))
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestDeadlineEnforcer(t *testing.T) {
+	const interval = 10 * time.Second
+
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+	mockClock.On("AfterFunc", interval, mock.Anything).Return()
+
+	de := NewDeadlineEnforcer(context.Background(), mockdb, mockClock, interval)
+	de.Start()
+
+	// Nothing happens before the check interval elapses.
+	mockClock.Advance(interval / 2)
+	mockdb.AssertExpectations(t)
+
+	// DB errors are reported and checks continue in the next interval.
+	mockdb.On("ListBucketsPastDeadline", mock.AnythingOfType("time.Time")).Return(nil, database.MockDatabaseError()).Once()
+	mockClock.Advance(interval)
+	mockdb.AssertExpectations(t)
+
+	mockdb.On("ListBucketsPastDeadline", mock.AnythingOfType("time.Time")).Return([]model.Bucket{}, nil).Once()
+	mockdb.On("ListArtifactsPastDeadline", mock.AnythingOfType("time.Time")).Return(nil, database.MockDatabaseError()).Once()
+	mockClock.Advance(interval + time.Second)
+	mockdb.AssertExpectations(t)
+
+	now := mockClock.Now().Add(interval + time.Second)
+	// Buckets are timed out before expiring artifacts. Artifacts of timed out buckets are closed
+	// along with the bucket.
+	mockdb.On("ListBucketsPastDeadline", now).Return([]model.Bucket{
+		{Id: "b1", State: model.OPEN},
+		{Id: "b2", State: model.OPEN},
+	}, nil).Once()
+	mockdb.On("UpdateBucket", &model.Bucket{Id: "b1", State: model.TIMEDOUT, DateClosed: now}).Return(database.MockDatabaseError()).Once()
+	mockdb.On("UpdateBucket", &model.Bucket{Id: "b2", State: model.TIMEDOUT, DateClosed: now}).Return(nil).Once()
+	mockdb.On("ListArtifactsInBucket", "b2").Return([]model.Artifact{
+		{Id: 3, BucketId: "b2", State: model.WAITING_FOR_UPLOAD},
+	}, nil).Once()
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 3, BucketId: "b2", State: model.CLOSED_WITHOUT_DATA}).Return(nil).Once()
+
+	mockdb.On("ListArtifactsPastDeadline", now).Return([]model.Artifact{
+		{Id: 1, State: model.WAITING_FOR_UPLOAD},
+		{Id: 2, State: model.APPENDING},
+	}, nil).Once()
+	// Failure to expire one artifact doesn't prevent expiring others.
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 1, State: model.DEADLINE_EXCEEDED}).Return(database.MockDatabaseError()).Once()
+	mockdb.On("UpdateArtifact", &model.Artifact{Id: 2, State: model.DEADLINE_EXCEEDED}).Return(nil).Once()
+	mockClock.Advance(interval + time.Second)
+	mockdb.AssertExpectations(t)
+
+	// No more checks after the enforcer is stopped.
+	de.Stop()
+	mockClock.Advance(2 * interval)
+	mockdb.AssertExpectations(t)
+}
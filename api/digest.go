@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// DigestHeader is the HTTP header (RFC 3230) used to send the SHA-256 digest of artifact content,
+// both by clients uploading an artifact and by the server when serving artifact content. Values
+// look like "SHA-256=<base64 encoded digest>".
+const DigestHeader = "Digest"
+
+const sha256DigestAlgorithm = "SHA-256"
+
+// formatSha256Digest formats a hex encoded SHA-256 digest as a Digest header value.
+func formatSha256Digest(hexDigest string) string {
+	digest, err := hex.DecodeString(hexDigest)
+	if err != nil {
+		return ""
+	}
+	return sha256DigestAlgorithm + "=" + base64.StdEncoding.EncodeToString(digest)
+}
+
+// parseSha256Digest extracts the SHA-256 digest from a Digest header value, which may list digests
+// computed with several algorithms. The digest is returned hex encoded, as stored in
+// model.Artifact. If there is no SHA-256 digest in the header, "" is returned.
+func parseSha256Digest(header string) (string, error) {
+	for _, instance := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(instance), "=", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], sha256DigestAlgorithm) {
+			continue
+		}
+
+		digest, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(digest) != 32 {
+			return "", fmt.Errorf("Invalid SHA-256 digest %q", parts[1])
+		}
+		return hex.EncodeToString(digest), nil
+	}
+
+	return "", nil
+}
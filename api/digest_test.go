@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSha256DigestHeader(t *testing.T) {
+	header := formatSha256Digest(testContentSha256)
+	require.Equal(t, "SHA-256=hNiYd/DUBB77a/kaFvAkjy/Vc+avBcGflr7bn4gveII=", header)
+
+	digest, err := parseSha256Digest(header)
+	require.NoError(t, err)
+	require.Equal(t, testContentSha256, digest)
+
+	// Other algorithms are ignored.
+	digest, err = parseSha256Digest("MD5=eB5eJF1ptWaXm4bijSPyxw==, sha-256=hNiYd/DUBB77a/kaFvAkjy/Vc+avBcGflr7bn4gveII=")
+	require.NoError(t, err)
+	require.Equal(t, testContentSha256, digest)
+
+	digest, err = parseSha256Digest("MD5=eB5eJF1ptWaXm4bijSPyxw==")
+	require.NoError(t, err)
+	require.Equal(t, "", digest)
+
+	digest, err = parseSha256Digest("")
+	require.NoError(t, err)
+	require.Equal(t, "", digest)
+
+	// Not base64
+	_, err = parseSha256Digest("SHA-256=????")
+	require.Error(t, err)
+
+	// Wrong length
+	_, err = parseSha256Digest("SHA-256=eB5eJF1ptWaXm4bijSPyxw==")
+	require.Error(t, err)
+}
@@ -26,8 +26,8 @@ type logChunkReader struct {
 	offset    int64
 	readAhead int64
 	unread    bytes.Buffer // Readahead buffer of bytes which were read from DB but not yet read by client.
-	// This can be replaced with a global cache because logchunks are immutable to reduce even more
-	// hits to the DB.
+	// Logchunks are immutable, so db may also serve them from a cache (see
+	// database.LogChunkCachingDatabase).
 }
 
 func newLogChunkReader(artifact *model.Artifact, db database.Database) *logChunkReader {
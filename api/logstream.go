@@ -0,0 +1,241 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common/sentry"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
+	"github.com/martini-contrib/render"
+)
+
+// StreamHeartbeatInterval is the longest an artifact stream stays silent. The artifact is also
+// looked up again after every heartbeat, in case a change notification was lost.
+const StreamHeartbeatInterval = 15 * time.Second
+
+// StreamArtifactContent streams the content of an artifact as Server-Sent Events, beginning at the
+// "offset" query parameter, or at the Last-Event-ID header when a client reconnects.
+//
+// Content is sent as soon as it is available, in "data" events with a JSON payload of the form
+// {"offset": ..., "size": ..., "text": ...}, and with the offset of the next byte as event id.
+// Once the artifact is closed and all of its content has been sent, a final "eof" event carrying
+// the state and size of the artifact is sent and the stream ends. If the artifact is deleted
+// instead, the stream ends with an "error" event saying so.
+func StreamArtifactContent(ctx context.Context, r render.Render, req *http.Request, res http.ResponseWriter, db database.Database, store storage.BlobStore, broker *ArtifactBroker, artifact *model.Artifact) {
+	if artifact == nil {
+		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No artifact specified")
+		return
+	}
+
+	offset := intParam(req.URL.Query(), "offset", 0)
+	if lastEventID := req.Header.Get("Last-Event-ID"); lastEventID != "" {
+		var err error
+		if offset, err = strconv.ParseInt(lastEventID, 10, 64); err != nil {
+			RespondWithErrorf(ctx, r, http.StatusBadRequest, "Invalid Last-Event-ID %q", lastEventID)
+			return
+		}
+	}
+	if offset < 0 {
+		RespondWithErrorf(ctx, r, http.StatusBadRequest, "Invalid offset %d", offset)
+		return
+	}
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		LogAndRespondWithErrorf(ctx, r, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	var disconnected <-chan bool
+	if cn, ok := res.(http.CloseNotifier); ok {
+		disconnected = cn.CloseNotify()
+	}
+
+	// Subscribe before reading any content, so that no change is missed in between.
+	changed, cancel := broker.Subscribe(artifact.Id)
+	defer cancel()
+
+	heartbeat := time.NewTicker(StreamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+
+	// Once streaming has started, it is too late to change the response status. Errors are reported
+	// in an "error" event instead, which also ends the stream.
+	fail := func(err error) {
+		sentry.ReportError(ctx, err)
+		writeStreamEvent(res, "error", "", map[string]string{"error": err.Error()})
+		flusher.Flush()
+	}
+
+	s := &artifactStream{w: res, db: db, store: store, artifact: artifact, offset: offset}
+	for {
+		done, err := s.sendAvailable()
+		if err != nil {
+			fail(err)
+			return
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			if _, err := io.WriteString(res, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-disconnected:
+			return
+		}
+
+		latest, dbErr := db.GetArtifactByName(artifact.BucketId, artifact.Name)
+		if (dbErr != nil && dbErr.EntityNotFound()) || (dbErr == nil && latest.Id != artifact.Id) {
+			// Not an error on our side, so it isn't reported. An artifact since created with the same
+			// name is a different artifact, which isn't followed.
+			writeStreamEvent(res, "error", "", map[string]string{"error": fmt.Sprintf("Artifact %s/%s was deleted", artifact.BucketId, artifact.Name)})
+			flusher.Flush()
+			return
+		}
+		if dbErr != nil {
+			fail(dbErr)
+			return
+		}
+		s.artifact = latest
+	}
+}
+
+// artifactStream tracks how much of an artifact has been sent on a stream.
+type artifactStream struct {
+	w        io.Writer
+	db       database.Database
+	store    storage.BlobStore
+	artifact *model.Artifact
+	offset   int64
+}
+
+// sendAvailable sends all content of the artifact past the current offset, followed by an "eof"
+// event if no more content will ever be available. It returns true if the stream is complete.
+func (s *artifactStream) sendAvailable() (bool, error) {
+	type Chunk struct {
+		Offset int64  `json:"offset"`
+		Size   int64  `json:"size"`
+		Text   string `json:"text"`
+	}
+
+	type EOF struct {
+		State string `json:"state"`
+		Size  int64  `json:"size"`
+	}
+
+	for s.hasContent() && s.offset < s.artifact.Size {
+		p, err := s.read(s.offset, min(s.artifact.Size-1, s.offset+MaxChunkedRequestBytes-1))
+		if err != nil {
+			return false, err
+		}
+
+		// Don't split a multi-byte character across events while the rest of it may still come.
+		n := len(p)
+		if s.offset+int64(n) < s.artifact.Size || s.artifact.State == model.APPENDING {
+			n = completeRunesLen(p)
+		}
+		if n == 0 {
+			break
+		}
+
+		chunk := &Chunk{Offset: s.offset, Size: int64(n), Text: string(p[:n])}
+		s.offset += int64(n)
+		if err := writeStreamEvent(s.w, "", strconv.FormatInt(s.offset, 10), chunk); err != nil {
+			return false, err
+		}
+	}
+
+	if !s.artifact.State.IsTerminal() || (s.artifact.State == model.UPLOADED && s.offset < s.artifact.Size) {
+		return false, nil
+	}
+
+	return true, writeStreamEvent(s.w, "eof", "", &EOF{State: s.artifact.State.String(), Size: s.artifact.Size})
+}
+
+// hasContent returns true if content of the artifact can be read in its current state.
+func (s *artifactStream) hasContent() bool {
+	switch s.artifact.State {
+	case model.APPENDING, model.APPEND_COMPLETE, model.UPLOADED:
+		return true
+	}
+	return false
+}
+
+// read returns bytes begin through end (both inclusive) of the artifact, from log chunks or the
+// blob store depending on artifact state.
+func (s *artifactStream) read(begin int64, end int64) ([]byte, error) {
+	var rd io.Reader
+	if s.artifact.State == model.UPLOADED {
+		rc, err := storage.GetVersionRange(s.store, artifactBlob(s.artifact), begin, end)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		rd = rc
+	} else {
+		lcr := newLogChunkReader(s.artifact, s.db)
+		if _, err := lcr.Seek(begin, os.SEEK_SET); err != nil {
+			return nil, err
+		}
+		rd = lcr
+	}
+
+	p := make([]byte, end-begin+1)
+	n, err := io.ReadFull(rd, p)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return p[:n], err
+}
+
+// completeRunesLen returns the length of p, excluding an incomplete UTF-8 character at its end.
+func completeRunesLen(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if utf8.FullRune(p[i:]) {
+				return len(p)
+			}
+			return i
+		}
+	}
+	return len(p)
+}
+
+// writeStreamEvent writes a Server-Sent Event with a JSON-serialized payload. Event type and id are
+// omitted if empty.
+func writeStreamEvent(w io.Writer, event string, id string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var msg string
+	if event != "" {
+		msg += "event: " + event + "\n"
+	}
+	if id != "" {
+		msg += "id: " + id + "\n"
+	}
+	msg += "data: " + string(payload) + "\n\n"
+
+	_, err = io.WriteString(w, msg)
+	return err
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamArtifactContent(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	broker := NewArtifactBroker()
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	artifact := &model.Artifact{
+		Id:       10,
+		Name:     "aName",
+		BucketId: "bName",
+		State:    model.APPENDING,
+		Size:     5,
+	}
+
+	// Content appended so far is sent right away. The artifact is then uploaded, which notifies the
+	// stream to send the remaining content from the blob store.
+	mockdb.On("ListLogChunksInArtifact", int64(10), int64(0), int64(5)).Return([]model.LogChunk{
+		{ArtifactId: 10, ByteOffset: 0, Size: 5, ContentBytes: []byte("hello")},
+	}, nil).Run(func(mock.Arguments) { broker.Publish(10) }).Once()
+
+	require.NoError(t, store.Put("/bName/aName", bytes.NewBufferString("hello world"), 11))
+	mockdb.On("GetArtifactByName", "bName", "aName").Return(&model.Artifact{
+		Id:       10,
+		Name:     "aName",
+		BucketId: "bName",
+		State:    model.UPLOADED,
+		Size:     11,
+		S3URL:    "/bName/aName",
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/stream", nil)
+	w := httptest.NewRecorder()
+	StreamArtifactContent(context.Background(), nil, req, w, mockdb, store, broker, artifact)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	require.Equal(t, "id: 5\n"+
+		`data: {"offset":0,"size":5,"text":"hello"}`+"\n\n"+
+		"id: 11\n"+
+		`data: {"offset":5,"size":6,"text":" world"}`+"\n\n"+
+		"event: eof\n"+
+		`data: {"state":"UPLOADED","size":11}`+"\n\n", w.Body.String())
+	mockdb.AssertExpectations(t)
+	require.Empty(t, broker.subs)
+
+	// Reconnecting client resumes from the last event it received.
+	uploaded := &model.Artifact{Id: 10, Name: "aName", BucketId: "bName", State: model.UPLOADED, Size: 11, S3URL: "/bName/aName"}
+	req, _ = http.NewRequest("GET", "/stream?offset=0", nil)
+	req.Header.Set("Last-Event-ID", "6")
+	w = httptest.NewRecorder()
+	StreamArtifactContent(context.Background(), nil, req, w, mockdb, store, broker, uploaded)
+	require.Equal(t, "id: 11\n"+
+		`data: {"offset":6,"size":5,"text":"world"}`+"\n\n"+
+		"event: eof\n"+
+		`data: {"state":"UPLOADED","size":11}`+"\n\n", w.Body.String())
+
+	// Artifacts closed without content end the stream right away.
+	req, _ = http.NewRequest("GET", "/stream", nil)
+	w = httptest.NewRecorder()
+	StreamArtifactContent(context.Background(), nil, req, w, mockdb, store, broker, &model.Artifact{Id: 11, State: model.CLOSED_WITHOUT_DATA})
+	require.Equal(t, "event: eof\n"+`data: {"state":"CLOSED_WITHOUT_DATA","size":0}`+"\n\n", w.Body.String())
+}
+
+func TestStreamArtifactContentDeleted(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	broker := NewArtifactBroker()
+
+	artifact := &model.Artifact{Id: 10, Name: "aName", BucketId: "bName", State: model.WAITING_FOR_UPLOAD}
+	mockdb.On("GetArtifactByName", "bName", "aName").Return(nil, database.NewEntityNotFoundError("Entity not found")).Once()
+
+	// Nothing to send until the artifact changes (here, by being deleted).
+	done := make(chan struct{})
+	w := httptest.NewRecorder()
+	go func() {
+		req, _ := http.NewRequest("GET", "/stream", nil)
+		StreamArtifactContent(context.Background(), nil, req, w, mockdb, nil, broker, artifact)
+		close(done)
+	}()
+
+	for !hasSubscribers(broker, 10) {
+		time.Sleep(time.Millisecond)
+	}
+	broker.Publish(10)
+	<-done
+	require.Equal(t, "event: error\n"+`data: {"error":"Artifact bName/aName was deleted"}`+"\n\n", w.Body.String())
+	mockdb.AssertExpectations(t)
+}
+
+func TestStreamArtifactContentRecreated(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	broker := NewArtifactBroker()
+
+	// An artifact with the same name, but a different id, is not followed.
+	artifact := &model.Artifact{Id: 10, Name: "aName", BucketId: "bName", State: model.WAITING_FOR_UPLOAD}
+	mockdb.On("GetArtifactByName", "bName", "aName").Return(&model.Artifact{
+		Id: 11, Name: "aName", BucketId: "bName", State: model.APPENDING, Size: 5,
+	}, nil).Once()
+
+	done := make(chan struct{})
+	w := httptest.NewRecorder()
+	go func() {
+		req, _ := http.NewRequest("GET", "/stream", nil)
+		StreamArtifactContent(context.Background(), nil, req, w, mockdb, nil, broker, artifact)
+		close(done)
+	}()
+
+	for !hasSubscribers(broker, 10) {
+		time.Sleep(time.Millisecond)
+	}
+	broker.Publish(10)
+	<-done
+	require.Equal(t, "event: error\n"+`data: {"error":"Artifact bName/aName was deleted"}`+"\n\n", w.Body.String())
+	mockdb.AssertExpectations(t)
+}
+
+func hasSubscribers(b *ArtifactBroker, artifactID int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs[artifactID]) > 0
+}
+
+func TestCompleteRunesLen(t *testing.T) {
+	require.Equal(t, 0, completeRunesLen([]byte{}))
+	require.Equal(t, 5, completeRunesLen([]byte("hello")))
+	require.Equal(t, 6, completeRunesLen([]byte("héllo")))
+	// "é" is encoded as 0xc3 0xa9.
+	require.Equal(t, 1, completeRunesLen([]byte("hé")[:2]))
+	require.Equal(t, 0, completeRunesLen([]byte("€")[:2]))
+	// Invalid bytes are never going to become a valid character.
+	require.Equal(t, 2, completeRunesLen([]byte{'a', 0xff}))
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/common/sentry"
+	"github.com/dropbox/changes-artifacts/common/stats"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
+)
+
+// DefaultMergeWorkers is the default number of concurrent merge workers.
+const DefaultMergeWorkers = 4
+
+// DefaultMergePollInterval is the default interval between two checks for closed artifacts, when
+// a merge worker is idle.
+const DefaultMergePollInterval = 1 * time.Second
+
+// Number of candidate artifacts fetched from the DB while looking for an artifact to merge.
+const mergeClaimBatchSize = 10
+
+var artifactsMergedCounter = stats.NewStat("artifacts_merged")
+var artifactsMergeFailedCounter = stats.NewStat("artifacts_merge_failed")
+
+// MergeWorkerPool merges and uploads chunked artifacts which have been closed (APPEND_COMPLETE),
+// in the background. Workers claim artifacts through the database, so multiple servers can run
+// worker pools against the same database without merging any artifact twice.
+//
+// Each worker looks for closed artifacts every pollInterval, and keeps merging artifacts until
+// there are none left.
+type MergeWorkerPool struct {
+	ctx     context.Context
+	db      database.Database
+	store   storage.BlobStore
+	workers []*common.PeriodicTask
+
+	lock    sync.Mutex
+	stopped bool
+}
+
+// NewMergeWorkerPool creates a pool of concurrency merge workers.
+func NewMergeWorkerPool(ctx context.Context, db database.Database, store storage.BlobStore, clk common.Clock, concurrency int, pollInterval time.Duration) *MergeWorkerPool {
+	p := &MergeWorkerPool{ctx: ctx, db: db, store: store}
+	for i := 0; i < concurrency; i++ {
+		p.workers = append(p.workers, common.NewPeriodicTask(clk, pollInterval, p.run))
+	}
+	return p
+}
+
+// Start starts all workers in the pool.
+func (p *MergeWorkerPool) Start() {
+	p.lock.Lock()
+	p.stopped = false
+	p.lock.Unlock()
+
+	for _, w := range p.workers {
+		w.Start()
+	}
+}
+
+// Stop stops all workers in the pool. Merges which are already in progress are allowed to
+// complete, but no new artifacts are claimed.
+func (p *MergeWorkerPool) Stop() {
+	p.lock.Lock()
+	p.stopped = true
+	p.lock.Unlock()
+
+	for _, w := range p.workers {
+		w.Stop()
+	}
+}
+
+func (p *MergeWorkerPool) isStopped() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.stopped
+}
+
+func (p *MergeWorkerPool) run() {
+	for !p.isStopped() {
+		merged, err := p.MergeNext()
+		if err != nil {
+			sentry.ReportError(p.ctx, err)
+		}
+		if !merged {
+			return
+		}
+	}
+}
+
+// MergeNext claims one closed artifact, and merges and uploads it. Returns true if an artifact was
+// claimed (even if it could not be merged), false if there was nothing to merge.
+func (p *MergeWorkerPool) MergeNext() (bool, error) {
+	artifacts, err := p.db.ListArtifactsInState(model.APPEND_COMPLETE, mergeClaimBatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range artifacts {
+		artifact := &artifacts[i]
+		if claimed, err := claimArtifactForMerge(p.db, artifact); err != nil {
+			return false, err
+		} else if !claimed {
+			// Someone else got to it first.
+			continue
+		}
+
+		if err := mergeClaimedArtifact(p.ctx, artifact, p.db, p.store); err != nil {
+			artifactsMergeFailedCounter.Add(1)
+			return true, fmt.Errorf("Error merging artifact %s/%s: %s", artifact.BucketId, artifact.Name, err)
+		}
+
+		artifactsMergedCounter.Add(1)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// claimArtifactForMerge moves a closed artifact out of APPEND_COMPLETE, so that no other worker
+// picks it up. Empty artifacts are finalized right away as CLOSED_WITHOUT_DATA. Returns false if
+// the artifact was no longer in APPEND_COMPLETE state.
+func claimArtifactForMerge(db database.Database, artifact *model.Artifact) (bool, error) {
+	newState := model.UPLOADING
+	if artifact.Size == 0 {
+		// If the file is empty, don't bother creating an object in the blob store.
+		newState = model.CLOSED_WITHOUT_DATA
+	}
+	if err := model.ValidateArtifactTransition(model.APPEND_COMPLETE, newState); err != nil {
+		return false, err
+	}
+
+	version, err := db.CompareAndSwapArtifactState(artifact.Id, model.APPEND_COMPLETE, newState)
+	if err != nil {
+		if err.Conflict() || err.EntityNotFound() {
+			// Claimed by someone else, or deleted.
+			return false, nil
+		}
+		return false, err
+	}
+
+	artifact.State = newState
+	artifact.Version = version
+	return true, nil
+}
+
+func mergeClaimedArtifact(ctx context.Context, artifact *model.Artifact, db database.Database, store storage.BlobStore) error {
+	if artifact.State != model.UPLOADING {
+		// Nothing to upload.
+		return nil
+	}
+
+	return uploadLogChunks(ctx, artifact, db, store)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeNext(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	pool := NewMergeWorkerPool(context.Background(), mockdb, nil, nil, 0, 0)
+
+	// DB error while looking for artifacts
+	mockdb.On("ListArtifactsInState", model.APPEND_COMPLETE, mergeClaimBatchSize).Return(nil, database.MockDatabaseError()).Once()
+	merged, err := pool.MergeNext()
+	require.False(t, merged)
+	require.Error(t, err)
+
+	// Nothing to merge
+	mockdb.On("ListArtifactsInState", model.APPEND_COMPLETE, mergeClaimBatchSize).Return([]model.Artifact{}, nil).Once()
+	merged, err = pool.MergeNext()
+	require.False(t, merged)
+	require.NoError(t, err)
+
+	// DB error while claiming artifact
+	mockdb.On("ListArtifactsInState", model.APPEND_COMPLETE, mergeClaimBatchSize).Return([]model.Artifact{
+		{Id: 1, State: model.APPEND_COMPLETE, Size: 10},
+	}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(1), model.APPEND_COMPLETE, model.UPLOADING).Return(int64(0), database.MockDatabaseError()).Once()
+	merged, err = pool.MergeNext()
+	require.False(t, merged)
+	require.Error(t, err)
+
+	// Artifact claimed by someone else is skipped. Empty artifact is closed without uploading.
+	mockdb.On("ListArtifactsInState", model.APPEND_COMPLETE, mergeClaimBatchSize).Return([]model.Artifact{
+		{Id: 1, State: model.APPEND_COMPLETE, Size: 10},
+		{Id: 2, State: model.APPEND_COMPLETE, Size: 0},
+	}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(1), model.APPEND_COMPLETE, model.UPLOADING).Return(int64(0), database.NewConflictError("Conflict")).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(2), model.APPEND_COMPLETE, model.CLOSED_WITHOUT_DATA).Return(int64(2), nil).Once()
+	merged, err = pool.MergeNext()
+	require.True(t, merged)
+	require.NoError(t, err)
+
+	// All candidates claimed by someone else, or deleted
+	mockdb.On("ListArtifactsInState", model.APPEND_COMPLETE, mergeClaimBatchSize).Return([]model.Artifact{
+		{Id: 1, State: model.APPEND_COMPLETE, Size: 10},
+		{Id: 3, State: model.APPEND_COMPLETE, Size: 10},
+	}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(1), model.APPEND_COMPLETE, model.UPLOADING).Return(int64(0), database.NewConflictError("Conflict")).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(3), model.APPEND_COMPLETE, model.UPLOADING).Return(int64(0), database.NewEntityNotFoundError("ENF")).Once()
+	merged, err = pool.MergeNext()
+	require.False(t, merged)
+	require.NoError(t, err)
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestMergeNextWithContent(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockTxs(mockdb)
+	s3Server, s3Bucket := testS3ServerWithBucket(t)
+	defer s3Server.Quit()
+	pool := NewMergeWorkerPool(context.Background(), mockdb, s3Bucket, nil, 0, 0)
+
+	mockdb.On("ListArtifactsInState", model.APPEND_COMPLETE, mergeClaimBatchSize).Return([]model.Artifact{
+		{
+			Id:       3,
+			State:    model.APPEND_COMPLETE,
+			Size:     10,
+			Name:     "TestMergeNext__artifactName",
+			BucketId: "TestMergeNext__bucketName",
+		},
+	}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(3), model.APPEND_COMPLETE, model.UPLOADING).Return(int64(2), nil).Once()
+	mockdb.On("ListLogChunksInArtifact", int64(3), int64(0), int64(10)).Return(makeChunks(0, "01234", "56789"), nil).Once()
+	mockdb.On("UpdateArtifact", &model.Artifact{
+		Id:       3,
+		State:    model.UPLOADED,
+		Sha256:   testContentSha256,
+		Size:     10,
+		S3URL:    "/TestMergeNext__bucketName/TestMergeNext__artifactName",
+		Name:     "TestMergeNext__artifactName",
+		BucketId: "TestMergeNext__bucketName",
+		Version:  2,
+	}).Return(nil).Once()
+	mockdb.On("DeleteLogChunksForArtifact", int64(3)).Return(int64(2), nil).Once()
+
+	merged, err := pool.MergeNext()
+	require.True(t, merged)
+	require.NoError(t, err)
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestMergeWorkerPool(t *testing.T) {
+	const interval = 10 * time.Second
+
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+	mockClock.On("AfterFunc", interval, mock.Anything).Return()
+
+	pool := NewMergeWorkerPool(context.Background(), mockdb, nil, mockClock, 1, interval)
+	pool.Start()
+
+	// Nothing happens before the poll interval elapses.
+	mockClock.Advance(interval / 2)
+	mockdb.AssertExpectations(t)
+
+	// Worker keeps merging artifacts until there is nothing left to merge.
+	mockdb.On("ListArtifactsInState", model.APPEND_COMPLETE, mergeClaimBatchSize).Return([]model.Artifact{
+		{Id: 1, State: model.APPEND_COMPLETE},
+		{Id: 2, State: model.APPEND_COMPLETE},
+	}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(1), model.APPEND_COMPLETE, model.CLOSED_WITHOUT_DATA).Return(int64(2), nil).Once()
+	mockdb.On("ListArtifactsInState", model.APPEND_COMPLETE, mergeClaimBatchSize).Return([]model.Artifact{
+		{Id: 2, State: model.APPEND_COMPLETE},
+	}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(2), model.APPEND_COMPLETE, model.CLOSED_WITHOUT_DATA).Return(int64(2), nil).Once()
+	mockdb.On("ListArtifactsInState", model.APPEND_COMPLETE, mergeClaimBatchSize).Return([]model.Artifact{}, nil).Once()
+	mockClock.Advance(interval)
+	mockdb.AssertExpectations(t)
+
+	// No more merges after the pool is stopped.
+	pool.Stop()
+	mockClock.Advance(2 * interval)
+	mockdb.AssertExpectations(t)
+}
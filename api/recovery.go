@@ -0,0 +1,204 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/common/sentry"
+	"github.com/dropbox/changes-artifacts/common/stats"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
+)
+
+// DefaultStaleArtifactTimeout is the default time an artifact can remain in UPLOADING or
+// APPEND_COMPLETE state without any updates, before it is considered stuck.
+const DefaultStaleArtifactTimeout = 1 * time.Hour
+
+// DefaultStaleArtifactCheckInterval is the default interval between two scans for stuck artifacts.
+const DefaultStaleArtifactCheckInterval = 5 * time.Minute
+
+// UploadHeartbeatInterval is the interval at which artifacts being uploaded are marked as updated,
+// so that long uploads are not mistaken for interrupted ones (see StaleArtifactRecoverer). It must
+// be well below the stale artifact timeout. Set at startup.
+var UploadHeartbeatInterval = DefaultStaleArtifactTimeout / 4
+
+var artifactsRecoveredCounter = stats.NewStat("artifacts_recovered")
+var artifactsRecoveryFailedCounter = stats.NewStat("artifacts_recovery_failed")
+
+// StaleArtifactRecoverer periodically looks for artifacts which are stuck in a transient state
+// (UPLOADING or APPEND_COMPLETE), typically because the server processing them died midway, and
+// moves them forward:
+//
+// - Chunked artifacts stuck in UPLOADING still have all their logchunks, so they are moved back to
+// APPEND_COMPLETE to be merged again.
+//
+// - Streamed artifacts stuck in UPLOADING have lost their content, so they are marked ERROR.
+//
+// - Artifacts stuck in APPEND_COMPLETE (which no merge worker has picked up) are merged right away.
+type StaleArtifactRecoverer struct {
+	ctx          context.Context
+	db           database.Database
+	store        storage.BlobStore
+	clk          common.Clock
+	staleTimeout time.Duration
+	task         *common.PeriodicTask
+}
+
+// NewStaleArtifactRecoverer creates a StaleArtifactRecoverer which, once started, checks every
+// interval for artifacts which have not been updated for staleTimeout.
+func NewStaleArtifactRecoverer(ctx context.Context, db database.Database, store storage.BlobStore, clk common.Clock, staleTimeout time.Duration, interval time.Duration) *StaleArtifactRecoverer {
+	r := &StaleArtifactRecoverer{ctx: ctx, db: db, store: store, clk: clk, staleTimeout: staleTimeout}
+	r.task = common.NewPeriodicTask(clk, interval, r.run)
+	return r
+}
+
+// Start begins periodic recovery checks in the background.
+func (r *StaleArtifactRecoverer) Start() {
+	r.task.Start()
+}
+
+// Stop terminates periodic recovery checks.
+func (r *StaleArtifactRecoverer) Stop() {
+	r.task.Stop()
+}
+
+func (r *StaleArtifactRecoverer) run() {
+	if err := r.RecoverStaleArtifacts(); err != nil {
+		sentry.ReportError(r.ctx, err)
+	}
+}
+
+// RecoverStaleArtifacts performs a single recovery pass over all stuck artifacts. Errors while
+// recovering individual artifacts are reported and do not prevent processing remaining artifacts.
+func (r *StaleArtifactRecoverer) RecoverStaleArtifacts() error {
+	staleBefore := r.clk.Now().Add(-r.staleTimeout)
+
+	// UPLOADING artifacts are handled first. Chunked artifacts among them go back to APPEND_COMPLETE
+	// with a fresh update time, so they are left to the merge workers instead of being merged below.
+	artifacts, err := r.db.ListStaleArtifacts(model.UPLOADING, staleBefore)
+	if err != nil {
+		return err
+	}
+
+	for i := range artifacts {
+		r.reportRecovery(&artifacts[i], recoverUploadingArtifact(r.db, &artifacts[i]))
+	}
+
+	artifacts, err = r.db.ListStaleArtifacts(model.APPEND_COMPLETE, staleBefore)
+	if err != nil {
+		return err
+	}
+
+	for i := range artifacts {
+		r.reportRecovery(&artifacts[i], r.recoverAppendCompleteArtifact(&artifacts[i]))
+	}
+
+	return nil
+}
+
+func (r *StaleArtifactRecoverer) reportRecovery(artifact *model.Artifact, err error) {
+	if err != nil {
+		artifactsRecoveryFailedCounter.Add(1)
+		sentry.ReportError(r.ctx, fmt.Errorf("Error recovering artifact %s/%s: %s", artifact.BucketId, artifact.Name, err))
+		return
+	}
+
+	artifactsRecoveredCounter.Add(1)
+}
+
+func (r *StaleArtifactRecoverer) recoverAppendCompleteArtifact(artifact *model.Artifact) error {
+	if claimed, err := claimArtifactForMerge(r.db, artifact); err != nil {
+		return err
+	} else if !claimed {
+		// Picked up by a merge worker in the meantime.
+		return nil
+	}
+
+	return mergeClaimedArtifact(r.ctx, artifact, r.db, r.store)
+}
+
+// recoverUploadingArtifact moves an artifact stuck in UPLOADING state out of it. If the artifact
+// was being merged from logchunks, it is moved back to APPEND_COMPLETE so that the merge is
+// retried. Otherwise, the artifact was streamed and its content is lost, so it is marked ERROR.
+func recoverUploadingArtifact(db database.Database, artifact *model.Artifact) error {
+	logChunks, err := db.ListLogChunksInArtifact(artifact.Id, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	newState := model.ERROR
+	if len(logChunks) > 0 {
+		newState = model.APPEND_COMPLETE
+	}
+	if err := model.ValidateArtifactTransition(model.UPLOADING, newState); err != nil {
+		return err
+	}
+
+	// The artifact is marked ERROR along with the reason, so that it can't be left in ERROR without
+	// a reason.
+	return db.WithTx(func(tx database.Database) error {
+		version, err := tx.CompareAndSwapArtifactState(artifact.Id, model.UPLOADING, newState)
+		if err != nil {
+			if err.Conflict() || err.EntityNotFound() {
+				// Upload made progress (or the artifact was deleted) in the meantime.
+				return nil
+			}
+			return err
+		}
+
+		artifact.State = newState
+		artifact.Version = version
+		if newState == model.ERROR {
+			artifact.ErrorReason = "Upload was interrupted and content is no longer available"
+			if err := tx.UpdateArtifact(artifact); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// startUploadHeartbeat marks an artifact being uploaded (in UPLOADING state) as updated every
+// UploadHeartbeatInterval, until the returned function is called. That function may be called
+// more than once, and brings artifact.Version up to date, so it must be called before the artifact
+// is updated again.
+func startUploadHeartbeat(db database.Database, artifact *model.Artifact) (stop func()) {
+	done := make(chan bool)
+	versions := make(chan int64, 1)
+	go func(id int64, version int64) {
+		ticker := time.NewTicker(UploadHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				versions <- version
+				return
+			case <-ticker.C:
+				// Leaving the state as is only bumps the update time (and version) of the artifact.
+				newVersion, err := db.CompareAndSwapArtifactState(id, model.UPLOADING, model.UPLOADING)
+				if err != nil {
+					// If the artifact has moved on (or is gone), updating it after the upload fails too.
+					log.Printf("Error marking artifact %d as being uploaded: %s", id, err)
+					continue
+				}
+				version = newVersion
+			}
+		}
+	}(artifact.Id, artifact.Version)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			artifact.Version = <-versions
+		})
+	}
+}
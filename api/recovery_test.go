@@ -0,0 +1,146 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverUploadingArtifact(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockTxs(mockdb)
+
+	// DB error
+	mockdb.On("ListLogChunksInArtifact", int64(1), int64(0), int64(1)).Return(nil, database.MockDatabaseError()).Once()
+	require.Error(t, recoverUploadingArtifact(mockdb, &model.Artifact{Id: 1, State: model.UPLOADING, Size: 10}))
+
+	// Chunked artifact goes back to APPEND_COMPLETE to be merged again.
+	mockdb.On("ListLogChunksInArtifact", int64(2), int64(0), int64(1)).Return(makeChunks(0, "01234"), nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(2), model.UPLOADING, model.APPEND_COMPLETE).Return(int64(2), nil).Once()
+	artifact := &model.Artifact{Id: 2, State: model.UPLOADING, Size: 10}
+	require.NoError(t, recoverUploadingArtifact(mockdb, artifact))
+	require.Equal(t, model.APPEND_COMPLETE, artifact.State)
+	require.Equal(t, int64(2), artifact.Version)
+
+	// Streamed artifact is marked ERROR.
+	mockdb.On("ListLogChunksInArtifact", int64(3), int64(0), int64(1)).Return([]model.LogChunk{}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(3), model.UPLOADING, model.ERROR).Return(int64(2), nil).Once()
+	mockdb.On("UpdateArtifact", &model.Artifact{
+		Id:          3,
+		State:       model.ERROR,
+		Size:        10,
+		ErrorReason: "Upload was interrupted and content is no longer available",
+		Version:     2,
+	}).Return(nil).Once()
+	require.NoError(t, recoverUploadingArtifact(mockdb, &model.Artifact{Id: 3, State: model.UPLOADING, Size: 10}))
+
+	// Error while recording the reason. The transaction is rolled back, so the artifact isn't left in
+	// ERROR without a reason.
+	mockdb.On("ListLogChunksInArtifact", int64(7), int64(0), int64(1)).Return([]model.LogChunk{}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(7), model.UPLOADING, model.ERROR).Return(int64(2), nil).Once()
+	mockdb.On("UpdateArtifact", &model.Artifact{
+		Id:          7,
+		State:       model.ERROR,
+		Size:        10,
+		ErrorReason: "Upload was interrupted and content is no longer available",
+		Version:     2,
+	}).Return(database.MockDatabaseError()).Once()
+	require.Error(t, recoverUploadingArtifact(mockdb, &model.Artifact{Id: 7, State: model.UPLOADING, Size: 10}))
+
+	// Upload completed while we were looking at it.
+	mockdb.On("ListLogChunksInArtifact", int64(4), int64(0), int64(1)).Return([]model.LogChunk{}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(4), model.UPLOADING, model.ERROR).Return(int64(0), database.NewConflictError("Conflict")).Once()
+	require.NoError(t, recoverUploadingArtifact(mockdb, &model.Artifact{Id: 4, State: model.UPLOADING, Size: 10}))
+
+	// Artifact deleted while we were looking at it.
+	mockdb.On("ListLogChunksInArtifact", int64(5), int64(0), int64(1)).Return([]model.LogChunk{}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(5), model.UPLOADING, model.ERROR).Return(int64(0), database.NewEntityNotFoundError("ENF")).Once()
+	require.NoError(t, recoverUploadingArtifact(mockdb, &model.Artifact{Id: 5, State: model.UPLOADING, Size: 10}))
+
+	// Other DB errors are returned.
+	mockdb.On("ListLogChunksInArtifact", int64(6), int64(0), int64(1)).Return([]model.LogChunk{}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(6), model.UPLOADING, model.ERROR).Return(int64(0), database.MockDatabaseError()).Once()
+	require.Error(t, recoverUploadingArtifact(mockdb, &model.Artifact{Id: 6, State: model.UPLOADING, Size: 10}))
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestRecoverStaleArtifacts(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+	staleBefore := mockClock.Now().Add(-time.Hour)
+
+	mockTxs(mockdb)
+	r := NewStaleArtifactRecoverer(context.Background(), mockdb, nil, mockClock, time.Hour, time.Minute)
+
+	// DB errors
+	mockdb.On("ListStaleArtifacts", model.UPLOADING, staleBefore).Return(nil, database.MockDatabaseError()).Once()
+	require.Error(t, r.RecoverStaleArtifacts())
+
+	mockdb.On("ListStaleArtifacts", model.UPLOADING, staleBefore).Return([]model.Artifact{}, nil).Once()
+	mockdb.On("ListStaleArtifacts", model.APPEND_COMPLETE, staleBefore).Return(nil, database.MockDatabaseError()).Once()
+	require.Error(t, r.RecoverStaleArtifacts())
+
+	// Failure to recover one artifact doesn't prevent recovering others.
+	mockdb.On("ListStaleArtifacts", model.UPLOADING, staleBefore).Return([]model.Artifact{
+		{Id: 1, State: model.UPLOADING, Size: 10},
+		{Id: 2, State: model.UPLOADING, Size: 10},
+	}, nil).Once()
+	mockdb.On("ListLogChunksInArtifact", int64(1), int64(0), int64(1)).Return(nil, database.MockDatabaseError()).Once()
+	mockdb.On("ListLogChunksInArtifact", int64(2), int64(0), int64(1)).Return(makeChunks(0, "01234"), nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(2), model.UPLOADING, model.APPEND_COMPLETE).Return(int64(2), nil).Once()
+
+	// Stale APPEND_COMPLETE artifacts are merged right away.
+	mockdb.On("ListStaleArtifacts", model.APPEND_COMPLETE, staleBefore).Return([]model.Artifact{
+		{Id: 3, State: model.APPEND_COMPLETE},
+		{Id: 4, State: model.APPEND_COMPLETE, Size: 10},
+	}, nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(3), model.APPEND_COMPLETE, model.CLOSED_WITHOUT_DATA).Return(int64(2), nil).Once()
+	mockdb.On("CompareAndSwapArtifactState", int64(4), model.APPEND_COMPLETE, model.UPLOADING).Return(int64(0), database.NewConflictError("Conflict")).Once()
+	require.NoError(t, r.RecoverStaleArtifacts())
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestUploadHeartbeat(t *testing.T) {
+	defer func(interval time.Duration) { UploadHeartbeatInterval = interval }(UploadHeartbeatInterval)
+	UploadHeartbeatInterval = time.Millisecond
+
+	mockdb := &database.MockDatabase{}
+
+	// Artifact is marked as updated until the heartbeat is stopped, and ends up with the version of
+	// the last heartbeat.
+	artifact := &model.Artifact{Id: 1, State: model.UPLOADING, Version: 2}
+	heartbeats := make(chan bool, 1)
+	mockdb.On("CompareAndSwapArtifactState", int64(1), model.UPLOADING, model.UPLOADING).Return(int64(3), nil).Run(func(mock.Arguments) {
+		select {
+		case heartbeats <- true:
+		default:
+		}
+	})
+	stop := startUploadHeartbeat(mockdb, artifact)
+	<-heartbeats
+	stop()
+	stop()
+	require.Equal(t, int64(3), artifact.Version)
+
+	// Failed heartbeats leave the version as is.
+	artifact = &model.Artifact{Id: 2, State: model.UPLOADING, Version: 2}
+	mockdb.On("CompareAndSwapArtifactState", int64(2), model.UPLOADING, model.UPLOADING).Return(int64(0), database.NewConflictError("Conflict")).Run(func(mock.Arguments) {
+		select {
+		case heartbeats <- true:
+		default:
+		}
+	})
+	stop = startUploadHeartbeat(mockdb, artifact)
+	<-heartbeats
+	stop()
+	require.Equal(t, int64(2), artifact.Version)
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/common/sentry"
+	"github.com/dropbox/changes-artifacts/common/stats"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/dropbox/changes-artifacts/storage"
+)
+
+// DefaultRetentionCheckInterval is the default interval between two scans for expired buckets.
+const DefaultRetentionCheckInterval = 1 * time.Hour
+
+// Number of expired buckets fetched from the DB (and deleted) at a time.
+const retentionBatchSize = 100
+
+var retentionBucketsDeletedCounter = stats.NewStat("retention_buckets_deleted")
+var retentionBucketsDeleteFailedCounter = stats.NewStat("retention_buckets_delete_failed")
+var retentionBucketsDryRunCounter = stats.NewStat("retention_buckets_dry_run")
+
+// RetentionPolicy determines how long buckets (and their artifacts) are kept after they are
+// created, based on the bucket owner. Buckets are only deleted once they are no longer open.
+type RetentionPolicy struct {
+	// Retention period for buckets of specific owners.
+	ByOwner map[string]time.Duration
+
+	// Retention period for buckets of owners not listed in ByOwner. Zero keeps them forever.
+	Default time.Duration
+}
+
+// IsEmpty returns true if the policy never expires any bucket.
+func (p RetentionPolicy) IsEmpty() bool {
+	return len(p.ByOwner) == 0 && p.Default == 0
+}
+
+// RetentionJanitor periodically deletes buckets which have expired under a RetentionPolicy, along
+// with their artifacts, logchunks and blob store objects (see DeleteBucket).
+//
+// In dry run mode, expired buckets are only logged and counted, not deleted.
+type RetentionJanitor struct {
+	ctx    context.Context
+	db     database.Database
+	store  storage.BlobStore
+	clk    common.Clock
+	policy RetentionPolicy
+	dryRun bool
+	task   *common.PeriodicTask
+}
+
+// NewRetentionJanitor creates a RetentionJanitor which enforces policy every interval once started.
+func NewRetentionJanitor(ctx context.Context, db database.Database, store storage.BlobStore, clk common.Clock, policy RetentionPolicy, dryRun bool, interval time.Duration) *RetentionJanitor {
+	j := &RetentionJanitor{ctx: ctx, db: db, store: store, clk: clk, policy: policy, dryRun: dryRun}
+	j.task = common.NewPeriodicTask(clk, interval, j.run)
+	return j
+}
+
+// Start begins periodic retention checks in the background.
+func (j *RetentionJanitor) Start() {
+	j.task.Start()
+}
+
+// Stop terminates periodic retention checks.
+func (j *RetentionJanitor) Stop() {
+	j.task.Stop()
+}
+
+func (j *RetentionJanitor) run() {
+	if err := j.EnforceRetention(); err != nil {
+		sentry.ReportError(j.ctx, err)
+	}
+}
+
+// EnforceRetention deletes all buckets which have expired under the retention policy, in batches.
+// Errors while deleting individual buckets are reported and do not prevent processing remaining
+// buckets.
+//
+// Buckets which can't be deleted (for example, because they still have artifacts being uploaded)
+// are skipped, and retried on the next run.
+func (j *RetentionJanitor) EnforceRetention() error {
+	now := j.clk.Now()
+
+	// Sorted, so that owners are always processed in the same order.
+	owners := make([]string, 0, len(j.policy.ByOwner))
+	for owner := range j.policy.ByOwner {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	for _, owner := range owners {
+		createdBefore := now.Add(-j.policy.ByOwner[owner])
+		if err := j.deleteInBatches(func(after *database.BucketCursor) ([]model.Bucket, *database.DatabaseError) {
+			return j.db.ListExpiredBuckets(owner, createdBefore, after, retentionBatchSize)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if j.policy.Default == 0 {
+		return nil
+	}
+
+	createdBefore := now.Add(-j.policy.Default)
+	return j.deleteInBatches(func(after *database.BucketCursor) ([]model.Bucket, *database.DatabaseError) {
+		return j.db.ListExpiredBucketsOfOtherOwners(owners, createdBefore, after, retentionBatchSize)
+	})
+}
+
+// deleteInBatches repeatedly fetches the batch of expired buckets following the previous one and
+// deletes them, until there are none left. Each batch starts after the last bucket of the previous
+// batch, so that buckets which could not be deleted are not fetched again.
+func (j *RetentionJanitor) deleteInBatches(listBatch func(after *database.BucketCursor) ([]model.Bucket, *database.DatabaseError)) error {
+	var after *database.BucketCursor
+	for {
+		buckets, err := listBatch(after)
+		if err != nil {
+			return err
+		}
+
+		for i := range buckets {
+			bucket := &buckets[i]
+			if j.dryRun {
+				log.Printf("[Dry run] Would delete expired bucket %s (owner %s, created %s)", bucket.Id, bucket.Owner, bucket.DateCreated)
+				retentionBucketsDryRunCounter.Add(1)
+				continue
+			}
+
+			if err := DeleteBucket(j.ctx, bucket, j.db, j.store); err != nil {
+				sentry.ReportError(j.ctx, fmt.Errorf("Error deleting expired bucket %s: %s", bucket.Id, err))
+				retentionBucketsDeleteFailedCounter.Add(1)
+				continue
+			}
+			retentionBucketsDeletedCounter.Add(1)
+		}
+
+		if len(buckets) < retentionBatchSize {
+			return nil
+		}
+		last := buckets[len(buckets)-1]
+		after = &database.BucketCursor{DateCreated: last.DateCreated, Id: last.Id}
+	}
+}
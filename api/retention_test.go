@@ -0,0 +1,145 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/database"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const day = 24 * time.Hour
+
+var testRetentionPolicy = RetentionPolicy{
+	ByOwner: map[string]time.Duration{"changes": 90 * day, "scratch": 7 * day},
+	Default: 30 * day,
+}
+
+func TestRetentionPolicyIsEmpty(t *testing.T) {
+	require.True(t, RetentionPolicy{}.IsEmpty())
+	require.False(t, RetentionPolicy{Default: day}.IsEmpty())
+	require.False(t, RetentionPolicy{ByOwner: map[string]time.Duration{"changes": day}}.IsEmpty())
+}
+
+func TestEnforceRetention(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+	store, cleanup := testLocalBlobStore(t)
+	defer cleanup()
+
+	now := mockClock.Now()
+	j := NewRetentionJanitor(context.Background(), mockdb, store, mockClock, testRetentionPolicy, false, time.Minute)
+
+	mockdb.On("ListExpiredBuckets", "changes", now.Add(-90*day), (*database.BucketCursor)(nil), retentionBatchSize).Return([]model.Bucket{
+		{Id: "c1", Owner: "changes", State: model.CLOSED},
+	}, nil).Once()
+	mockdb.On("ListArtifactsInBucket", "c1").Return([]model.Artifact{{Id: 1, State: model.CLOSED_WITHOUT_DATA}}, nil).Once()
+	mockdb.On("DeleteLogChunksForArtifact", int64(1)).Return(int64(0), nil).Once()
+	mockdb.On("DeleteArtifact", int64(1)).Return(nil).Once()
+	mockdb.On("DeleteBucket", "c1").Return(nil).Once()
+
+	// Failure to delete one bucket doesn't prevent deleting others.
+	mockdb.On("ListExpiredBuckets", "scratch", now.Add(-7*day), (*database.BucketCursor)(nil), retentionBatchSize).Return([]model.Bucket{
+		{Id: "s1", Owner: "scratch", State: model.CLOSED},
+		{Id: "s2", Owner: "scratch", State: model.TIMEDOUT},
+	}, nil).Once()
+	mockdb.On("ListArtifactsInBucket", "s1").Return(nil, database.MockDatabaseError()).Once()
+	mockdb.On("ListArtifactsInBucket", "s2").Return([]model.Artifact{}, nil).Once()
+	mockdb.On("DeleteBucket", "s2").Return(nil).Once()
+
+	mockdb.On("ListExpiredBucketsOfOtherOwners", []string{"changes", "scratch"}, now.Add(-30*day), (*database.BucketCursor)(nil), retentionBatchSize).Return([]model.Bucket{}, nil).Once()
+
+	require.NoError(t, j.EnforceRetention())
+	mockdb.AssertExpectations(t)
+
+	// DB error while listing buckets
+	mockdb.On("ListExpiredBuckets", "changes", now.Add(-90*day), (*database.BucketCursor)(nil), retentionBatchSize).Return(nil, database.MockDatabaseError()).Once()
+	require.Error(t, j.EnforceRetention())
+	mockdb.AssertExpectations(t)
+}
+
+func TestEnforceRetentionInBatches(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+
+	now := mockClock.Now()
+	j := NewRetentionJanitor(context.Background(), mockdb, nil, mockClock, RetentionPolicy{Default: day}, false, time.Minute)
+
+	batch := func(prefix string, n int) []model.Bucket {
+		buckets := make([]model.Bucket, n)
+		for i := range buckets {
+			buckets[i] = model.Bucket{Id: fmt.Sprintf("%s%d", prefix, i), State: model.CLOSED}
+		}
+		return buckets
+	}
+
+	// Batches are fetched, each starting after the previous one, until a partial batch is returned.
+	mockdb.On("ListExpiredBucketsOfOtherOwners", []string{}, now.Add(-day), (*database.BucketCursor)(nil), retentionBatchSize).Return(batch("a", retentionBatchSize), nil).Once()
+	mockdb.On("ListExpiredBucketsOfOtherOwners", []string{}, now.Add(-day), &database.BucketCursor{Id: fmt.Sprintf("a%d", retentionBatchSize-1)}, retentionBatchSize).Return(batch("b", 1), nil).Once()
+	mockdb.On("ListArtifactsInBucket", mock.AnythingOfType("string")).Return([]model.Artifact{}, nil)
+	mockdb.On("DeleteBucket", mock.AnythingOfType("string")).Return(nil).Times(retentionBatchSize + 1)
+	require.NoError(t, j.EnforceRetention())
+	mockdb.AssertExpectations(t)
+
+	// If no bucket in a full batch can be deleted, the same batch is not retried, but later buckets
+	// are still deleted.
+	mockdb = &database.MockDatabase{}
+	j.db = mockdb
+	mockdb.On("ListExpiredBucketsOfOtherOwners", []string{}, now.Add(-day), (*database.BucketCursor)(nil), retentionBatchSize).Return(batch("a", retentionBatchSize), nil).Once()
+	mockdb.On("ListArtifactsInBucket", mock.MatchedBy(func(id string) bool { return id[0] == 'a' })).Return(nil, database.MockDatabaseError())
+	mockdb.On("ListExpiredBucketsOfOtherOwners", []string{}, now.Add(-day), &database.BucketCursor{Id: fmt.Sprintf("a%d", retentionBatchSize-1)}, retentionBatchSize).Return(batch("b", 1), nil).Once()
+	mockdb.On("ListArtifactsInBucket", "b0").Return([]model.Artifact{}, nil).Once()
+	mockdb.On("DeleteBucket", "b0").Return(nil).Once()
+	require.NoError(t, j.EnforceRetention())
+	mockdb.AssertExpectations(t)
+}
+
+func TestEnforceRetentionDryRun(t *testing.T) {
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+
+	now := mockClock.Now()
+	j := NewRetentionJanitor(context.Background(), mockdb, nil, mockClock, testRetentionPolicy, true, time.Minute)
+
+	// Nothing is deleted.
+	mockdb.On("ListExpiredBuckets", "changes", now.Add(-90*day), (*database.BucketCursor)(nil), retentionBatchSize).Return([]model.Bucket{
+		{Id: "c1", Owner: "changes", State: model.CLOSED},
+	}, nil).Once()
+	mockdb.On("ListExpiredBuckets", "scratch", now.Add(-7*day), (*database.BucketCursor)(nil), retentionBatchSize).Return([]model.Bucket{}, nil).Once()
+	mockdb.On("ListExpiredBucketsOfOtherOwners", []string{"changes", "scratch"}, now.Add(-30*day), (*database.BucketCursor)(nil), retentionBatchSize).Return([]model.Bucket{
+		{Id: "o1", Owner: "other", State: model.CLOSED},
+	}, nil).Once()
+
+	require.NoError(t, j.EnforceRetention())
+	mockdb.AssertExpectations(t)
+}
+
+func TestRetentionJanitor(t *testing.T) {
+	const interval = 10 * time.Second
+
+	mockdb := &database.MockDatabase{}
+	mockClock := common.NewFrozenClock()
+	mockClock.On("AfterFunc", interval, mock.Anything).Return()
+
+	j := NewRetentionJanitor(context.Background(), mockdb, nil, mockClock, RetentionPolicy{Default: day}, false, interval)
+	j.Start()
+
+	// Nothing happens before the check interval elapses.
+	mockClock.Advance(interval / 2)
+	mockdb.AssertExpectations(t)
+
+	mockdb.On("ListExpiredBucketsOfOtherOwners", []string{}, mock.AnythingOfType("time.Time"), (*database.BucketCursor)(nil), retentionBatchSize).Return([]model.Bucket{}, nil).Once()
+	mockClock.Advance(interval)
+	mockdb.AssertExpectations(t)
+
+	// No more checks after the janitor is stopped.
+	j.Stop()
+	mockClock.Advance(2 * interval)
+	mockdb.AssertExpectations(t)
+}
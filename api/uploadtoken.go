@@ -0,0 +1,160 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/martini-contrib/render"
+)
+
+// DefaultUploadTokenTTL is the validity period of upload tokens created without an explicit one.
+const DefaultUploadTokenTTL = 24 * time.Hour
+
+// MaxUploadTokenTTL is the longest validity period an upload token can be created with.
+const MaxUploadTokenTTL = 7 * 24 * time.Hour
+
+// Distinguishes upload tokens from API tokens in the Authorization header.
+const uploadTokenPrefix = "upload."
+
+// UploadTokenSigner creates and verifies upload tokens. An upload token is limited to a single
+// bucket and expires after a while. It allows reading the bucket, and creating, appending to and
+// closing artifacts in it, so that it can be handed to untrusted build machines.
+//
+// Upload tokens are not stored anywhere, they are signed (HMAC-SHA256) with a key shared by all
+// servers instead.
+type UploadTokenSigner struct {
+	key []byte
+	clk common.Clock
+}
+
+// NewUploadTokenSigner creates an UploadTokenSigner which signs tokens with key.
+func NewUploadTokenSigner(key []byte, clk common.Clock) *UploadTokenSigner {
+	return &UploadTokenSigner{key: key, clk: clk}
+}
+
+// Mint creates an upload token for bucketID, valid for ttl. Returns the token and its expiry time.
+func (s *UploadTokenSigner) Mint(bucketID string, ttl time.Duration) (string, time.Time) {
+	expires := s.clk.Now().Add(ttl)
+	payload := fmt.Sprintf("%s%s.%d", uploadTokenPrefix, base64.RawURLEncoding.EncodeToString([]byte(bucketID)), expires.Unix())
+	return payload + "." + s.sign(payload), expires
+}
+
+// Verify checks that token is a valid, unexpired upload token for bucketID.
+func (s *UploadTokenSigner) Verify(token string, bucketID string) error {
+	sep := strings.LastIndex(token, ".")
+	if !isUploadToken(token) || sep < 0 {
+		return errors.New("Malformed upload token")
+	}
+
+	payload := token[:sep]
+	if !hmac.Equal([]byte(token[sep+1:]), []byte(s.sign(payload))) {
+		return errors.New("Invalid upload token signature")
+	}
+
+	fields := strings.Split(strings.TrimPrefix(payload, uploadTokenPrefix), ".")
+	if len(fields) != 2 {
+		return errors.New("Malformed upload token")
+	}
+
+	tokenBucketID, err := base64.RawURLEncoding.DecodeString(fields[0])
+	if err != nil {
+		return errors.New("Malformed upload token")
+	}
+	if string(tokenBucketID) != bucketID {
+		return fmt.Errorf("Upload token is not valid for bucket %s", bucketID)
+	}
+
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return errors.New("Malformed upload token")
+	}
+	if !s.clk.Now().Before(time.Unix(expires, 0)) {
+		return errors.New("Upload token has expired")
+	}
+
+	return nil
+}
+
+func (s *UploadTokenSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func isUploadToken(token string) bool {
+	return strings.HasPrefix(token, uploadTokenPrefix)
+}
+
+// CreateUploadToken creates an upload token for an open bucket, valid for ttl (or
+// DefaultUploadTokenTTL if zero).
+func CreateUploadToken(signer *UploadTokenSigner, bucket *model.Bucket, ttl time.Duration) (string, time.Time, *HttpError) {
+	if signer == nil {
+		return "", time.Time{}, NewHttpError(http.StatusNotImplemented, "Upload tokens are not enabled on this server")
+	}
+
+	if bucket.State != model.OPEN {
+		return "", time.Time{}, NewHttpError(http.StatusBadRequest, "Bucket %s is already closed", bucket.Id)
+	}
+
+	if ttl == 0 {
+		ttl = DefaultUploadTokenTTL
+	}
+	if ttl > MaxUploadTokenTTL {
+		return "", time.Time{}, NewHttpError(http.StatusBadRequest, "Upload token TTL must be at most %s", MaxUploadTokenTTL)
+	}
+
+	token, expires := signer.Mint(bucket.Id, ttl)
+	return token, expires, nil
+}
+
+// HandleCreateUploadToken handles the HTTP request to create an upload token. See
+// CreateUploadToken for details.
+func HandleCreateUploadToken(ctx context.Context, r render.Render, req *http.Request, signer *UploadTokenSigner, bucket *model.Bucket) {
+	if bucket == nil {
+		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "No bucket specified")
+		return
+	}
+
+	var createReq struct {
+		TTLSecs uint
+	}
+	if err := json.NewDecoder(req.Body).Decode(&createReq); err != nil {
+		LogAndRespondWithErrorf(ctx, r, http.StatusBadRequest, "Malformed JSON request")
+		return
+	}
+
+	ttl, err := uploadTokenTTLFromSecs(createReq.TTLSecs)
+	if err != nil {
+		LogAndRespondWithError(ctx, r, err.errCode, err)
+		return
+	}
+
+	token, expires, err := CreateUploadToken(signer, bucket, ttl)
+	if err != nil {
+		LogAndRespondWithError(ctx, r, err.errCode, err)
+		return
+	}
+
+	r.JSON(http.StatusOK, map[string]interface{}{"token": token, "expires": expires})
+}
+
+// uploadTokenTTLFromSecs converts the TTL of an upload token request to a duration. TTLs longer
+// than MaxUploadTokenTTL are rejected here, since they may not fit in a duration.
+func uploadTokenTTLFromSecs(secs uint) (time.Duration, *HttpError) {
+	if secs > uint(MaxUploadTokenTTL/time.Second) {
+		return 0, NewHttpError(http.StatusBadRequest, "Upload token TTL must be at most %s", MaxUploadTokenTTL)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dropbox/changes-artifacts/common"
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadTokenSigner(t *testing.T) {
+	mockClock := common.NewFrozenClock()
+	signer := NewUploadTokenSigner([]byte("key"), mockClock)
+
+	token, expires := signer.Mint("bkt.1", time.Hour)
+	require.True(t, isUploadToken(token))
+	require.Equal(t, mockClock.Now().Add(time.Hour), expires)
+	require.NoError(t, signer.Verify(token, "bkt.1"))
+
+	// Only valid for the bucket it was created for.
+	require.Error(t, signer.Verify(token, "bkt"))
+	require.Error(t, signer.Verify(token, "bkt.2"))
+
+	// Only valid with the key it was signed with.
+	require.Error(t, NewUploadTokenSigner([]byte("otherkey"), mockClock).Verify(token, "bkt.1"))
+
+	// Tampering with the token invalidates its signature.
+	otherToken, _ := signer.Mint("bkt.2", 2*time.Hour)
+	sep := strings.LastIndex(token, ".")
+	otherSep := strings.LastIndex(otherToken, ".")
+	require.Error(t, signer.Verify(otherToken[:otherSep]+token[sep:], "bkt.2"))
+	require.Error(t, signer.Verify(token[:sep]+".", "bkt.1"))
+
+	for _, malformed := range []string{"", "upload.", "upload.abc", "bogus", "0123456789abcdef"} {
+		require.Error(t, signer.Verify(malformed, "bkt.1"), "Token %q should be rejected", malformed)
+	}
+
+	// Expired tokens are rejected.
+	mockClock.Advance(time.Hour - time.Second)
+	require.NoError(t, signer.Verify(token, "bkt.1"))
+	mockClock.Advance(time.Second)
+	require.Error(t, signer.Verify(token, "bkt.1"))
+}
+
+func TestCreateUploadToken(t *testing.T) {
+	mockClock := common.NewFrozenClock()
+	signer := NewUploadTokenSigner([]byte("key"), mockClock)
+	bucket := &model.Bucket{Id: "bkt", State: model.OPEN}
+
+	_, _, err := CreateUploadToken(nil, bucket, 0)
+	requireHttpErrorCode(t, http.StatusNotImplemented, err)
+
+	_, _, err = CreateUploadToken(signer, &model.Bucket{Id: "bkt", State: model.CLOSED}, 0)
+	requireHttpErrorCode(t, http.StatusBadRequest, err)
+
+	_, _, err = CreateUploadToken(signer, bucket, MaxUploadTokenTTL+time.Second)
+	requireHttpErrorCode(t, http.StatusBadRequest, err)
+
+	token, expires, err := CreateUploadToken(signer, bucket, 0)
+	require.Nil(t, err)
+	require.Equal(t, mockClock.Now().Add(DefaultUploadTokenTTL), expires)
+	require.NoError(t, signer.Verify(token, "bkt"))
+
+	_, expires, err = CreateUploadToken(signer, bucket, time.Minute)
+	require.Nil(t, err)
+	require.Equal(t, mockClock.Now().Add(time.Minute), expires)
+}
+
+func TestUploadTokenTTLFromSecs(t *testing.T) {
+	ttl, err := uploadTokenTTLFromSecs(60)
+	require.Nil(t, err)
+	require.Equal(t, time.Minute, ttl)
+
+	ttl, err = uploadTokenTTLFromSecs(uint(MaxUploadTokenTTL / time.Second))
+	require.Nil(t, err)
+	require.Equal(t, MaxUploadTokenTTL, ttl)
+
+	_, err = uploadTokenTTLFromSecs(uint(MaxUploadTokenTTL/time.Second) + 1)
+	requireHttpErrorCode(t, http.StatusBadRequest, err)
+
+	// Would overflow a duration.
+	_, err = uploadTokenTTLFromSecs(^uint(0))
+	requireHttpErrorCode(t, http.StatusBadRequest, err)
+}
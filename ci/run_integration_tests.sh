@@ -6,17 +6,15 @@ export PATH=$GOPATH/bin:$PATH
 go get -v github.com/jstemmer/go-junit-report
 go get -v ./...
 
-go run server.go -migrations-only
+go run server.go -migrations-only -config client/local_storage_config.json
 
-PORT=3000 go run server.go -verbose &
-sudo fakes3 -r /var/cache/fakes3 -p 4569 &
+PORT=3000 go run server.go -verbose -config client/local_storage_config.json &
 
 go test -v -race -cover $@ ./... | tee test.output | go-junit-report > junit.xml
 
 statuscode=${PIPESTATUS[0]}
 
 pkill -9 server
-sudo pkill -9 fakes3
 
 cat test.output
 
@@ -2,6 +2,8 @@ package client
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,7 +11,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -65,30 +70,69 @@ type ArtifactStoreClient struct {
 	server  string
 	ctx     context.Context
 	timeout time.Duration
+	// API token sent with every request, if set.
+	apiToken string
 }
 
-func NewArtifactStoreClient(serverURL string) *ArtifactStoreClient {
-	return NewArtifactStoreClientWithContext(serverURL, DefaultReqTimeout, context.TODO())
+// ClientOption configures optional settings of an ArtifactStoreClient.
+type ClientOption func(*ArtifactStoreClient)
+
+// WithAPIToken makes the client authenticate all requests with given API token. Servers which
+// require authentication only allow a token to modify buckets of the owners it was granted.
+//
+// An upload token created with Bucket.NewUploadToken can be used instead, to only access a single
+// bucket.
+func WithAPIToken(token string) ClientOption {
+	return func(c *ArtifactStoreClient) {
+		c.apiToken = token
+	}
+}
+
+func NewArtifactStoreClient(serverURL string, opts ...ClientOption) *ArtifactStoreClient {
+	return NewArtifactStoreClientWithContext(serverURL, DefaultReqTimeout, context.TODO(), opts...)
 }
 
 // NewArtifactStoreClientWithContext creates a new client with given context and per-request
 // timeout.
-func NewArtifactStoreClientWithContext(serverURL string, timeout time.Duration, ctx context.Context) *ArtifactStoreClient {
-	return &ArtifactStoreClient{server: serverURL, timeout: timeout, ctx: ctx}
+func NewArtifactStoreClientWithContext(serverURL string, timeout time.Duration, ctx context.Context, opts ...ClientOption) *ArtifactStoreClient {
+	c := &ArtifactStoreClient{server: serverURL, timeout: timeout, ctx: ctx}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// do sends a request to the server, with credentials if the client has any.
+func (c *ArtifactStoreClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if c.apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiToken)
+	}
+	return ctxhttp.Do(ctx, nil, req)
 }
 
 func (c *ArtifactStoreClient) getAPI(path string) (io.ReadCloser, *ArtifactsError) {
+	body, _, err := c.getAPIWithHeader(path)
+	return body, err
+}
+
+// getAPIWithHeader is the same as getAPI, but also returns the response headers.
+func (c *ArtifactStoreClient) getAPIWithHeader(path string) (io.ReadCloser, http.Header, *ArtifactsError) {
 	url := c.server + path
 	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
 	defer cancel()
 
-	if resp, err := ctxhttp.Get(ctx, nil, url); err != nil {
-		return nil, NewRetriableError(err.Error())
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, NewTerminalError(err.Error())
+	}
+
+	if resp, err := c.do(ctx, req); err != nil {
+		return nil, nil, NewRetriableError(err.Error())
 	} else {
 		if resp.StatusCode != http.StatusOK {
-			return nil, determineResponseError(resp, url, "POST")
+			return nil, nil, determineResponseError(resp, url, "POST")
 		}
-		return resp.Body, nil
+		return resp.Body, resp.Header, nil
 	}
 }
 
@@ -107,7 +151,13 @@ func (c *ArtifactStoreClient) postAPI(path string, contentType string, body io.R
 	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
 	defer cancel()
 
-	if resp, err := ctxhttp.Post(ctx, nil, url, contentType, body); err != nil {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, NewTerminalError(err.Error())
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	if resp, err := c.do(ctx, req); err != nil {
 		// If there was an error connecting to the server, it is likely to be transient and should be
 		// retried.
 		return nil, NewRetriableError(err.Error())
@@ -119,6 +169,26 @@ func (c *ArtifactStoreClient) postAPI(path string, contentType string, body io.R
 	}
 }
 
+func (c *ArtifactStoreClient) deleteAPI(path string) (io.ReadCloser, *ArtifactsError) {
+	url := c.server + path
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return nil, NewTerminalError(err.Error())
+	}
+
+	if resp, err := c.do(ctx, req); err != nil {
+		return nil, NewRetriableError(err.Error())
+	} else {
+		if resp.StatusCode != http.StatusOK {
+			return nil, determineResponseError(resp, url, "DELETE")
+		}
+		return resp.Body, nil
+	}
+}
+
 func (c *ArtifactStoreClient) parseBucketFromResponse(body io.ReadCloser) (*Bucket, *ArtifactsError) {
 	bText, err := ioutil.ReadAll(body)
 	if err != nil {
@@ -194,11 +264,14 @@ func (c *ArtifactStoreClient) GetBucket(bucketName string) (*Bucket, *ArtifactsE
 	return bucket, nil
 }
 
-// XXX deadlineMins is not used. Is this planned for something?
+// NewBucket creates a new bucket. The bucket is closed (and marked TIMEDOUT) by the server if it
+// is not closed within deadlineMins minutes. If deadlineMins is 0, a server default is used, which
+// is no deadline unless the server is configured otherwise.
 func (c *ArtifactStoreClient) NewBucket(bucketName string, owner string, deadlineMins int) (*Bucket, *ArtifactsError) {
 	body, err := c.postAPIJSON("/buckets/", map[string]interface{}{
-		"id":    bucketName,
-		"owner": owner,
+		"id":           bucketName,
+		"owner":        owner,
+		"deadlineMins": deadlineMins,
 	})
 
 	if err != nil {
@@ -217,11 +290,131 @@ func (c *ArtifactStoreClient) NewBucket(bucketName string, owner string, deadlin
 	return bucket, err
 }
 
+// Response header carrying the cursor for the next page of a listing (same as
+// api.NextCursorHeader).
+const nextCursorHeader = "X-Next-Cursor"
+
+// Page size used by IterateArtifacts if not specified (same as api.DefaultPageSize).
+const defaultArtifactPageSize = 25
+
+// BucketListOptions filters the buckets listed by ListBuckets. Fields left at their zero value
+// don't restrict the listing.
+type BucketListOptions struct {
+	Owner string
+	State model.BucketState
+	// Only list buckets created at or after CreatedAfter, and before CreatedBefore.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// List newest buckets first, instead of oldest first.
+	Descending bool
+	// Number of buckets fetched from the server at a time. Server default is used if zero.
+	PageSize int
+}
+
+// BucketIterator iterates over buckets listed by ListBuckets, fetching them from the server one
+// page at a time.
+type BucketIterator struct {
+	client  *ArtifactStoreClient
+	query   url.Values
+	page    []model.Bucket
+	started bool
+	cursor  string
+}
+
+// ListBuckets lists buckets matching opts, oldest first unless opts.Descending is set.
+func (c *ArtifactStoreClient) ListBuckets(opts BucketListOptions) *BucketIterator {
+	query := url.Values{}
+	if opts.Owner != "" {
+		query.Set("owner", opts.Owner)
+	}
+	if opts.State != model.UNKNOWN {
+		query.Set("state", opts.State.String())
+	}
+	if !opts.CreatedAfter.IsZero() {
+		query.Set("createdAfter", opts.CreatedAfter.Format(time.RFC3339))
+	}
+	if !opts.CreatedBefore.IsZero() {
+		query.Set("createdBefore", opts.CreatedBefore.Format(time.RFC3339))
+	}
+	if opts.Descending {
+		query.Set("sort", "-dateCreated")
+	}
+	if opts.PageSize > 0 {
+		query.Set("limit", strconv.Itoa(opts.PageSize))
+	}
+
+	return &BucketIterator{client: c, query: query}
+}
+
+// Next returns the next bucket in the listing, or nil once all buckets have been listed. If an
+// error is returned, Next can be called again to retry.
+func (it *BucketIterator) Next() (*Bucket, *ArtifactsError) {
+	for len(it.page) == 0 {
+		if it.started && it.cursor == "" {
+			return nil, nil
+		}
+		if err := it.fetchPage(); err != nil {
+			return nil, err
+		}
+	}
+
+	bucket := &Bucket{client: it.client, bucket: &it.page[0]}
+	it.page = it.page[1:]
+	return bucket, nil
+}
+
+func (it *BucketIterator) fetchPage() *ArtifactsError {
+	page := []model.Bucket{}
+	cursor, err := it.client.getListingPage("/buckets", it.query, it.cursor, &page)
+	if err != nil {
+		return err
+	}
+
+	it.page = page
+	it.started = true
+	it.cursor = cursor
+	return nil
+}
+
+// getListingPage fetches a page of a paginated listing at path into page, continuing from cursor
+// (if not empty). Returns the cursor for the next page, which is empty if this was the last page.
+func (c *ArtifactStoreClient) getListingPage(path string, query url.Values, cursor string, page interface{}) (string, *ArtifactsError) {
+	pageQuery := url.Values{}
+	for k, v := range query {
+		pageQuery[k] = v
+	}
+	if cursor != "" {
+		pageQuery.Set("cursor", cursor)
+	}
+
+	body, header, err := c.getAPIWithHeader(path + "?" + pageQuery.Encode())
+	if err != nil {
+		return "", err
+	}
+
+	bText, e := ioutil.ReadAll(body)
+	body.Close()
+	if e != nil {
+		return "", NewRetriableError(e.Error())
+	}
+
+	if e := json.Unmarshal(bText, page); e != nil {
+		return "", NewTerminalError(e.Error())
+	}
+
+	return header.Get(nextCursorHeader), nil
+}
+
 type Bucket struct {
 	client *ArtifactStoreClient
 	bucket *model.Bucket
 }
 
+// GetBucketModel returns the raw model.Bucket instance associated with the bucket.
+func (b *Bucket) GetBucketModel() *model.Bucket {
+	return b.bucket
+}
+
 func (b *Bucket) parseArtifactFromResponse(body io.ReadCloser) (Artifact, *ArtifactsError) {
 	bText, err := ioutil.ReadAll(body)
 	if err != nil {
@@ -303,6 +496,8 @@ func (b *Bucket) GetArtifact(name string) (Artifact, *ArtifactsError) {
 	return b.parseArtifactFromResponse(body)
 }
 
+// ListArtifacts lists all artifacts in the bucket with a single request. For buckets with lots of
+// artifacts, prefer IterateArtifacts.
 func (b *Bucket) ListArtifacts() ([]Artifact, *ArtifactsError) {
 	body, err := b.client.getAPI(fmt.Sprintf("/buckets/%s/artifacts/", b.bucket.Id))
 	if err != nil {
@@ -312,6 +507,109 @@ func (b *Bucket) ListArtifacts() ([]Artifact, *ArtifactsError) {
 	return b.parseArtifactListFromResponse(body)
 }
 
+// Sort orders for ArtifactListOptions.SortBy.
+const (
+	SortArtifactsByID          = "id"
+	SortArtifactsByName        = "name"
+	SortArtifactsBySize        = "size"
+	SortArtifactsByDateCreated = "dateCreated"
+)
+
+// ArtifactListOptions filters and orders the artifacts listed by IterateArtifacts. Fields left at
+// their zero value don't restrict the listing.
+type ArtifactListOptions struct {
+	State model.ArtifactState
+	// Only list artifacts whose name (or relative path) starts with given prefix.
+	NamePrefix         string
+	RelativePathPrefix string
+	// Only list artifacts whose name (or relative path) matches given glob pattern, where '*'
+	// matches any sequence of characters and '?' matches any single character.
+	NameGlob         string
+	RelativePathGlob string
+	// One of the SortArtifactsBy* constants, SortArtifactsByID if empty.
+	SortBy     string
+	Descending bool
+	// Number of artifacts fetched from the server at a time. Server default is used if zero.
+	PageSize int
+}
+
+// ArtifactIterator iterates over artifacts listed by IterateArtifacts, fetching them from the
+// server one page at a time.
+type ArtifactIterator struct {
+	bucket  *Bucket
+	query   url.Values
+	page    []model.Artifact
+	started bool
+	cursor  string
+}
+
+// IterateArtifacts lists artifacts in the bucket matching opts.
+func (b *Bucket) IterateArtifacts(opts ArtifactListOptions) *ArtifactIterator {
+	query := url.Values{}
+	if opts.State != model.UNKNOWN_ARTIFACT_STATE {
+		query.Set("state", opts.State.String())
+	}
+	for param, value := range map[string]string{
+		"namePrefix":         opts.NamePrefix,
+		"relativePathPrefix": opts.RelativePathPrefix,
+		"nameGlob":           opts.NameGlob,
+		"relativePathGlob":   opts.RelativePathGlob,
+	} {
+		if value != "" {
+			query.Set(param, value)
+		}
+	}
+	if opts.SortBy != "" || opts.Descending {
+		sortBy := opts.SortBy
+		if sortBy == "" {
+			sortBy = SortArtifactsByID
+		}
+		if opts.Descending {
+			sortBy = "-" + sortBy
+		}
+		query.Set("sort", sortBy)
+	}
+
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		// Unlike other listings, artifact listings are unlimited unless a page size is given.
+		pageSize = defaultArtifactPageSize
+	}
+	query.Set("limit", strconv.Itoa(pageSize))
+
+	return &ArtifactIterator{bucket: b, query: query}
+}
+
+// Next returns the next artifact in the listing, or nil once all artifacts have been listed. If an
+// error is returned, Next can be called again to retry.
+func (it *ArtifactIterator) Next() (Artifact, *ArtifactsError) {
+	for len(it.page) == 0 {
+		if it.started && it.cursor == "" {
+			return nil, nil
+		}
+		if err := it.fetchPage(); err != nil {
+			return nil, err
+		}
+	}
+
+	artifact := &ArtifactImpl{artifact: &it.page[0], bucket: it.bucket}
+	it.page = it.page[1:]
+	return artifact, nil
+}
+
+func (it *ArtifactIterator) fetchPage() *ArtifactsError {
+	page := []model.Artifact{}
+	cursor, err := it.bucket.client.getListingPage(fmt.Sprintf("/buckets/%s/artifacts/", it.bucket.bucket.Id), it.query, it.cursor, &page)
+	if err != nil {
+		return err
+	}
+
+	it.page = page
+	it.started = true
+	it.cursor = cursor
+	return nil
+}
+
 func (b *Bucket) parseArtifactListFromResponse(body io.ReadCloser) ([]Artifact, *ArtifactsError) {
 	bText, err := ioutil.ReadAll(body)
 	if err != nil {
@@ -339,6 +637,39 @@ func (b *Bucket) Close() *ArtifactsError {
 	return ignoreBody(b.client.postAPIJSON(fmt.Sprintf("/buckets/%s/close", b.bucket.Id), map[string]interface{}{}))
 }
 
+// NewUploadToken creates a token which can be handed to untrusted machines (see WithAPIToken). It
+// only allows reading the bucket and creating, appending to and closing artifacts in it, until it
+// expires after ttl (or a server default, if zero). Returns the token and its expiry time.
+func (b *Bucket) NewUploadToken(ttl time.Duration) (string, time.Time, *ArtifactsError) {
+	body, err := b.client.postAPIJSON(fmt.Sprintf("/buckets/%s/uploadtoken", b.bucket.Id), map[string]interface{}{
+		"ttlSecs": int64(ttl / time.Second),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	bText, e := ioutil.ReadAll(body)
+	body.Close()
+	if e != nil {
+		return "", time.Time{}, NewRetriableError(e.Error())
+	}
+
+	var resp struct {
+		Token   string
+		Expires time.Time
+	}
+	if e := json.Unmarshal(bText, &resp); e != nil {
+		return "", time.Time{}, NewTerminalError(e.Error())
+	}
+
+	return resp.Token, resp.Expires, nil
+}
+
+// Delete deletes the bucket along with all its artifacts and their contents.
+func (b *Bucket) Delete() *ArtifactsError {
+	return ignoreBody(b.client.deleteAPI(fmt.Sprintf("/buckets/%s", b.bucket.Id)))
+}
+
 type Artifact interface {
 	// Returns a read-only copy of the raw model.Artifact instance associated with the artifact
 	GetArtifactModel() *model.Artifact
@@ -353,6 +684,9 @@ type Artifact interface {
 
 	// Returns a direct link to the raw contents of this artifact
 	GetContentURL() string
+
+	// Deletes the artifact along with its contents
+	Delete() *ArtifactsError
 }
 
 type ArtifactImpl struct {
@@ -373,6 +707,11 @@ func (ai *ArtifactImpl) GetContentURL() string {
 	return fmt.Sprintf("%s/buckets/%s/artifacts/%s/content", ai.bucket.client.server, ai.bucket.bucket.Id, ai.artifact.Name)
 }
 
+// Delete deletes the artifact along with its contents.
+func (ai *ArtifactImpl) Delete() *ArtifactsError {
+	return ignoreBody(ai.bucket.client.deleteAPI(fmt.Sprintf("/buckets/%s/artifacts/%s", ai.bucket.bucket.Id, ai.artifact.Name)))
+}
+
 // A chunked artifact is one which can be sent in chunks of
 // varying size. It is only complete upon the client manually
 // telling the server that it is complete, and is useful for
@@ -428,9 +767,52 @@ func newTicker() *backoff.Ticker {
 	return backoff.NewTicker(b)
 }
 
+// Query parameters holding the byte offset and the sizes of a batch of appended log chunks (same as
+// api.logChunkOffsetParam and api.logChunkSizesParam).
+const (
+	logChunkOffsetParam = "offset"
+	logChunkSizesParam  = "sizes"
+)
+
+// Limits on the log chunks sent with a single request by a ChunkedArtifact.
+const (
+	maxLogChunksPerBatch  = 100
+	maxLogChunkBatchBytes = 1 << 20
+)
+
+// nextLogChunkBatch returns logChunk along with the log chunks which are already pending, as long
+// as the batch is within limits.
+func (artifact *ChunkedArtifact) nextLogChunkBatch(logChunk []byte) [][]byte {
+	batch := [][]byte{logChunk}
+	batchBytes := len(logChunk)
+	for len(batch) < maxLogChunksPerBatch && batchBytes < maxLogChunkBatchBytes {
+		select {
+		case next, ok := <-artifact.bytestream:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, next)
+			batchBytes += len(next)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
 func (artifact *ChunkedArtifact) pushLogChunks() {
 	var err *ArtifactsError
 	for logChunk := range artifact.bytestream {
+		// Many small appends are coalesced into a single request.
+		batch := artifact.nextLogChunkBatch(logChunk)
+		sizes := make([]string, len(batch))
+		for i, chunk := range batch {
+			sizes[i] = strconv.Itoa(len(chunk))
+		}
+		content := bytes.Join(batch, nil)
+		path := fmt.Sprintf("/buckets/%s/artifacts/%s/chunks?%s=%d&%s=%s", artifact.bucket.bucket.Id, artifact.artifact.Name,
+			logChunkOffsetParam, artifact.offset, logChunkSizesParam, strings.Join(sizes, ","))
+
 		ticker := newTicker()
 		for {
 			// If our parent context has been cancelled, we discard state and get out.
@@ -442,11 +824,8 @@ func (artifact *ChunkedArtifact) pushLogChunks() {
 				return
 			}
 
-			err = ignoreBody(artifact.bucket.client.postAPIJSON(fmt.Sprintf("/buckets/%s/artifacts/%s", artifact.bucket.bucket.Id, artifact.artifact.Name), map[string]interface{}{
-				"size":       len(logChunk),
-				"bytes":      logChunk,
-				"byteoffset": artifact.offset,
-			}))
+			// Chunks are sent as is, rather than base64 encoded in JSON.
+			err = ignoreBody(artifact.bucket.client.postAPI(path, "application/octet-stream", bytes.NewReader(content)))
 
 			if err != nil {
 				if err.IsRetriable() {
@@ -464,7 +843,7 @@ func (artifact *ChunkedArtifact) pushLogChunks() {
 				}
 			}
 
-			artifact.offset += len(logChunk)
+			artifact.offset += len(content)
 			ticker.Stop()
 			break
 		}
@@ -500,10 +879,23 @@ func (a *StreamedArtifact) UploadArtifact(stream io.Reader) *ArtifactsError {
 			return NewTerminalError("Client context has closed during artifact upload. Bailing out without any further retries.")
 		}
 
-		err := ignoreBody(a.bucket.client.postAPI(url, "application/octet-stream", stream))
+		h := sha256.New()
+		body, err := a.bucket.client.postAPI(url, "application/octet-stream", io.TeeReader(stream, h))
 
 		if err == nil {
-			// TODO: Verify that the artifact that was stored matches the one we just uploaded.
+			uploaded, err := a.bucket.parseArtifactFromResponse(body)
+			if err != nil {
+				return err
+			}
+
+			// Verify that the artifact that was stored matches the one we just uploaded. Servers which
+			// don't record digests report an empty digest.
+			digest := hex.EncodeToString(h.Sum(nil))
+			if stored := uploaded.GetArtifactModel().Sha256; stored != "" && stored != digest {
+				return NewTerminalErrorf("SHA-256 digest of stored artifact %s does not match uploaded content %s", stored, digest)
+			}
+
+			a.artifact = uploaded.GetArtifactModel()
 			return nil
 		}
 
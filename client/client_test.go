@@ -77,7 +77,7 @@ func setupDB(tb testing.TB) {
 	// `maxMigrations` below is the maximum number of migration levels to be performed.
 	// This is left here to make it easy to verify that database upgrades are backwards compatible.
 	// After a new migration is added, this number should be bumped up.
-	const maxMigrations = 4
+	const maxMigrations = 16
 	if n, err := migrate.ExecMax(db, "postgres", migrations, migrate.Up, maxMigrations); err != nil {
 		tb.Fatalf("Error recreating Postgres DB: %s", err)
 	} else {
@@ -87,6 +87,25 @@ func setupDB(tb testing.TB) {
 	fmt.Println("************* DB RESET **************")
 }
 
+// waitForArtifactState polls the server until the artifact reaches the given state. Chunked
+// artifacts are merged and uploaded asynchronously after they are closed.
+func waitForArtifactState(tb testing.TB, bucket *Bucket, artifactName string, state model.ArtifactState) Artifact {
+	retries := 1000
+
+	for retries > 0 {
+		artifact, err := bucket.GetArtifact(artifactName)
+		require.NoError(tb, err)
+		if artifact.GetArtifactModel().State == state {
+			return artifact
+		}
+		retries--
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tb.Fatalf("Artifact %s did not reach state %s in time", artifactName, state)
+	return nil
+}
+
 func TestCreateAndGetBucket(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping end-to-end test in short mode.")
@@ -129,6 +148,41 @@ func TestCreateAndGetBucket(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestListBuckets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping end-to-end test in short mode.")
+	}
+
+	client := setup(t)
+
+	for _, b := range []struct{ id, owner string }{{"b1", "owner1"}, {"b2", "owner2"}, {"b3", "owner1"}, {"b4", "owner1"}} {
+		_, err := client.NewBucket(b.id, b.owner, 31)
+		require.NoError(t, err)
+	}
+
+	listIds := func(opts BucketListOptions) []string {
+		var ids []string
+		it := client.ListBuckets(opts)
+		for {
+			bucket, err := it.Next()
+			require.Nil(t, err)
+			if bucket == nil {
+				return ids
+			}
+			ids = append(ids, bucket.GetBucketModel().Id)
+		}
+	}
+
+	// Oldest first, across multiple pages.
+	require.Equal(t, []string{"b1", "b2", "b3", "b4"}, listIds(BucketListOptions{PageSize: 3}))
+	require.Equal(t, []string{"b1", "b3", "b4"}, listIds(BucketListOptions{Owner: "owner1", PageSize: 1}))
+
+	// Newest first
+	require.Equal(t, []string{"b4", "b3", "b2", "b1"}, listIds(BucketListOptions{Descending: true, PageSize: 3}))
+	require.Equal(t, []string{"b4", "b3", "b1"}, listIds(BucketListOptions{Owner: "owner1", Descending: true, PageSize: 1}))
+	require.Empty(t, listIds(BucketListOptions{State: model.CLOSED}))
+}
+
 func TestCreateAndGetChunkedArtifact(t *testing.T) {
 	bucketName := "bucketName"
 	ownerName := "ownerName"
@@ -328,9 +382,8 @@ func TestAppendToChunkedArtifact(t *testing.T) {
 	require.NoError(t, cartifact.Close())
 
 	// Verify it exists on S3
-	artifact, err = bucket.GetArtifact(artifactName)
+	artifact = waitForArtifactState(t, bucket, artifactName, model.UPLOADED)
 	require.NotNil(t, artifact)
-	require.Equal(t, model.UPLOADED, artifact.GetArtifactModel().State)
 	// require.Equal will crib if the types are not identical.
 	require.Equal(t, int64(30), artifact.GetArtifactModel().Size)
 	require.Equal(t, bucketName, artifact.GetArtifactModel().BucketId)
@@ -455,6 +508,41 @@ func TestPostStreamedArtifact(t *testing.T) {
 	require.Equal(t, 30, buf.Len())
 }
 
+func TestDeleteArtifactAndBucket(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping end-to-end test in short mode.")
+	}
+
+	client := setup(t)
+
+	bucket, err := client.NewBucket("bucketName", "ownerName", 31)
+	require.NoError(t, err)
+
+	streamedArtifact, err := bucket.NewStreamedArtifact("streamedArtifact", 10)
+	require.NoError(t, err)
+	require.NoError(t, streamedArtifact.UploadArtifact(bytes.NewReader([]byte("0123456789"))))
+
+	chunkedArtifact, err := bucket.NewChunkedArtifact("chunkedArtifact")
+	require.NoError(t, err)
+	require.NoError(t, chunkedArtifact.AppendLog("0123456789"))
+	require.NoError(t, chunkedArtifact.Flush())
+
+	require.NoError(t, streamedArtifact.Delete())
+	_, err = bucket.GetArtifact("streamedArtifact")
+	require.Error(t, err)
+	_, err = streamedArtifact.GetContent()
+	require.Error(t, err)
+
+	// Deleting again fails, since the artifact is gone.
+	require.Error(t, streamedArtifact.Delete())
+
+	require.NoError(t, bucket.Delete())
+	_, err = client.GetBucket("bucketName")
+	require.Error(t, err)
+	_, err = bucket.GetArtifact("chunkedArtifact")
+	require.Error(t, err)
+}
+
 func TestCreateAndListArtifacts(t *testing.T) {
 	bucketName := "bucketName"
 	ownerName := "ownerName"
@@ -495,6 +583,46 @@ func TestCreateAndListArtifacts(t *testing.T) {
 	require.Equal(t, artifactName2, artifacts[1].GetArtifactModel().Name)
 }
 
+func TestIterateArtifacts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping end-to-end test in short mode.")
+	}
+
+	client := setup(t)
+
+	bucket, err := client.NewBucket("bucketName", "ownerName", 31)
+	require.NoError(t, err)
+
+	for _, name := range []string{"a_1.xml", "ab1.xml", "b.log", "c.xml"} {
+		_, err := bucket.NewChunkedArtifact(name)
+		require.NoError(t, err)
+	}
+
+	listNames := func(opts ArtifactListOptions) []string {
+		var names []string
+		it := bucket.IterateArtifacts(opts)
+		for {
+			artifact, err := it.Next()
+			require.Nil(t, err)
+			if artifact == nil {
+				return names
+			}
+			names = append(names, artifact.GetArtifactModel().Name)
+		}
+	}
+
+	// Order of creation, across multiple pages.
+	require.Equal(t, []string{"a_1.xml", "ab1.xml", "b.log", "c.xml"}, listNames(ArtifactListOptions{PageSize: 3}))
+	require.Equal(t, []string{"c.xml", "b.log", "ab1.xml", "a_1.xml"}, listNames(ArtifactListOptions{SortBy: SortArtifactsByName, Descending: true, PageSize: 1}))
+
+	// '_' in a prefix is not a wildcard.
+	require.Equal(t, []string{"a_1.xml"}, listNames(ArtifactListOptions{NamePrefix: "a_"}))
+	require.Equal(t, []string{"a_1.xml", "ab1.xml", "c.xml"}, listNames(ArtifactListOptions{NameGlob: "*.xml"}))
+	require.Equal(t, []string{"a_1.xml", "ab1.xml"}, listNames(ArtifactListOptions{NameGlob: "a?1.*"}))
+	require.Equal(t, []string{"a_1.xml", "ab1.xml", "b.log", "c.xml"}, listNames(ArtifactListOptions{State: model.APPENDING}))
+	require.Empty(t, listNames(ArtifactListOptions{State: model.UPLOADED}))
+}
+
 func TestCreateDuplicateArtifactRace(t *testing.T) {
 	bucketName := "bucketName"
 	ownerName := "ownerName"
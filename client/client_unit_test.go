@@ -1,7 +1,9 @@
 package client
 
 import (
+	"bytes"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -81,6 +83,29 @@ func TestNewStreamedArtifactSuccessfully(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestUploadStreamedArtifactVerifiesDigest(t *testing.T) {
+	ts := testserver.NewTestServer(t)
+	defer ts.CloseAndAssertExpectations()
+
+	client := NewArtifactStoreClient(ts.URL)
+
+	ts.ExpectAndRespond("POST", "/buckets/", http.StatusOK, `{"Id": "foo"}`)
+	ts.ExpectAndRespond("POST", "/buckets/foo/artifacts", http.StatusOK, `{"Name": "artifact"}`)
+	ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact", http.StatusOK, `{"Name": "artifact", "sha256": "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"}`)
+	ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact", http.StatusOK, `{"Name": "artifact", "sha256": "0000"}`)
+
+	b, _ := client.NewBucket("foo", "bar", 32)
+	sa, _ := b.NewStreamedArtifact("artifact", 10)
+
+	require.Nil(t, sa.UploadArtifact(bytes.NewBufferString("0123456789")))
+	require.Equal(t, "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882", sa.GetArtifactModel().Sha256)
+
+	// Stored content doesn't match uploaded content
+	err := sa.UploadArtifact(bytes.NewBufferString("0123456789"))
+	require.Error(t, err)
+	require.False(t, err.IsRetriable(), "Error %s should not be retriable", err)
+}
+
 func TestNewBucketErrors(t *testing.T) {
 	testErrorCombinations(t, func(*testserver.TestServer, *ArtifactStoreClient) interface{} { return nil }, "POST", "/buckets/",
 		func(c *ArtifactStoreClient, _ interface{}) (interface{}, *ArtifactsError) {
@@ -136,6 +161,183 @@ func TestGetArtifactErrors(t *testing.T) {
 		})
 }
 
+func TestDeleteBucketAndArtifact(t *testing.T) {
+	ts := testserver.NewTestServer(t)
+	defer ts.CloseAndAssertExpectations()
+
+	client := NewArtifactStoreClient(ts.URL)
+
+	ts.ExpectAndRespond("POST", "/buckets/", http.StatusOK, `{"Id": "foo"}`)
+	ts.ExpectAndRespond("POST", "/buckets/foo/artifacts", http.StatusOK, `{"Name": "bar"}`)
+	b, _ := client.NewBucket("foo", "bar", 32)
+	sa, _ := b.NewStreamedArtifact("bar", 10)
+
+	ts.ExpectAndRespond("DELETE", "/buckets/foo/artifacts/bar", http.StatusOK, `{}`)
+	require.Nil(t, sa.Delete())
+
+	ts.ExpectAndRespond("DELETE", "/buckets/foo/artifacts/bar", http.StatusNotFound, `{"error": "Artifact not found"}`)
+	err := sa.Delete()
+	require.Error(t, err)
+	require.False(t, err.IsRetriable(), "Error %s should not be retriable", err)
+
+	ts.ExpectAndRespond("DELETE", "/buckets/foo", http.StatusInternalServerError, `{"error": "Something bad happened"}`)
+	err = b.Delete()
+	require.Error(t, err)
+	require.True(t, err.IsRetriable(), "Error %s should be retriable", err)
+
+	ts.ExpectAndRespond("DELETE", "/buckets/foo", http.StatusOK, `{}`)
+	require.Nil(t, b.Delete())
+}
+
+func TestBucketIterator(t *testing.T) {
+	ts := testserver.NewTestServer(t)
+	defer ts.CloseAndAssertExpectations()
+
+	client := NewArtifactStoreClient(ts.URL)
+
+	it := client.ListBuckets(BucketListOptions{
+		Owner:        "owner",
+		State:        model.CLOSED,
+		CreatedAfter: time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+		Descending:   true,
+		PageSize:     2,
+	})
+
+	query := "/buckets?createdAfter=2016-01-02T03%3A04%3A05Z&limit=2&owner=owner&sort=-dateCreated&state=CLOSED"
+	ts.ExpectAndRespondWithHeader("GET", query, http.StatusOK, http.Header{"X-Next-Cursor": {"abc"}}, `[{"Id": "b1"}, {"Id": "b2"}]`)
+	nextQuery := "/buckets?createdAfter=2016-01-02T03%3A04%3A05Z&cursor=abc&limit=2&owner=owner&sort=-dateCreated&state=CLOSED"
+	ts.ExpectAndRespond("GET", nextQuery, http.StatusInternalServerError, `{"error": "Something bad happened"}`)
+	ts.ExpectAndRespond("GET", nextQuery, http.StatusOK, `[{"Id": "b3"}]`)
+
+	b, err := it.Next()
+	require.Nil(t, err)
+	require.Equal(t, "b1", b.GetBucketModel().Id)
+	b, err = it.Next()
+	require.Nil(t, err)
+	require.Equal(t, "b2", b.GetBucketModel().Id)
+
+	// Errors can be retried.
+	b, err = it.Next()
+	require.Nil(t, b)
+	require.Error(t, err)
+	require.True(t, err.IsRetriable(), "Error %s should be retriable", err)
+
+	b, err = it.Next()
+	require.Nil(t, err)
+	require.Equal(t, "b3", b.GetBucketModel().Id)
+
+	// No more pages
+	b, err = it.Next()
+	require.Nil(t, b)
+	require.Nil(t, err)
+}
+
+func TestBucketIteratorEmpty(t *testing.T) {
+	ts := testserver.NewTestServer(t)
+	defer ts.CloseAndAssertExpectations()
+
+	client := NewArtifactStoreClient(ts.URL)
+	ts.ExpectAndRespond("GET", "/buckets?", http.StatusOK, `[]`)
+
+	b, err := client.ListBuckets(BucketListOptions{}).Next()
+	require.Nil(t, b)
+	require.Nil(t, err)
+}
+
+func TestArtifactIterator(t *testing.T) {
+	ts := testserver.NewTestServer(t)
+	defer ts.CloseAndAssertExpectations()
+
+	client := NewArtifactStoreClient(ts.URL)
+	bucket := &Bucket{client: client, bucket: &model.Bucket{Id: "bkt"}}
+
+	it := bucket.IterateArtifacts(ArtifactListOptions{
+		State:      model.UPLOADED,
+		NameGlob:   "*.xml",
+		SortBy:     SortArtifactsBySize,
+		Descending: true,
+		PageSize:   2,
+	})
+
+	ts.ExpectAndRespondWithHeader("GET", "/buckets/bkt/artifacts/?limit=2&nameGlob=%2A.xml&sort=-size&state=UPLOADED",
+		http.StatusOK, http.Header{"X-Next-Cursor": {"abc"}}, `[{"Name": "a1"}, {"Name": "a2"}]`)
+	ts.ExpectAndRespond("GET", "/buckets/bkt/artifacts/?cursor=abc&limit=2&nameGlob=%2A.xml&sort=-size&state=UPLOADED",
+		http.StatusOK, `[{"Name": "a3"}]`)
+
+	for _, name := range []string{"a1", "a2", "a3"} {
+		artifact, err := it.Next()
+		require.Nil(t, err)
+		require.Equal(t, name, artifact.GetArtifactModel().Name)
+		require.Equal(t, bucket, artifact.GetBucket())
+	}
+
+	artifact, err := it.Next()
+	require.Nil(t, artifact)
+	require.Nil(t, err)
+}
+
+func TestArtifactIteratorDefaults(t *testing.T) {
+	ts := testserver.NewTestServer(t)
+	defer ts.CloseAndAssertExpectations()
+
+	client := NewArtifactStoreClient(ts.URL)
+	bucket := &Bucket{client: client, bucket: &model.Bucket{Id: "bkt"}}
+
+	// Artifact listings are unlimited unless a page size is given, so one is always set.
+	ts.ExpectAndRespond("GET", "/buckets/bkt/artifacts/?limit=25", http.StatusOK, `[]`)
+	artifact, err := bucket.IterateArtifacts(ArtifactListOptions{}).Next()
+	require.Nil(t, artifact)
+	require.Nil(t, err)
+
+	ts.ExpectAndRespond("GET", "/buckets/bkt/artifacts/?limit=25&sort=-id", http.StatusOK, `mangled`)
+	artifact, err = bucket.IterateArtifacts(ArtifactListOptions{Descending: true}).Next()
+	require.Nil(t, artifact)
+	require.Error(t, err)
+	require.False(t, err.IsRetriable())
+}
+
+func TestAPITokenIsSent(t *testing.T) {
+	var authorizations []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		w.Write([]byte(`{"Id": "bkt"}`))
+	}))
+	defer ts.Close()
+
+	client := NewArtifactStoreClientWithContext(ts.URL, DefaultReqTimeout, context.Background(), WithAPIToken("secret"))
+	bucket, err := client.NewBucket("bkt", "owner", 0)
+	require.Nil(t, err)
+	_, err = client.GetBucket("bkt")
+	require.Nil(t, err)
+	require.Nil(t, bucket.Delete())
+	require.Equal(t, []string{"Bearer secret", "Bearer secret", "Bearer secret"}, authorizations)
+
+	// No credentials by default.
+	authorizations = nil
+	_, err = NewArtifactStoreClient(ts.URL).GetBucket("bkt")
+	require.Nil(t, err)
+	require.Equal(t, []string{""}, authorizations)
+}
+
+func TestNewUploadToken(t *testing.T) {
+	ts := testserver.NewTestServer(t)
+	defer ts.CloseAndAssertExpectations()
+
+	client := NewArtifactStoreClient(ts.URL)
+	bucket := &Bucket{client: client, bucket: &model.Bucket{Id: "bkt"}}
+
+	ts.ExpectAndRespond("POST", "/buckets/bkt/uploadtoken", http.StatusOK, `{"token": "upload.abc", "expires": "2016-01-02T03:04:05Z"}`)
+	token, expires, err := bucket.NewUploadToken(time.Hour)
+	require.Nil(t, err)
+	require.Equal(t, "upload.abc", token)
+	require.Equal(t, time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC), expires)
+
+	ts.ExpectAndRespond("POST", "/buckets/bkt/uploadtoken", http.StatusForbidden, `{"error": "Forbidden"}`)
+	_, _, err = bucket.NewUploadToken(time.Hour)
+	require.Error(t, err)
+	require.False(t, err.IsRetriable())
+}
+
 func testErrorCombinations(t *testing.T,
 	prerun func(*testserver.TestServer, *ArtifactStoreClient) interface{},
 	method string,
@@ -244,15 +446,17 @@ func TestUploadLogChunksAndFlushSuccessfully(t *testing.T) {
 	require.NoError(t, err)
 
 	{
-		// Content request might come later, even as late as Flush()
-		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact", 200, `{}`)
+		// Content request might come later, even as late as Flush(). Flushing here ensures that the
+		// next chunk is not sent in the same batch.
+		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact/chunks?offset=0&sizes=16", 200, `{}`)
 		err := sa.AppendLog("console contents")
 		require.NoError(t, err)
+		require.NoError(t, sa.Flush())
 	}
 
 	{
 		// Content request might come later, even as late as Flush()
-		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact", 200, `{}`)
+		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact/chunks?offset=16&sizes=21", 200, `{}`)
 		err := sa.AppendLog("more console contents")
 		require.NoError(t, err)
 	}
@@ -278,15 +482,17 @@ func TestUploadLogChunksAndCloseSuccessfully(t *testing.T) {
 	require.NoError(t, err)
 
 	{
-		// Content request might come later, even as late as Flush()
-		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact", 200, `{}`)
+		// Content request might come later, even as late as Flush(). Flushing here ensures that the
+		// next chunk is not sent in the same batch.
+		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact/chunks?offset=0&sizes=16", 200, `{}`)
 		err := sa.AppendLog("console contents")
 		require.NoError(t, err)
+		require.NoError(t, sa.Flush())
 	}
 
 	{
 		// Content request might come later, even as late as Flush()
-		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact", 200, `{}`)
+		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact/chunks?offset=16&sizes=21", 200, `{}`)
 		err := sa.AppendLog("more console contents")
 		require.NoError(t, err)
 	}
@@ -314,9 +520,9 @@ func TestPushLogChunkServerSucceedOnRetry(t *testing.T) {
 
 	{
 		// Fail with a retriable error first
-		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact", 500, `{}`)
+		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact/chunks?offset=0&sizes=16", 500, `{}`)
 		// Then succeed on retry
-		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact", 200, `{}`)
+		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact/chunks?offset=0&sizes=16", 200, `{}`)
 		err := sa.AppendLog("console contents")
 		require.NoError(t, err)
 	}
@@ -344,7 +550,7 @@ func TestPushLogChunkServerFailWithTerminalError(t *testing.T) {
 
 	{
 		// Fail with a terminal error
-		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact", 400, `{}`)
+		ts.ExpectAndRespond("POST", "/buckets/foo/artifacts/artifact/chunks?offset=0&sizes=16", 400, `{}`)
 		err := sa.AppendLog("console contents")
 		require.NoError(t, err)
 		err = sa.Close()
@@ -353,6 +559,33 @@ func TestPushLogChunkServerFailWithTerminalError(t *testing.T) {
 	}
 }
 
+func TestNextLogChunkBatch(t *testing.T) {
+	artifact := &ChunkedArtifact{bytestream: make(chan []byte, 2*maxLogChunksPerBatch)}
+
+	// Only chunks which are already pending are batched.
+	require.Equal(t, [][]byte{[]byte("a")}, artifact.nextLogChunkBatch([]byte("a")))
+
+	artifact.bytestream <- []byte("b")
+	artifact.bytestream <- []byte("c")
+	require.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, artifact.nextLogChunkBatch([]byte("a")))
+
+	// Batches are limited in number of chunks...
+	for i := 0; i < maxLogChunksPerBatch+1; i++ {
+		artifact.bytestream <- []byte("x")
+	}
+	require.Len(t, artifact.nextLogChunkBatch([]byte("a")), maxLogChunksPerBatch)
+	require.Len(t, artifact.nextLogChunkBatch([]byte("a")), 3)
+
+	// ...and in size.
+	large := make([]byte, maxLogChunkBatchBytes)
+	artifact.bytestream <- []byte("b")
+	require.Equal(t, [][]byte{large}, artifact.nextLogChunkBatch(large))
+
+	// Pending chunks are still batched after the stream is closed.
+	close(artifact.bytestream)
+	require.Equal(t, [][]byte{[]byte("a"), []byte("b")}, artifact.nextLogChunkBatch([]byte("a")))
+}
+
 func TestPushLogChunkCancelledContext(t *testing.T) {
 	ts := testserver.NewTestServer(t)
 	defer ts.CloseAndAssertExpectations()
@@ -2,5 +2,5 @@
 
 export GOMAXPROCS=4
 cd /go/src/github.com/dropbox/changes-artifacts
-go run server.go -migrations-only
-go run server.go -verbose
+go run server.go -migrations-only -config client/local_storage_config.json
+go run server.go -verbose -config client/local_storage_config.json
@@ -8,11 +8,12 @@ import (
 )
 
 type request struct {
-	method        string
-	url           string
-	responseCode  int
-	responseBytes string
-	shouldHang    bool
+	method         string
+	url            string
+	responseCode   int
+	responseHeader http.Header
+	responseBytes  string
+	shouldHang     bool
 }
 
 // TestServer wraps around httptest.Server to support expectations and timeout tests.
@@ -50,6 +51,19 @@ func (ts *TestServer) ExpectAndRespond(method string, url string, responseCode i
 	})
 }
 
+// ExpectAndRespondWithHeader is the same as ExpectAndRespond, but also sets given headers on the
+// response.
+func (ts *TestServer) ExpectAndRespondWithHeader(method string, url string, responseCode int, responseHeader http.Header, responseBytes string) *TestServer {
+	return ts.insertNextReq(request{
+		method:         method,
+		url:            url,
+		responseCode:   responseCode,
+		responseHeader: responseHeader,
+		responseBytes:  responseBytes,
+		shouldHang:     false,
+	})
+}
+
 // ExpectAndHang specifies the next request expected, the server hangs and the request will not be
 // responded to. This is useful to test client timeouts. To stop the hanging server, call
 // CloseAndAssertExpectations.
@@ -106,6 +120,9 @@ func (ts *TestServer) run() {
 			ts.waiter.L.Unlock()
 		} else {
 			ts.t.Logf("Responding with status %d\n", nextReq.responseCode)
+			for k, v := range nextReq.responseHeader {
+				w.Header()[k] = v
+			}
 			w.WriteHeader(nextReq.responseCode)
 			w.Write([]byte(nextReq.responseBytes))
 		}
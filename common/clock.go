@@ -77,16 +77,19 @@ func (m *MockClock) Advance(d time.Duration) *MockClock {
 	}
 
 	m.currentTime = m.currentTime.Add(d)
-	m.lock.Unlock()
 
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+	// Like time.AfterFunc, a scheduled event fires only once.
+	var event func()
+	if m.nextEventSet && m.currentTime.After(m.nextEventTimer) {
+		event = m.nextEvent
+		m.nextEventSet = false
+	}
+	m.lock.Unlock()
 
-	if m.nextEventSet {
-		if m.currentTime.After(m.nextEventTimer) {
-			// TODO: This is a synchronous call to avoid race conditions.
-			m.nextEvent()
-		}
+	if event != nil {
+		// TODO: This is a synchronous call to avoid race conditions.
+		// The lock is released first so that the event can schedule its successor using AfterFunc.
+		event()
 	}
 
 	return m
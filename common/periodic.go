@@ -0,0 +1,62 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// PeriodicTask runs a function repeatedly using a Clock. The next run is scheduled only after the
+// previous one completes, so runs never overlap. Using Clock (instead of time.Ticker) allows tests
+// to drive background tasks deterministically with MockClock.Advance.
+type PeriodicTask struct {
+	clk      Clock
+	interval time.Duration
+	f        func()
+
+	lock    sync.Mutex
+	stopped bool
+}
+
+// NewPeriodicTask creates a task which invokes f every interval once started.
+func NewPeriodicTask(clk Clock, interval time.Duration, f func()) *PeriodicTask {
+	return &PeriodicTask{clk: clk, interval: interval, f: f}
+}
+
+// Start schedules the first run of the task, one interval from now.
+func (p *PeriodicTask) Start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.stopped = false
+	p.clk.AfterFunc(p.interval, p.run)
+}
+
+// Stop prevents any further runs of the task. A run which is already in progress is allowed to
+// complete.
+func (p *PeriodicTask) Stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.stopped = true
+}
+
+func (p *PeriodicTask) run() {
+	if p.isStopped() {
+		return
+	}
+
+	p.f()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.stopped {
+		p.clk.AfterFunc(p.interval, p.run)
+	}
+}
+
+func (p *PeriodicTask) isStopped() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.stopped
+}
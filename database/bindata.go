@@ -1,9 +1,21 @@
 // Code generated by go-bindata.
 // sources:
+// migrations/10_bucket_listing.sql
+// migrations/11_artifact_listing.sql
+// migrations/12_api_tokens.sql
+// migrations/13_artifact_events.sql
+// migrations/14_versions.sql
+// migrations/15_artifact_notify.sql
+// migrations/16_artifact_notify_version.sql
 // migrations/1_initial.sql
 // migrations/2_index_artifactid_size.sql
 // migrations/3_add_relative_path.sql
 // migrations/4_byte_array.sql
+// migrations/5_index_artifact_state.sql
+// migrations/6_bucket_deadline.sql
+// migrations/7_artifact_dateupdated.sql
+// migrations/8_artifact_sha256.sql
+// migrations/9_bucket_owner_datecreated.sql
 // migrations/README
 // DO NOT EDIT!
 
@@ -72,6 +84,146 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
+var _migrations10_bucket_listingSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x8c\x91\xc1\x4e\xf3\x30\x10\x84\xef\x7e\x8a\x39\xfe\xd5\x1f\xf7\x05\x72\x42\x24\x87\x5e\x5a\x54\x40\xe2\x16\xb9\xf1\x36\x59\x11\xec\xc8\xde\x12\xfa\xf6\x28\xa1\x95\x2a\x6a\x85\x9c\x77\xf6\xdb\x99\x59\xad\xf1\xff\x83\x9b\x60\x84\xf0\xda\x2b\xad\xb1\x71\x96\xbe\x28\xe2\xe8\x03\x7a\xd3\xb0\x33\x42\x16\x87\x53\xfd\x4e\x82\x8e\xa3\xb0\x6b\x62\x86\xa1\xe5\xba\x85\x09\x04\x1f\x2c\x85\x51\x72\xc6\x3f\x6b\x84\xea\x40\xe3\x4a\x06\xb6\xab\x0c\xbe\x17\xf6\xce\x74\xdd\x79\x84\x1f\xb9\x93\xab\xd8\x0f\x8e\x02\x7c\x40\x14\x23\xb4\x56\x8f\xfb\xf2\xe1\xa5\xc4\x66\x5b\x94\x6f\x97\x7b\xd5\x0d\xaf\x62\x8b\xdd\xf6\x6a\xe4\xee\x52\x9e\xdc\x9f\x6e\xcc\x50\xa6\x79\x86\x65\xb0\xc9\xe7\x0c\x6c\x9a\xa7\x60\x5a\xe3\xf9\xd4\x53\x88\x64\x7f\xa2\x4b\x4b\xe0\x4b\xcf\xe6\xe0\x3f\x69\xad\x8a\xfd\xee\xe9\x0f\xef\x79\x42\x74\xe7\x29\x57\xea\xf6\xa7\x85\x1f\xdc\xb2\x30\xf3\x49\x96\xf6\x3b\x5f\xee\x6a\x51\x84\x8a\x93\x51\x53\xbf\x4c\xe9\x7e\x2b\xbe\x07\x00\x6e\x9b\x0f\x51\xe3\x02\x00\x00")
+
+func migrations10_bucket_listingSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations10_bucket_listingSql,
+		"migrations/10_bucket_listing.sql",
+	)
+}
+
+func migrations10_bucket_listingSql() (*asset, error) {
+	bytes, err := migrations10_bucket_listingSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/10_bucket_listing.sql", size: 739, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations11_artifact_listingSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x94\x92\x41\x4f\xc2\x40\x10\x85\xef\xfd\x15\xef\x08\x91\xfa\x07\x38\x19\xe9\x81\x68\xc0\x10\x49\xbc\x35\x43\x77\x68\x27\xc2\xb6\xee\x4e\x05\xfd\xf5\x66\x2b\x90\x92\x68\x57\xaf\xbb\x6f\xbe\xf7\xe6\xed\xa6\x29\x6e\xf6\x52\x3a\x52\xc6\xba\x49\xd2\x14\x73\x6b\xf8\xc8\x1e\xdb\xda\xa1\xa1\x52\x2c\x29\x1b\x90\x53\xd9\x52\xa1\xd8\x89\x57\xb1\xa5\xc7\x41\xb4\x12\x0b\xc2\xa6\x2d\x5e\x59\x27\xa8\x9d\x61\xc7\x06\x9b\x0f\x88\x99\xc0\xcb\x27\xa3\x76\x30\xa4\x5c\x38\x0e\x94\x80\x1f\x75\x32\xb1\x65\xd0\x59\xda\x33\x5a\xcf\x1e\x5a\x31\x46\xdf\xa4\x30\x1c\x2e\xc6\x68\xad\xbc\xb5\x0c\x09\x89\xc6\xb7\xc9\xfd\x2a\xbb\x7b\xce\x30\x5f\xcc\xb2\x97\x4b\xa0\xfc\x3c\x94\x8b\xc1\x72\x71\x39\xef\xd3\xc4\x8c\xa7\xb1\xe9\x10\x77\x00\x11\xae\xff\x06\xea\xed\x3b\xc0\xeb\xa9\x4e\xd8\x34\xc5\xda\xb3\xe9\x7a\xef\x7a\x21\x6b\xe0\x78\x47\x2a\xef\xdc\x90\x56\x68\x1c\x6f\xe5\x88\x3d\x69\x51\x85\x02\xc3\x0b\xe0\x71\xfe\x90\x45\xab\x09\xbc\xbc\x21\x55\x76\xf6\xb7\x44\x9d\xa7\xf2\x51\xcf\xc2\xbc\x6e\x7c\x7c\xdd\x7e\xc2\x98\xc5\xd5\x36\x3f\x58\x25\xfd\xdf\x38\xab\x0f\x36\x99\xad\x96\x4f\xff\xb2\x9e\x0e\x8f\xf4\x8b\x88\x48\xaf\xdf\x31\x22\x3e\xfd\x9e\x88\x2a\x08\xbe\x06\x00\x41\x79\xf4\xad\x71\x03\x00\x00")
+
+func migrations11_artifact_listingSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations11_artifact_listingSql,
+		"migrations/11_artifact_listing.sql",
+	)
+}
+
+func migrations11_artifact_listingSql() (*asset, error) {
+	bytes, err := migrations11_artifact_listingSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/11_artifact_listing.sql", size: 881, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations12_api_tokensSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x6c\x90\xd1\x4e\x83\x30\x18\x85\xef\xfb\x14\xe7\x6e\x5b\xdc\x9e\x80\xab\x0e\xfe\x69\x63\x29\x58\x7e\xa2\xf3\x8e\x8c\xc6\x35\x46\x20\xac\xc9\x5e\xdf\x50\x4c\x44\xc3\xe5\x69\xbf\xf4\x7c\xa7\x87\x03\x1e\xbe\xfc\xc7\xd8\x04\x87\x7a\x10\xa9\x25\xc9\x04\x96\x47\x4d\x68\x06\x1f\xfa\x4f\xd7\x61\x2b\x00\xdf\xe2\xa8\x1e\x2b\xb2\x4a\x6a\x98\x82\x61\x6a\xad\x51\x5a\x95\x4b\x7b\xc6\x33\x9d\xf7\x02\x88\xf8\xb5\xb9\x5d\xc1\xf4\xc6\xbf\x58\x6d\xd4\x4b\x4d\x13\xd1\xba\xdb\x65\xf4\x43\xf0\x7d\xf7\x8f\xc9\xe8\x24\x6b\xcd\xd8\x6c\x22\xd7\x04\x77\x19\x5d\x13\x5c\x0b\x56\x39\x55\x2c\xf3\x12\xaf\x8a\x9f\x62\xc4\x7b\x61\x48\xec\x92\x75\xe1\xfe\xde\xb9\x31\x5a\xc7\x38\xab\x2b\xb3\x28\xb3\x74\x22\x4b\x26\xa5\x6a\xb1\xd2\xb7\x3b\x14\x06\x19\x69\x62\x42\x2a\xab\x54\x66\x51\x7a\x7e\xef\x8f\xee\x74\xbc\x18\x8f\xed\x4f\xd3\x7e\x86\x77\x93\x9b\x58\x7e\x6e\xd6\xdf\x3b\x91\xd9\xa2\x5c\x73\x4d\xd6\x6e\x12\xf1\x3d\x00\x5e\x93\x4d\x03\x9d\x01\x00\x00")
+
+func migrations12_api_tokensSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations12_api_tokensSql,
+		"migrations/12_api_tokens.sql",
+	)
+}
+
+func migrations12_api_tokensSql() (*asset, error) {
+	bytes, err := migrations12_api_tokensSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/12_api_tokens.sql", size: 413, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations13_artifact_eventsSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xbc\x54\xc1\x6e\xea\x38\x14\xdd\xfb\x2b\xce\x02\x09\xa2\x81\x6a\xf6\xd1\x2c\x4c\x72\x03\x56\x83\x83\x1c\x67\x68\x67\x13\xa5\xc4\xa5\xd6\x40\xc2\x24\x9e\x19\xbd\xbf\x7f\x0a\x34\x84\xf6\x95\x56\x6f\xf3\x76\x89\x7c\xcf\xb9\xe7\x9c\x7b\xed\xd9\x0c\xbf\x1d\xec\xae\x29\x9c\x41\x76\x64\xb3\x19\xf8\xbf\xa5\x75\xd8\xd7\x3b\xd4\xcf\x28\x1a\x67\x9f\x8b\xad\x43\xeb\xba\x0a\xd7\x14\x55\x6b\x9d\xad\xab\x76\x8a\x43\x61\x2b\x57\xd8\xca\x94\x78\xfa\x06\xd7\xd8\xdd\xce\x34\x2d\xea\x0a\xee\xc5\x0c\x48\x57\x3c\xed\x0d\xda\x1a\xee\xa5\x70\xa8\xea\xae\xc7\xc0\x83\x6d\x51\xe1\xc9\xe0\x60\xdb\xd6\x94\x77\x2c\x50\xc4\x35\x41\xf3\x79\x4c\x17\x8e\xdc\xfc\x67\x2a\x87\x09\x03\x6c\x89\xb9\x58\xa4\xa4\x04\x8f\x21\x13\x0d\x99\xc5\x31\xd6\x4a\xac\xb8\x7a\xc4\x3d\x3d\x4e\x19\x2e\xb8\x73\xb1\x90\x7a\xa8\x54\x14\x91\x22\x19\x50\x3a\x28\x9c\xd8\xd2\x43\x22\x11\x52\x4c\x9a\x10\xf0\x34\xe0\x21\x75\x44\xcf\x4d\x7d\x38\x3b\xd7\xf4\x30\xb0\x74\x47\xae\xbe\x71\x50\x16\xce\x6c\x1b\x53\x38\x53\x42\x8b\x15\xa5\x9a\xaf\xd6\xd8\x08\xbd\x3c\xfd\xe2\xaf\x44\xd2\x05\xc0\x3c\xbf\xb7\x2c\x64\x48\x0f\xef\x2c\xe7\x83\x93\xdc\x96\x9d\xc6\xf7\x91\x0c\x05\x53\xd8\xd2\xf3\x19\xbb\x9e\x68\xda\x49\x3c\x98\xca\xcd\xcd\xce\x56\x7d\xa7\x28\x93\x81\x16\x89\x44\x63\xb6\x75\x53\xe6\x6f\x39\x27\x1e\x14\xe9\x4c\xc9\xb4\x9f\x29\x78\x8a\xd1\x88\xcd\x69\x21\x24\x03\x44\x04\xbd\xc8\x93\x35\xfe\xc0\x58\xc8\x94\x94\x1e\x43\x2f\xa9\x3b\x02\x66\x33\x04\xaf\xe6\xbb\xf0\x90\xc9\x7b\x99\x6c\x64\xce\x95\x16\x11\x0f\x74\x9e\x6a\xae\xe9\xee\x54\x7b\x06\x43\x48\x9d\x7c\xea\xeb\x32\x85\x69\x9f\xfa\xf4\x3a\x65\xef\x44\x06\xfc\xc9\xe3\x8c\x52\x4c\x24\x6d\xee\x3a\xd8\xf8\xf7\xf1\x14\xdd\xcf\x2b\x64\xbb\xaf\xb7\x7f\xe7\xce\x1e\x4c\xeb\x8a\xc3\x71\xe2\x79\x3e\x03\x28\x4e\xe9\x57\xa8\x49\xe2\xb0\x17\xf2\xb5\x26\x19\x42\x44\xdd\xd7\x79\x12\xa7\x55\xf1\x19\xc9\xd0\x67\xa3\x11\x62\x2e\x17\x19\x5f\x10\x8e\xfb\xe3\xae\xfd\x67\xef\x7f\x3c\x73\xaa\x4a\x76\xb9\x4f\x4a\x2c\x16\xa4\x06\x63\xfd\x86\xf2\x48\x93\xea\xbd\x5f\xed\x17\x03\xa2\x44\x81\x78\xb0\x84\x4a\x36\xa0\x07\x0a\x32\x4d\x58\xab\x24\xa0\x30\x53\x74\x6b\x79\xfc\x9b\x2d\x4f\x96\xf3\xed\x4b\x51\xed\x2e\x8d\xb3\x75\xd8\xd5\x26\xd1\xeb\x03\xf3\x89\x82\xcd\x92\x24\x26\x97\x18\x21\x52\x84\x22\xd5\x42\x06\x1a\x91\x4a\x56\x43\xac\xde\xcf\xa8\x7d\x93\x5d\x58\xff\x5f\xb1\x50\x25\xeb\xaf\xd4\x5f\xe9\xf4\x6f\x00\xfa\x84\x7f\x2c\xfd\xea\xfe\xf5\x8c\x1f\xbc\x81\x3e\xfb\x3e\x00\x18\xc8\xe6\x1b\xb1\x05\x00\x00")
+
+func migrations13_artifact_eventsSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations13_artifact_eventsSql,
+		"migrations/13_artifact_events.sql",
+	)
+}
+
+func migrations13_artifact_eventsSql() (*asset, error) {
+	bytes, err := migrations13_artifact_eventsSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/13_artifact_events.sql", size: 1457, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations14_versionsSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x94\xd0\xc1\x4a\xc4\x30\x10\x06\xe0\x7b\x9f\xe2\xbf\x4b\x17\x3c\xef\xa9\x6b\xaa\x2c\xc4\x56\x96\xc4\x7b\x36\x9d\xda\x60\x9b\x94\xc9\xd4\xb2\x6f\x2f\x05\x11\x95\x5e\xbc\x0d\xc3\xcc\xf7\x0f\x53\x96\xb8\x9b\xc2\x1b\x3b\x21\xd8\xb9\x28\x4b\xbc\x12\xe7\x90\x22\xe2\x32\x5d\x89\x33\xfa\xc4\x48\xb3\x84\x29\x64\x09\x1e\x3e\x45\xbf\x30\x53\xf4\xb7\xad\x16\x4e\xe3\x01\x76\xee\x9c\x50\x46\x8a\xe3\x0d\x79\xf1\x9e\xa8\x43\xe8\x21\x03\x81\xd3\x8a\x2c\x61\x1c\x31\xb8\xbc\x05\x6c\xcd\x8f\xaf\x10\x19\x9c\x60\x75\x19\x4c\xae\x3b\x14\x95\x36\xf5\x05\xa6\x3a\xe9\x1a\xd7\xc5\xbf\x93\xa0\x52\x0a\x0f\xad\xb6\xcf\xcd\xf7\xd2\xe9\xfc\x74\x6e\x0c\x9a\xd6\xa0\xb1\x5a\x43\xd5\x8f\x95\xd5\x06\xf7\xc7\x5f\x80\x63\x09\xbd\xf3\xff\x25\x8a\x9f\x2f\x51\x69\x8d\xfb\xa8\xba\xb4\x2f\x7f\xd4\xe3\xde\xfd\xbb\x73\x9f\x03\x00\x81\x0e\x57\xba\x76\x01\x00\x00")
+
+func migrations14_versionsSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations14_versionsSql,
+		"migrations/14_versions.sql",
+	)
+}
+
+func migrations14_versionsSql() (*asset, error) {
+	bytes, err := migrations14_versionsSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/14_versions.sql", size: 374, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations15_artifact_notifySql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x94\x52\xcd\x6e\xa3\x3c\x14\xdd\xfb\x29\xce\x22\x52\x13\x7d\x49\x1f\xa0\xac\x28\x98\x14\x29\xb5\x23\x07\xd4\x6f\x17\x59\x70\x43\x2c\x11\x9b\x31\x56\x67\xd2\xa7\x1f\x01\x6d\xd4\xbf\xcc\x68\x56\x96\xec\x73\xcf\xcf\x3d\x5e\xad\xf0\xdf\xc9\x34\x5e\x07\x42\xd9\xb1\xd5\x0a\xc2\x05\x73\x38\xa3\x27\xff\x4c\xbe\x47\x6b\xfa\x40\xd6\xd8\x06\xce\x22\x1c\x09\xda\x07\x73\xd0\x55\xd8\x57\x47\x6d\x1b\xaa\x31\x9c\x96\x5a\xb8\x03\xa6\xab\x1e\xc1\x8d\xd0\x3e\x0c\xb4\xce\xa3\x37\x2f\x84\x79\x38\xea\x00\xd3\x2f\x07\x15\xdd\x75\x64\xeb\x61\xda\xd9\x40\x36\x2c\x86\x71\x6d\x2f\xec\xb7\x93\x0f\x53\xe9\x60\x9c\xed\xa1\x3d\xc1\xd9\xf6\x8c\x9a\x5a\xf3\x4c\x9e\x6a\x38\x5b\xd1\x28\x33\xa9\xa2\x72\xa7\x93\x09\xfd\x12\xda\xd6\x83\x44\xa5\xbd\x3f\x7f\xb0\x0c\x53\xdf\xb2\xf7\x89\x77\x83\xc1\x13\xd9\x70\x4f\x8d\xb1\x2c\x51\x3c\x2e\x38\xb2\x52\x24\x45\x2e\x05\xec\xb8\x8a\xfd\xe7\xc4\xf3\x05\x14\x2f\x4a\x25\x76\x08\xde\x34\x0d\x79\xc4\x3b\xcc\x66\xec\x9e\xaf\x73\xc1\x80\x2d\x57\x99\x54\x8f\xe8\x9a\xfd\x44\x31\xbf\xf9\xcc\x71\xb3\x84\xdc\xa4\xb7\xa6\xbe\xbb\x0b\xf4\x2b\x2c\x22\x86\x57\x52\x88\x72\xb3\x89\x18\x17\x69\xc4\x66\x33\x6c\x62\xb1\x2e\xe3\x35\x47\xd7\x76\x4d\xff\xa3\x8d\xbe\x0f\xc0\x6d\xcd\xde\xfc\x17\x2a\x5f\xaf\xb9\xfa\xda\x54\x9c\x15\x5c\xa1\xdc\xa6\x03\x4a\x66\x53\x3f\xcb\xa9\x1d\x29\x2e\x78\x06\x64\x52\x81\xc7\xc9\x03\x94\x7c\xc2\xd3\x03\x17\x98\x0f\x76\xc7\x01\xe4\x3b\xa4\xf9\xae\xc8\x45\x52\x20\x53\xf2\x11\x82\x3f\xbd\x3e\x49\x35\xc6\x1a\x19\xbf\x87\x99\x17\x5a\x30\x80\xff\xcf\x93\xb2\xe0\xd8\x2a\x99\xf0\xb4\x54\xfc\xfa\xb2\xa3\xab\xb9\x6a\x6a\x29\x5c\x72\xa5\x7c\xc3\x0b\xfe\xa7\x20\xff\x24\xfa\x61\xcf\xa9\xfb\x69\x59\xaa\xe4\xf6\xba\x89\x77\xba\xd1\x15\xe8\x5b\x0f\x5f\xa1\x7f\xff\x72\x11\xfb\x3d\x00\x04\x75\xa6\x9e\xab\x03\x00\x00")
+
+func migrations15_artifact_notifySqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations15_artifact_notifySql,
+		"migrations/15_artifact_notify.sql",
+	)
+}
+
+func migrations15_artifact_notifySql() (*asset, error) {
+	bytes, err := migrations15_artifact_notifySqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/15_artifact_notify.sql", size: 939, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations16_artifact_notify_versionSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xbc\x91\xdf\x8a\xd3\x40\x14\xc6\xef\xf3\x14\x1f\x4b\x21\x2d\x6e\xf7\x01\xda\x75\xa1\xdb\x4c\x6b\x20\x4e\x4a\x9a\xe0\x65\x19\x33\xa7\xe9\xe0\xec\x24\xce\x9c\x5a\x03\xfb\xf0\x92\xed\x5a\xb4\x7a\xa3\x82\x57\x09\xe7\x70\x7e\xdf\x9f\x99\x4e\xf1\xe6\xc9\x34\x5e\x31\xa1\xea\xa2\xe9\x14\xa9\xab\xed\x51\x13\xf8\x40\x70\x74\x82\xf2\x6c\xf6\xaa\x66\x7c\x21\x1f\x4c\xeb\x60\xdc\x65\xb6\xab\x0f\xca\x35\xa4\xe1\x5a\x36\x7b\x53\x2b\x36\xad\x0b\x68\xf7\x38\x76\x5a\x31\x05\x8c\x55\x18\xa0\x37\xf7\x46\x3f\xcc\xee\x5f\x11\x0f\x37\x93\x5b\x84\x16\x7c\x50\x8c\x40\x7e\x18\xa3\x56\x0e\x4c\xd6\xfe\xca\x3a\x8b\x84\xc1\x51\x0f\x65\x3d\x29\xdd\xe3\x93\x6b\x4f\x50\x1f\xdb\x23\xdf\x0d\x02\xf2\xfa\x48\x93\x25\x26\x7d\xb1\x1a\x10\xd8\x58\x8b\x5a\x79\xdf\xa3\x75\xb6\x7f\x49\xf8\x7d\x0d\xa3\x5f\x38\x97\x32\xb6\xac\x98\x9e\xc8\xf1\x23\x35\xc6\x45\xcb\x42\x2c\x4a\x81\xbc\x40\x21\x36\xd9\x62\x29\xb0\xaa\xe4\xb2\x4c\x73\x79\xf6\xdb\xef\xae\x3b\x19\x4f\x50\x88\xb2\x2a\xe4\x16\xec\x4d\xd3\x90\xc7\x62\x8b\xd1\x28\x7a\x14\xeb\x54\x46\x40\xba\x42\xb9\xde\xe5\x1b\xbc\x45\x9c\x88\x4c\x94\x22\x46\xf9\x4e\x0c\x2b\x60\x23\x8a\x55\x5e\xbc\x47\xd7\xec\xce\xfc\x71\x7c\x2d\x10\xdf\x22\xcf\x92\x3b\xa3\x67\x33\xa6\xaf\x3c\x99\x47\x80\xc8\xb6\xe2\x0f\xee\xa5\xf8\x70\xb9\xc7\xf3\x33\xe2\x59\x3c\x7c\x86\xf1\xeb\x53\xfd\xc8\x96\x09\xd2\xd5\xf0\x77\xce\x05\x59\x65\xd9\x3c\x12\x32\x99\x47\xa3\x11\xb2\x85\x5c\x57\x8b\xb5\x40\x67\xbb\x26\x7c\xb6\xf3\xdf\xd7\x29\x9c\x8e\x7e\xda\x24\xed\xc9\xfd\xe7\xe6\xff\xb2\xdc\x7f\x8d\xfd\x6d\x00\x5d\x6b\x7a\xcc\x6c\x03\x00\x00")
+
+func migrations16_artifact_notify_versionSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations16_artifact_notify_versionSql,
+		"migrations/16_artifact_notify_version.sql",
+	)
+}
+
+func migrations16_artifact_notify_versionSql() (*asset, error) {
+	bytes, err := migrations16_artifact_notify_versionSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/16_artifact_notify_version.sql", size: 876, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
 var _migrations1_initialSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x94\x92\x41\x73\xba\x30\x10\xc5\xef\x7c\x8a\x1d\x2e\xea\xfc\xf5\xf4\x3f\x7a\xc2\x9a\xb6\x99\x22\x2a\x84\xa9\xf6\x16\xc3\xaa\x19\x31\x30\x10\xc6\xb6\x9f\xbe\x09\x88\xd2\xce\xb4\xb6\xc7\xf7\xf2\xd8\xfd\xed\xb2\xa3\x11\xfc\x3b\xca\x5d\xc1\x35\x42\x9c\x3b\x46\x46\x4b\x1f\xa4\x82\x12\x85\x96\x99\x82\x5e\x9c\xf7\x40\x96\x80\xaf\x28\x2a\x8d\x09\x9c\xf6\xa8\x40\xef\x8d\xd5\x7c\x67\x43\x46\xf0\x3c\x4f\x25\x26\xce\x5d\x48\x3c\x46\x80\x79\x13\x9f\x00\xbd\x87\x60\xce\x80\xac\x68\xc4\x22\xd8\x54\xe2\x80\x1a\xfa\x0e\x40\x62\xfa\x89\x34\x2b\x4d\x3d\x46\x67\x24\x62\xde\x6c\x01\xcf\x94\x3d\xd6\x12\x5e\xe6\x01\x19\xb6\xb1\x02\xb9\xbe\x91\x93\xe6\x99\xac\x58\xdd\x2c\x88\x7d\x1f\x16\x21\x9d\x79\xe1\x1a\x9e\xc8\xda\xbe\x67\x27\x85\x45\x1d\xb1\xaa\xd4\x76\x5a\xab\x9c\xc1\xf8\x27\x60\x5e\x68\xb9\xe5\xa2\x41\x6e\xe8\xcf\x9d\xfe\x08\x37\xa1\x0f\x11\x09\xa9\xe7\x7f\x4b\xa8\xf8\x11\xaf\x80\xff\xab\x22\xbd\x2a\xf9\x8e\xb6\x02\x0d\xbe\xd0\xd7\x10\xc8\x93\x54\x2a\x3c\x4a\x55\x5e\xcc\x38\xa0\xcb\x98\x40\xbf\x45\x1e\xd6\xe5\x07\x37\xa6\x4d\xb3\x9d\xd8\x57\xea\x00\x7d\x57\x26\xee\x0d\x66\x18\x82\xdb\xae\xe7\x9c\xb6\x7c\xe0\x6e\xde\x34\x66\xdb\x6d\x89\xba\x63\xda\x11\x3a\x52\x64\x4a\xa3\x32\x01\x0b\x6c\xa0\x9c\xee\x15\x4e\xcd\xcf\x6a\xef\xf0\x72\x84\xd6\xfc\xd5\x19\x16\x59\x9a\x9a\xd7\x0d\x17\x07\x67\x1a\xce\x17\xe7\x49\x9b\x4d\x8c\xbb\x56\x4b\xff\xc9\x6c\x77\x30\x76\x3e\x02\x00\x00\xff\xff\x08\x1b\xf1\xb5\x19\x03\x00\x00")
 
 func migrations1_initialSqlBytes() ([]byte, error) {
@@ -152,6 +304,106 @@ func migrations4_byte_arraySql() (*asset, error) {
 	return a, nil
 }
 
+var _migrations5_index_artifact_stateSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xd2\xd5\x55\xd0\xce\xcd\x4c\x2f\x4a\x2c\x49\x55\x08\x2d\xe0\x72\x0e\x72\x75\x0c\x71\x55\xf0\xf4\x73\x71\x8d\x50\x48\x2c\x2a\xc9\x4c\x4b\x4c\x2e\x89\x2f\x2e\x49\x2c\x49\x8d\x4f\x49\x2c\x49\x4d\x2e\x4a\x4d\x2c\x49\x4d\x51\xf0\xf7\x83\xcb\x2a\x68\x80\xa5\x75\x14\x90\xe4\x35\xad\xb9\xb8\x90\x4d\x76\xc9\x2f\xcf\xe3\x72\x09\xf2\x0f\x20\x68\xb2\x35\x17\x60\x00\xa5\x36\x89\x6e\x92\x00\x00\x00")
+
+func migrations5_index_artifact_stateSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations5_index_artifact_stateSql,
+		"migrations/5_index_artifact_state.sql",
+	)
+}
+
+func migrations5_index_artifact_stateSql() (*asset, error) {
+	bytes, err := migrations5_index_artifact_stateSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/5_index_artifact_state.sql", size: 146, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations6_bucket_deadlineSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x84\xcf\xc1\x4a\x03\x31\x10\xc6\xf1\x7b\x9e\xe2\x3b\xb6\xe8\x4a\xef\x7b\x8a\xcd\x28\x85\x98\x95\x25\x0b\xde\x4a\x6c\x86\x6d\xd0\x4d\x64\x33\x45\x7d\x7b\xa1\x54\xe9\x61\xc1\xf3\xc7\xfc\x86\x7f\xd3\xe0\x66\x4a\xe3\x1c\x84\x31\x7c\xa8\xa6\x01\x7d\xa5\x2a\x29\x8f\x78\x3d\x1d\xde\x58\x2a\x46\x16\xe4\x82\xc8\x21\xbe\xa7\xcc\x58\x6d\xd6\xa8\x05\x72\x0c\x02\x39\xf2\x37\xc2\xcc\xc8\x45\x20\x69\xe2\x88\x72\x12\x70\xc6\x14\x6a\xe5\x3b\xa5\xad\xa7\x1e\x5e\xdf\x5b\xba\x80\xd0\xc6\x60\xdb\xd9\xe1\xc9\xfd\x99\x53\xca\x15\x3b\xe7\xe9\x91\x7a\xb8\xce\xc3\x0d\xd6\xc2\xd0\x83\x1e\xac\xc7\xa6\x55\xdb\x9e\xb4\x27\xec\x9c\xa1\x97\x8b\xb3\xaf\x12\x84\xf7\x31\x08\x1f\x66\x0e\xc2\x11\x9d\xfb\xfd\xb1\x3a\x8f\xb7\xb8\x5a\xd7\xad\x52\xd7\xb5\xa6\x7c\x66\x65\xfa\xee\xf9\x1f\xb5\x5d\x6a\x38\xdf\x2d\x44\xb4\xea\x67\x00\xe8\xb4\x0d\x2f\x51\x01\x00\x00")
+
+func migrations6_bucket_deadlineSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations6_bucket_deadlineSql,
+		"migrations/6_bucket_deadline.sql",
+	)
+}
+
+func migrations6_bucket_deadlineSql() (*asset, error) {
+	bytes, err := migrations6_bucket_deadlineSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/6_bucket_deadline.sql", size: 337, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations7_artifact_dateupdatedSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x8c\x91\xc1\x6e\xa3\x30\x10\x86\xef\x3c\xc5\x7f\x4b\xa2\x0d\xfb\x02\x68\x0f\x6c\x70\x15\x24\x02\x11\x31\x4a\xd4\x4b\x64\xc1\x50\x50\x13\x1b\x8d\x4d\xd3\xc7\xaf\xe0\x90\x82\xa2\x2a\xbd\x58\x1a\xdb\xf3\xfd\xdf\xd8\xbe\x8f\x3f\xd7\xf6\x8d\x95\x23\x14\x9d\xe7\xfb\xa8\x94\xa3\xbe\x1b\xd6\x0a\xad\x45\x6f\xa9\x82\x33\xa8\xc8\x51\xe9\xa0\xd8\xb5\xb5\x2a\x9d\xc5\xad\x69\xcb\x06\x8a\x09\xd6\xf5\xe5\x3b\x5a\x0d\x05\xc7\x4a\xdb\x96\xb4\x83\x75\x03\x72\x59\x1b\x06\x7d\xaa\x6b\x77\xa1\x35\x54\xed\x88\x87\x0c\x05\x4b\xfc\x41\x8c\x92\x95\x6d\x86\x56\xd7\x10\xae\x6d\x55\x5d\x08\xa6\x86\xd2\xe8\xbb\x8b\x51\xd5\xea\xaf\x17\x26\x52\xe4\x90\xe1\xff\x44\xdc\xd3\x11\x46\x11\x36\x59\x52\xec\xd2\x99\xaf\x8c\x77\xe2\x20\xc3\xdd\x1e\xc7\x58\x6e\xc7\x12\xaf\x59\x2a\x90\x66\x12\x69\x91\x24\x88\xc4\x4b\x58\x24\x12\xda\xdc\x96\xab\xc0\x2b\xf6\x51\x28\x27\xdc\x83\x90\x33\xe0\xbf\xb1\x2a\x99\xc6\xea\xb8\x15\xb9\x98\xed\xc4\x87\x3b\x3a\x78\x6a\x4a\xcc\x86\x99\x94\x35\x1a\x52\x9c\xe4\xa3\xd5\x62\x11\x78\x9b\x5c\x0c\x4a\x71\x1a\x89\xd3\x1d\x73\x1e\x9f\xf3\x3c\x55\xcb\xd2\xef\x90\xe5\x78\xbc\x9e\xaa\xaf\x02\xcf\x9b\x7e\x6e\x64\x6e\xda\x8b\xf2\x6c\xff\x94\xfc\xc3\x20\x63\xef\xe3\x24\xbf\xb8\x3d\x63\x7f\x0d\x00\x0a\xad\x7e\x61\x71\x02\x00\x00")
+
+func migrations7_artifact_dateupdatedSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations7_artifact_dateupdatedSql,
+		"migrations/7_artifact_dateupdated.sql",
+	)
+}
+
+func migrations7_artifact_dateupdatedSql() (*asset, error) {
+	bytes, err := migrations7_artifact_dateupdatedSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/7_artifact_dateupdated.sql", size: 625, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations8_artifact_sha256Sql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x6c\x8f\x3d\x4f\xc3\x30\x18\x84\x77\xff\x8a\xdb\x3a\x80\x3b\x20\xc1\xd2\xc9\x60\xa3\x0e\x26\x41\xc5\x91\x58\x8d\xfd\x26\xb5\xd4\xf8\x8d\x1c\x57\x81\x7f\x8f\x22\x10\x1f\x52\xf7\xbb\xe7\xb9\x93\x12\x57\x63\x1a\x8a\xaf\x84\x6e\x12\x52\x62\x4f\xef\xa0\x1c\x38\x52\xc4\xcb\x5e\xc9\x9b\xdb\x3b\xc4\x34\xd0\x5c\xc1\x3d\x7c\xa9\xa9\xf7\xa1\x22\x70\xae\x94\xeb\x35\x02\x8f\xd3\xb9\x52\xc4\x72\x4c\x27\xc2\x79\x3a\xb1\x8f\x29\x0f\x5b\x98\x71\xaa\x1f\xe8\xb9\xfc\xb4\xe6\x55\xf0\x95\xa0\x88\x37\xea\xb9\xd0\x37\x7c\xc6\x42\x85\x50\x28\x70\x89\x14\xb7\x42\x59\x67\x0e\x70\xea\xde\x9a\x5f\xab\xd2\x1a\x0f\xad\xed\x9e\x1a\xcc\x47\xbf\x4e\x73\xe6\xd5\xa1\x69\x1d\x9a\xce\x5a\x68\xf3\xa8\x3a\xeb\xb0\xd9\xec\x84\xf8\xfb\x4d\xf3\x92\x2f\x23\xf5\xa1\x7d\xfe\xcf\xdc\x89\xcf\x01\x00\x0c\xaa\x8c\x4c\x16\x01\x00\x00")
+
+func migrations8_artifact_sha256SqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations8_artifact_sha256Sql,
+		"migrations/8_artifact_sha256.sql",
+	)
+}
+
+func migrations8_artifact_sha256Sql() (*asset, error) {
+	bytes, err := migrations8_artifact_sha256SqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/8_artifact_sha256.sql", size: 278, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
+var _migrations9_bucket_owner_datecreatedSql = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x84\xce\xb1\xce\x82\x30\x14\xc5\xf1\xbd\x4f\x71\xb6\xef\x33\x5a\x5f\x80\xc9\x08\x83\x0b\x18\x22\x89\x1b\xa9\xed\x55\x1a\xf5\x96\x94\x8b\xd5\xb7\x37\x21\x0c\x6c\xce\xbf\x93\x7f\x8e\xd6\x58\x3f\xfd\x2d\x1a\x21\x34\xbd\xd2\x1a\xcd\x40\x0e\x12\x70\xf5\xec\x70\x19\xed\x9d\x64\x40\xea\xbc\xed\xd0\x99\x17\x81\xde\xbd\x8f\xe4\x30\xb2\xa3\x08\xc3\x08\x89\x29\xfe\x0d\x88\x24\xc4\xe2\x03\xa3\x0f\x0f\x6f\x3f\x5b\xb5\xaf\x8b\xdd\xa9\xc0\xa1\xcc\x8b\xf3\x9c\x6a\xa7\x75\xeb\x8c\x90\x8d\x64\x84\x1c\xaa\x72\x36\xfc\x4f\xb8\xc1\x42\x57\x99\x52\xcb\x8f\x79\x48\xac\xf2\xba\x3a\xfe\xa8\x66\xea\x3b\x00\x2f\x8d\x29\x40\xda\x00\x00\x00")
+
+func migrations9_bucket_owner_datecreatedSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_migrations9_bucket_owner_datecreatedSql,
+		"migrations/9_bucket_owner_datecreated.sql",
+	)
+}
+
+func migrations9_bucket_owner_datecreatedSql() (*asset, error) {
+	bytes, err := migrations9_bucket_owner_datecreatedSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "migrations/9_bucket_owner_datecreated.sql", size: 218, mode: os.FileMode(436), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info:  info}
+	return a, nil
+}
+
 var _migrationsReadme = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x9c\x53\xcd\x6e\xdb\x30\x0c\xbe\xeb\x29\x08\xf4\xd2\x06\x89\x73\x0f\xba\x02\x2d\xb2\xe3\x7e\x80\x06\xd8\x31\x91\x2d\xda\x16\x2a\x4b\xaa\x48\xc5\xf3\xdb\x8f\xb2\x97\x60\xcd\x0e\x03\x66\x20\x87\x48\x24\xbf\x3f\xea\x39\xb1\x6d\x75\xc3\x04\xaf\x1c\x12\xc2\x5e\xb3\xae\x35\x21\x7c\xb1\x5d\xd2\x6c\x83\x27\xf5\xe9\xdf\x9f\x52\xcf\xc6\x80\xf6\x13\x0c\x97\x3e\xa0\x26\xd9\x28\x83\xd9\xb2\x43\x03\x8f\x84\xef\x47\x1f\x9e\x8e\x8f\xd4\x87\xc4\xc7\x01\x89\x74\x87\x4f\x15\xbd\x3b\xe0\x00\xdc\x5b\x82\x36\x38\x83\xa9\x82\xd7\xdf\xbd\xa3\x75\x0e\x6a\x54\xf8\x13\x9b\xcc\x32\xc5\xfa\xeb\x20\x08\x69\xae\xfd\xee\xb0\x10\x26\x44\xe8\x99\x23\xed\xb6\xdb\xce\x72\x9f\xeb\xaa\x09\xc3\x36\xe5\x1a\xfd\x79\x2b\x20\x9b\x85\x1a\xde\x8d\xc9\xb2\xf5\xdd\xe6\x4a\xb5\xe0\x26\xd5\x65\x6b\xd0\x59\x8f\x04\xc2\xbe\x0f\x63\x61\x55\x6a\x51\xb8\xe1\xdf\xc2\x2a\xa5\xbe\x7e\x3b\x7c\xde\x5d\x08\xa4\xec\xe1\xd4\x05\xe8\xd0\x63\xc1\x81\xcd\x19\xaa\x6d\x55\x55\x27\xd0\x2d\x63\x82\x41\xbf\x09\xec\xec\x52\xd3\x6b\xdf\x09\xd0\xad\xee\x1f\x08\x5a\x52\xc0\xa1\x46\x63\x4a\xb1\x20\xab\x5b\x64\xd0\x04\x1a\x04\xa9\xb5\x0e\xe1\x7e\x1e\xc0\x49\x7b\x72\x4b\x95\xfc\x8d\x98\x44\xd2\x20\x86\xd5\xd3\x07\x52\xa7\x07\x01\x11\x77\x42\xe6\x42\xd8\x17\x0c\xb9\x57\xd7\xfb\xf5\x8d\xd8\x19\x63\x8e\x61\x25\x6a\x57\x12\x86\x64\xd0\xb8\x6c\xb0\xe4\x2d\xbf\x18\x9d\x45\x23\x66\x1c\x90\x8a\xad\x60\x2e\x2b\xf4\x87\xbf\xf7\xb5\x6e\xde\x46\x9d\x0c\x81\x84\x12\xe5\xb4\x76\x96\xa7\x87\x9d\xda\xfc\xcf\xa7\xf6\x39\x15\xa8\x46\xa0\x3d\x0b\x21\xc6\x0b\x5f\x16\x16\xb4\x86\x51\x4c\x5c\x76\x66\xd6\x43\x7a\xf8\xc0\x87\x7b\xcd\xa5\x66\x0c\xd9\x99\x12\x77\x4c\xc1\x54\xf0\x32\x81\xc1\x56\x67\xc7\x6b\x25\x6d\xa2\x61\x1e\xb7\xe8\x2f\xf9\xea\x4e\x5b\x4f\x3c\xcf\x8c\x09\xcf\x36\x64\x72\xa5\x29\xba\x30\x89\x23\x67\x4c\x54\x58\x2c\xa1\xac\x64\xd3\x65\xfe\x4a\x92\xe7\xa6\x9f\x9b\x2e\x05\xc1\xab\x02\x29\x69\x1c\x42\x39\xb4\xed\xb4\x90\x5a\x00\x9b\xe0\xc5\xcb\x8c\x65\x41\x66\xdc\x65\x81\xae\x02\xd6\x50\xe7\x21\x42\x8e\x37\x69\x39\x3c\xa3\x03\x2a\x4f\xb9\xbc\x15\xb5\x18\x74\x2c\x43\xab\x2e\xdc\x11\x72\x8e\xfb\x97\x4a\xfd\x0a\x00\x00\xff\xff\x23\xa1\xeb\xb5\xf7\x03\x00\x00")
 
 func migrationsReadmeBytes() ([]byte, error) {
@@ -224,10 +476,22 @@ func AssetNames() []string {
 
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
+	"migrations/10_bucket_listing.sql": migrations10_bucket_listingSql,
+	"migrations/11_artifact_listing.sql": migrations11_artifact_listingSql,
+	"migrations/12_api_tokens.sql": migrations12_api_tokensSql,
+	"migrations/13_artifact_events.sql": migrations13_artifact_eventsSql,
+	"migrations/14_versions.sql": migrations14_versionsSql,
+	"migrations/15_artifact_notify.sql": migrations15_artifact_notifySql,
+	"migrations/16_artifact_notify_version.sql": migrations16_artifact_notify_versionSql,
 	"migrations/1_initial.sql": migrations1_initialSql,
 	"migrations/2_index_artifactid_size.sql": migrations2_index_artifactid_sizeSql,
 	"migrations/3_add_relative_path.sql": migrations3_add_relative_pathSql,
 	"migrations/4_byte_array.sql": migrations4_byte_arraySql,
+	"migrations/5_index_artifact_state.sql": migrations5_index_artifact_stateSql,
+	"migrations/6_bucket_deadline.sql": migrations6_bucket_deadlineSql,
+	"migrations/7_artifact_dateupdated.sql": migrations7_artifact_dateupdatedSql,
+	"migrations/8_artifact_sha256.sql": migrations8_artifact_sha256Sql,
+	"migrations/9_bucket_owner_datecreated.sql": migrations9_bucket_owner_datecreatedSql,
 	"migrations/README": migrationsReadme,
 }
 
@@ -272,6 +536,20 @@ type bintree struct {
 }
 var _bintree = &bintree{nil, map[string]*bintree{
 	"migrations": &bintree{nil, map[string]*bintree{
+		"10_bucket_listing.sql": &bintree{migrations10_bucket_listingSql, map[string]*bintree{
+		}},
+		"11_artifact_listing.sql": &bintree{migrations11_artifact_listingSql, map[string]*bintree{
+		}},
+		"12_api_tokens.sql": &bintree{migrations12_api_tokensSql, map[string]*bintree{
+		}},
+		"13_artifact_events.sql": &bintree{migrations13_artifact_eventsSql, map[string]*bintree{
+		}},
+		"14_versions.sql": &bintree{migrations14_versionsSql, map[string]*bintree{
+		}},
+		"15_artifact_notify.sql": &bintree{migrations15_artifact_notifySql, map[string]*bintree{
+		}},
+		"16_artifact_notify_version.sql": &bintree{migrations16_artifact_notify_versionSql, map[string]*bintree{
+		}},
 		"1_initial.sql": &bintree{migrations1_initialSql, map[string]*bintree{
 		}},
 		"2_index_artifactid_size.sql": &bintree{migrations2_index_artifactid_sizeSql, map[string]*bintree{
@@ -280,6 +558,16 @@ var _bintree = &bintree{nil, map[string]*bintree{
 		}},
 		"4_byte_array.sql": &bintree{migrations4_byte_arraySql, map[string]*bintree{
 		}},
+		"5_index_artifact_state.sql": &bintree{migrations5_index_artifact_stateSql, map[string]*bintree{
+		}},
+		"6_bucket_deadline.sql": &bintree{migrations6_bucket_deadlineSql, map[string]*bintree{
+		}},
+		"7_artifact_dateupdated.sql": &bintree{migrations7_artifact_dateupdatedSql, map[string]*bintree{
+		}},
+		"8_artifact_sha256.sql": &bintree{migrations8_artifact_sha256Sql, map[string]*bintree{
+		}},
+		"9_bucket_owner_datecreated.sql": &bintree{migrations9_bucket_owner_datecreatedSql, map[string]*bintree{
+		}},
 		"README": &bintree{migrationsReadme, map[string]*bintree{
 		}},
 	}},
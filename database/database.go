@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/dropbox/changes-artifacts/model"
 	_ "github.com/vektra/mockery" // Required to generate MockDatabase
@@ -18,6 +19,9 @@ const (
 
 	// Entity not found in the database
 	ENTITY_NOT_FOUND
+
+	// Entity was modified concurrently, since it was read
+	CONFLICT
 )
 
 type DatabaseError struct {
@@ -71,15 +75,98 @@ func NewEntityNotFoundError(format string, args ...interface{}) *DatabaseError {
 	return &DatabaseError{errStr: format, errType: ENTITY_NOT_FOUND}
 }
 
+func NewConflictError(format string, args ...interface{}) *DatabaseError {
+	if format == "" {
+		panic("Error formatting NewConflictError")
+	}
+	if len(args) > 0 {
+		return &DatabaseError{errStr: fmt.Sprintf(format, args...), errType: CONFLICT}
+	}
+	return &DatabaseError{errStr: format, errType: CONFLICT}
+}
+
 func (dbe *DatabaseError) EntityNotFound() bool {
 	return dbe != nil && dbe.errType == ENTITY_NOT_FOUND
 }
 
+func (dbe *DatabaseError) Conflict() bool {
+	return dbe != nil && dbe.errType == CONFLICT
+}
+
+// BucketCursor identifies a position in a bucket listing, which is ordered by creation time and
+// then id.
+type BucketCursor struct {
+	DateCreated time.Time
+	Id          string
+}
+
+// BucketFilter selects the buckets returned by ListBuckets. Fields left at their zero value don't
+// restrict the listing.
+type BucketFilter struct {
+	Owner string
+	State model.BucketState
+	// Only list buckets created at or after CreatedAfter, and before CreatedBefore.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// List newest buckets first, instead of oldest first.
+	Descending bool
+	// Only list buckets which come after Cursor in the listing.
+	Cursor *BucketCursor
+	// Maximum number of buckets to list.
+	Limit int
+}
+
+// ArtifactSortKey is the artifact field by which an artifact listing is ordered.
+type ArtifactSortKey string
+
+const (
+	// Order of creation.
+	SortArtifactsByID          ArtifactSortKey = "id"
+	SortArtifactsByName        ArtifactSortKey = "name"
+	SortArtifactsBySize        ArtifactSortKey = "size"
+	SortArtifactsByDateCreated ArtifactSortKey = "datecreated"
+)
+
+// ArtifactCursor identifies a position in an artifact listing, which is ordered by the sort key and
+// then id. Only the field corresponding to the sort key of the listing (and Id) is used.
+type ArtifactCursor struct {
+	Name        string
+	Size        int64
+	DateCreated time.Time
+	Id          int64
+}
+
+// ArtifactFilter selects the artifacts of a bucket returned by ListArtifacts. Fields left at their
+// zero value don't restrict the listing.
+type ArtifactFilter struct {
+	BucketId string
+	State    model.ArtifactState
+	// Only list artifacts whose name (or relative path) starts with given prefix.
+	NamePrefix         string
+	RelativePathPrefix string
+	// Only list artifacts whose name (or relative path) matches given glob pattern, where '*'
+	// matches any sequence of characters and '?' matches any single character.
+	NameGlob         string
+	RelativePathGlob string
+	// Field to order the listing by, SortArtifactsByID if empty.
+	SortBy     ArtifactSortKey
+	Descending bool
+	// Only list artifacts which come after Cursor in the listing.
+	Cursor *ArtifactCursor
+	// Maximum number of artifacts to list, unlimited if zero.
+	Limit int
+}
+
 //go:generate mockery -name=Database -inpkg
 type Database interface {
 	// Register all DB table<->object mappings in memory
 	RegisterEntities()
 
+	// Run f in a transaction. All operations on tx are committed if f returns nil, and rolled back
+	// otherwise, in which case the error returned by f is returned as is. Calling WithTx on tx runs
+	// in the same transaction.
+	WithTx(f func(tx Database) error) error
+
 	// Bucket instance is expected to have id, datecreated, state and owner field set.
 	InsertBucket(*model.Bucket) *DatabaseError
 
@@ -87,16 +174,26 @@ type Database interface {
 
 	InsertLogChunk(*model.LogChunk) *DatabaseError
 
-	// Bucket instance is expected to have id, datecreated, state and owner field set.
+	// Insert log chunks with a single statement. Ids of the inserted log chunks are not set.
+	InsertLogChunks(logChunks []model.LogChunk) *DatabaseError
+
+	// Bucket instance is expected to have id, datecreated, state and owner field set. The update
+	// only succeeds if the bucket was not modified since it was read (see model.Bucket.Version),
+	// and returns CONFLICT otherwise.
 	UpdateBucket(*model.Bucket) *DatabaseError
 
-	// TODO: Pagination and/or other forms of filtering
-	ListBuckets() ([]model.Bucket, *DatabaseError)
+	// List buckets matching filter, in the order requested by filter.
+	ListBuckets(filter BucketFilter) ([]model.Bucket, *DatabaseError)
 
 	GetBucket(string) (*model.Bucket, *DatabaseError)
 
 	ListArtifactsInBucket(string) ([]model.Artifact, *DatabaseError)
 
+	// List artifacts of a bucket matching filter, in the order requested by filter.
+	ListArtifacts(filter ArtifactFilter) ([]model.Artifact, *DatabaseError)
+
+	// The update only succeeds if the artifact was not modified since it was read (see
+	// model.Artifact.Version), and returns CONFLICT otherwise.
 	UpdateArtifact(*model.Artifact) *DatabaseError
 
 	ListLogChunksInArtifact(artifactID int64, offset int64, limit int64) ([]model.LogChunk, *DatabaseError)
@@ -104,8 +201,55 @@ type Database interface {
 	// Delete list of log chunks, primarily used to clean up log chunks after merging and uploading
 	DeleteLogChunksForArtifact(int64) (int64, *DatabaseError)
 
+	// Returns ENTITY_NOT_FOUND if the bucket has no artifact with given name.
 	GetArtifactByName(bucket string, name string) (*model.Artifact, *DatabaseError)
 
 	// Get last logchunk seen for an artifact.
 	GetLastLogChunkSeenForArtifact(int64) (*model.LogChunk, *DatabaseError)
+
+	// List artifacts which are still open for writes (APPENDING or WAITING_FOR_UPLOAD) but were
+	// created more than DeadlineMins minutes before the given time.
+	ListArtifactsPastDeadline(now time.Time) ([]model.Artifact, *DatabaseError)
+
+	// List all open buckets which were not closed before their deadline.
+	ListBucketsPastDeadline(now time.Time) ([]model.Bucket, *DatabaseError)
+
+	// List up to limit artifacts in given state, oldest first.
+	ListArtifactsInState(state model.ArtifactState, limit int) ([]model.Artifact, *DatabaseError)
+
+	// Atomically move artifact from expectedState to newState, returning the new artifact version.
+	// If the artifact is not in expectedState (for example, because someone else changed it first),
+	// a CONFLICT error is returned and the artifact is left untouched. ENTITY_NOT_FOUND is returned
+	// if the artifact does not exist. If newState is expectedState, only the update time (and
+	// version) of the artifact changes.
+	CompareAndSwapArtifactState(artifactID int64, expectedState model.ArtifactState, newState model.ArtifactState) (int64, *DatabaseError)
+
+	// List artifacts in given state which have not been updated since updatedBefore.
+	ListStaleArtifacts(state model.ArtifactState, updatedBefore time.Time) ([]model.Artifact, *DatabaseError)
+
+	// Delete an artifact. Log chunks are not deleted, use DeleteLogChunksForArtifact for that.
+	// Returns ENTITY_NOT_FOUND if the artifact does not exist.
+	DeleteArtifact(artifactID int64) *DatabaseError
+
+	// Delete a bucket. Artifacts in the bucket are not deleted, use DeleteArtifact for that.
+	// Returns ENTITY_NOT_FOUND if the bucket does not exist.
+	DeleteBucket(bucketID string) *DatabaseError
+
+	// List up to limit buckets of given owner which are no longer open and were created before
+	// createdBefore, oldest first (by creation time, then id). If after is set, only buckets which
+	// come after it in that order are listed.
+	ListExpiredBuckets(owner string, createdBefore time.Time, after *BucketCursor, limit int) ([]model.Bucket, *DatabaseError)
+
+	// Same as ListExpiredBuckets, but lists buckets of all owners except excludedOwners.
+	ListExpiredBucketsOfOtherOwners(excludedOwners []string, createdBefore time.Time, after *BucketCursor, limit int) ([]model.Bucket, *DatabaseError)
+
+	// Insert an API token along with its owners.
+	InsertAPIToken(token *model.APIToken) *DatabaseError
+
+	// Get the API token with given hash, with its owners. Returns ENTITY_NOT_FOUND if there is no
+	// such token.
+	GetAPITokenByHash(tokenHash string) (*model.APIToken, *DatabaseError)
+
+	// List the recorded state transitions of an artifact, oldest first.
+	ListArtifactEvents(artifactID int64) ([]model.ArtifactEvent, *DatabaseError)
 }
@@ -4,9 +4,9 @@ package database
 
 import "fmt"
 
-const _DBErrorType_name = "INTERNALVALIDATION_FAILUREENTITY_NOT_FOUND"
+const _DBErrorType_name = "INTERNALVALIDATION_FAILUREENTITY_NOT_FOUNDCONFLICT"
 
-var _DBErrorType_index = [...]uint8{0, 8, 26, 42}
+var _DBErrorType_index = [...]uint8{0, 8, 26, 42, 50}
 
 func (i DBErrorType) String() string {
 	if i < 0 || i >= DBErrorType(len(_DBErrorType_index)-1) {
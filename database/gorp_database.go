@@ -1,6 +1,9 @@
 package database
 
 import (
+	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dropbox/changes-artifacts/common/stats"
@@ -10,10 +13,53 @@ import (
 
 type GorpDatabase struct {
 	dbmap *gorp.DbMap
+	// Runs all queries. This is the transaction for databases passed to WithTx callbacks, and dbmap
+	// otherwise.
+	exec gorp.SqlExecutor
 }
 
 func NewGorpDatabase(dbmap *gorp.DbMap) *GorpDatabase {
-	return &GorpDatabase{dbmap: dbmap}
+	return &GorpDatabase{dbmap: dbmap, exec: dbmap}
+}
+
+var txTimer = stats.NewTimingStat("transaction")
+
+// WithTx runs f in a transaction. See Database.WithTx.
+func (db *GorpDatabase) WithTx(f func(tx Database) error) error {
+	return db.inTx(func(tx *GorpDatabase) error {
+		return f(tx)
+	})
+}
+
+func (db *GorpDatabase) inTx(f func(tx *GorpDatabase) error) error {
+	if _, ok := db.exec.(*gorp.Transaction); ok {
+		// Already in a transaction, which f becomes part of.
+		return f(db)
+	}
+
+	defer txTimer.AddTimeSince(time.Now())
+	tx, err := db.dbmap.Begin()
+	if err != nil {
+		return WrapInternalDatabaseError(err)
+	}
+
+	committed := false
+	defer func() {
+		// Also rolls back if f panics.
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if err := f(&GorpDatabase{dbmap: db.dbmap, exec: tx}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return WrapInternalDatabaseError(err)
+	}
+	committed = true
+	return nil
 }
 
 func verifyBucketFields(bucket *model.Bucket) *DatabaseError {
@@ -34,15 +80,23 @@ func verifyBucketFields(bucket *model.Bucket) *DatabaseError {
 
 func (db *GorpDatabase) RegisterEntities() {
 	// Add bucket non-autoincrementing ID field.
-	db.dbmap.AddTableWithName(model.Bucket{}, "bucket").SetKeys(false, "Id")
+	db.dbmap.AddTableWithName(model.Bucket{}, "bucket").
+		SetKeys(false, "Id").
+		SetVersionCol("Version")
 
 	// Add artifact autoincrementing ID field.
 	db.dbmap.AddTableWithName(model.Artifact{}, "artifact").
 		SetKeys(true, "Id").
-		SetUniqueTogether("BucketID", "Name")
+		SetUniqueTogether("BucketID", "Name").
+		SetVersionCol("Version")
 
 	// Add logchunk autoincrementing ID field.
 	db.dbmap.AddTableWithName(model.LogChunk{}, "logchunk").SetKeys(true, "Id")
+
+	// Add apitoken autoincrementing ID field.
+	db.dbmap.AddTableWithName(model.APIToken{}, "apitoken").SetKeys(true, "Id")
+
+	db.dbmap.AddTableWithName(model.APITokenOwner{}, "apitokenowner").SetKeys(false, "TokenId", "Owner")
 }
 
 var insertBucketTimer = stats.NewTimingStat("insert_bucket")
@@ -54,21 +108,43 @@ func (db *GorpDatabase) InsertBucket(bucket *model.Bucket) *DatabaseError {
 		return err
 	}
 
-	return WrapInternalDatabaseError(db.dbmap.Insert(bucket))
+	return WrapInternalDatabaseError(db.exec.Insert(bucket))
 }
 
 var insertArtifactTimer = stats.NewTimingStat("insert_artifact")
 
 func (db *GorpDatabase) InsertArtifact(artifact *model.Artifact) *DatabaseError {
 	defer insertArtifactTimer.AddTimeSince(time.Now())
-	return WrapInternalDatabaseError(db.dbmap.Insert(artifact))
+	artifact.DateUpdated = time.Now()
+	return WrapInternalDatabaseError(db.exec.Insert(artifact))
 }
 
 var insertLogChunkTimer = stats.NewTimingStat("insert_logchunk")
 
 func (db *GorpDatabase) InsertLogChunk(logChunk *model.LogChunk) *DatabaseError {
 	defer insertLogChunkTimer.AddTimeSince(time.Now())
-	return WrapInternalDatabaseError(db.dbmap.Insert(logChunk))
+	return WrapInternalDatabaseError(db.exec.Insert(logChunk))
+}
+
+var insertLogChunksTimer = stats.NewTimingStat("insert_logchunks")
+
+// InsertLogChunks inserts log chunks with a single multi-row INSERT, which is much cheaper than
+// inserting them one at a time when there are many small chunks.
+func (db *GorpDatabase) InsertLogChunks(logChunks []model.LogChunk) *DatabaseError {
+	defer insertLogChunksTimer.AddTimeSince(time.Now())
+	if len(logChunks) == 0 {
+		return nil
+	}
+
+	rows := make([]string, len(logChunks))
+	args := make([]interface{}, 0, 4*len(logChunks))
+	for i, logChunk := range logChunks {
+		rows[i] = fmt.Sprintf("($%d, $%d, $%d, $%d)", 4*i+1, 4*i+2, 4*i+3, 4*i+4)
+		args = append(args, logChunk.ArtifactId, logChunk.ByteOffset, logChunk.Size, logChunk.ContentBytes)
+	}
+
+	_, err := db.exec.Exec("INSERT INTO logchunk (artifactid, byteoffset, size, content_bytes) VALUES "+strings.Join(rows, ", "), args...)
+	return WrapInternalDatabaseError(err)
 }
 
 var updateBucketTimer = stats.NewTimingStat("update_bucket")
@@ -79,17 +155,73 @@ func (db *GorpDatabase) UpdateBucket(bucket *model.Bucket) *DatabaseError {
 		return err
 	}
 
-	_, err := db.dbmap.Update(bucket)
+	_, err := db.exec.Update(bucket)
+	return wrapUpdateError(err)
+}
+
+// wrapUpdateError converts errors returned by gorp while updating a versioned entity. Stale
+// versions are reported as CONFLICT, and updates of deleted entities as ENTITY_NOT_FOUND.
+func wrapUpdateError(err error) *DatabaseError {
+	if lockErr, ok := err.(gorp.OptimisticLockError); ok {
+		if !lockErr.RowExists {
+			return NewEntityNotFoundError("%s %v not found", lockErr.TableName, lockErr.Keys)
+		}
+		return NewConflictError("%s %v was modified concurrently (local version %d)", lockErr.TableName, lockErr.Keys, lockErr.LocalVersion)
+	}
 	return WrapInternalDatabaseError(err)
 }
 
-func (db *GorpDatabase) ListBuckets() ([]model.Bucket, *DatabaseError) {
+var listBucketsTimer = stats.NewTimingStat("list_buckets")
+
+// ListBuckets returns up to filter.Limit buckets matching filter, oldest first unless
+// filter.Descending is set. Pagination is
+// keyset based (on datecreated and id) so that listing deep into the 2.5M+ buckets is as cheap as
+// listing the first page.
+func (db *GorpDatabase) ListBuckets(filter BucketFilter) ([]model.Bucket, *DatabaseError) {
+	defer listBucketsTimer.AddTimeSince(time.Now())
+	if filter.Limit <= 0 {
+		return nil, NewValidationError("Bucket listing limit must be positive")
+	}
+
+	var conditions []string
+	params := map[string]interface{}{"limit": filter.Limit}
+
+	if filter.Owner != "" {
+		conditions = append(conditions, "owner = :owner")
+		params["owner"] = filter.Owner
+	}
+	if filter.State != model.UNKNOWN {
+		conditions = append(conditions, "state = :state")
+		params["state"] = filter.State
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "datecreated >= :createdafter")
+		params["createdafter"] = filter.CreatedAfter
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "datecreated < :createdbefore")
+		params["createdbefore"] = filter.CreatedBefore
+	}
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.Cursor != nil {
+		conditions = append(conditions, fmt.Sprintf("(datecreated, id) %s (:cursordatecreated, :cursorid)", comparison))
+		params["cursordatecreated"] = filter.Cursor.DateCreated
+		params["cursorid"] = filter.Cursor.Id
+	}
+
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
 	buckets := []model.Bucket{}
-	// NOTE: Hardcoded limit of 25 buckets below.
-	// Because of the large number of buckets (2.5M+ and increasing), its not feasible to list all
-	// buckets at /buckets. Instead, we show only the latest 25 buckets. This endpoint is not
-	// particularly useful and is not used by any client.
-	if _, err := db.dbmap.Select(&buckets, "SELECT * FROM bucket ORDER BY datecreated LIMIT 25"); err != nil {
+	if _, err := db.exec.Select(&buckets,
+		fmt.Sprintf("SELECT * FROM bucket %s ORDER BY datecreated %s, id %s LIMIT :limit", whereClause, direction, direction),
+		params); err != nil && !gorp.NonFatalError(err) {
 		return nil, WrapInternalDatabaseError(err)
 	}
 
@@ -100,7 +232,7 @@ var getBucketTimer = stats.NewTimingStat("get_bucket")
 
 func (db *GorpDatabase) GetBucket(id string) (*model.Bucket, *DatabaseError) {
 	defer getBucketTimer.AddTimeSince(time.Now())
-	if bucket, err := db.dbmap.Get(model.Bucket{}, id); err != nil && !gorp.NonFatalError(err) {
+	if bucket, err := db.exec.Get(model.Bucket{}, id); err != nil && !gorp.NonFatalError(err) {
 		return nil, WrapInternalDatabaseError(err)
 	} else if bucket == nil {
 		return nil, NewEntityNotFoundError("Entity %s not found", id)
@@ -114,7 +246,7 @@ var listArtifactsTimer = stats.NewTimingStat("list_artifacts")
 func (db *GorpDatabase) ListArtifactsInBucket(bucketId string) ([]model.Artifact, *DatabaseError) {
 	defer listArtifactsTimer.AddTimeSince(time.Now())
 	artifacts := []model.Artifact{}
-	if _, err := db.dbmap.Select(&artifacts, "SELECT * FROM artifact WHERE bucketid = :bucketid",
+	if _, err := db.exec.Select(&artifacts, "SELECT * FROM artifact WHERE bucketid = :bucketid",
 		map[string]interface{}{"bucketid": bucketId}); err != nil && !gorp.NonFatalError(err) {
 		return nil, WrapInternalDatabaseError(err)
 	}
@@ -122,10 +254,114 @@ func (db *GorpDatabase) ListArtifactsInBucket(bucketId string) ([]model.Artifact
 	return artifacts, nil
 }
 
+var listArtifactsFilteredTimer = stats.NewTimingStat("list_artifacts_filtered")
+
+// Columns by which artifact listings can be ordered, along with the cursor field for that column.
+var artifactSortColumns = map[ArtifactSortKey]func(*ArtifactCursor) interface{}{
+	SortArtifactsByID:          func(c *ArtifactCursor) interface{} { return c.Id },
+	SortArtifactsByName:        func(c *ArtifactCursor) interface{} { return c.Name },
+	SortArtifactsBySize:        func(c *ArtifactCursor) interface{} { return c.Size },
+	SortArtifactsByDateCreated: func(c *ArtifactCursor) interface{} { return c.DateCreated },
+}
+
+// ListArtifacts returns artifacts of a bucket matching filter. Pagination is keyset based (on the
+// sort column and id), so that listing deep into buckets with lots of artifacts is as cheap as
+// listing the first page.
+func (db *GorpDatabase) ListArtifacts(filter ArtifactFilter) ([]model.Artifact, *DatabaseError) {
+	defer listArtifactsFilteredTimer.AddTimeSince(time.Now())
+	if filter.BucketId == "" {
+		return nil, NewValidationError("Bucket id not set for artifact listing")
+	}
+	if filter.Limit < 0 {
+		return nil, NewValidationError("Artifact listing limit must not be negative")
+	}
+
+	sortBy := filter.SortBy
+	if sortBy == "" {
+		sortBy = SortArtifactsByID
+	}
+	cursorValue, ok := artifactSortColumns[sortBy]
+	if !ok {
+		return nil, NewValidationError("Invalid artifact sort key %q", sortBy)
+	}
+
+	conditions := []string{"bucketid = :bucketid"}
+	params := map[string]interface{}{"bucketid": filter.BucketId}
+
+	if filter.State != model.UNKNOWN_ARTIFACT_STATE {
+		conditions = append(conditions, "state = :state")
+		params["state"] = filter.State
+	}
+	for _, pattern := range []struct {
+		column, param, value string
+	}{
+		{"name", "nameprefix", prefixToLikePattern(filter.NamePrefix)},
+		{"name", "nameglob", globToLikePattern(filter.NameGlob)},
+		{"relativepath", "relativepathprefix", prefixToLikePattern(filter.RelativePathPrefix)},
+		{"relativepath", "relativepathglob", globToLikePattern(filter.RelativePathGlob)},
+	} {
+		if pattern.value != "" {
+			conditions = append(conditions, fmt.Sprintf("%s LIKE :%s", pattern.column, pattern.param))
+			params[pattern.param] = pattern.value
+		}
+	}
+
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.Cursor != nil {
+		if sortBy == SortArtifactsByID {
+			conditions = append(conditions, fmt.Sprintf("id %s :cursorid", comparison))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s (:cursorvalue, :cursorid)", sortBy, comparison))
+			params["cursorvalue"] = cursorValue(filter.Cursor)
+		}
+		params["cursorid"] = filter.Cursor.Id
+	}
+
+	query := fmt.Sprintf("SELECT * FROM artifact WHERE %s ORDER BY ", strings.Join(conditions, " AND "))
+	if sortBy == SortArtifactsByID {
+		query += fmt.Sprintf("id %s", direction)
+	} else {
+		query += fmt.Sprintf("%s %s, id %s", sortBy, direction, direction)
+	}
+	if filter.Limit > 0 {
+		query += " LIMIT :limit"
+		params["limit"] = filter.Limit
+	}
+
+	artifacts := []model.Artifact{}
+	if _, err := db.exec.Select(&artifacts, query, params); err != nil && !gorp.NonFatalError(err) {
+		return nil, WrapInternalDatabaseError(err)
+	}
+
+	return artifacts, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// prefixToLikePattern converts a prefix into a LIKE pattern matching strings with that prefix.
+// Returns an empty string for an empty prefix.
+func prefixToLikePattern(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return likeEscaper.Replace(prefix) + "%"
+}
+
+// globToLikePattern converts a glob pattern, where '*' matches any sequence of characters and '?'
+// matches any single character, into the equivalent LIKE pattern.
+func globToLikePattern(glob string) string {
+	return strings.NewReplacer("*", "%", "?", "_").Replace(likeEscaper.Replace(glob))
+}
+
 func (db *GorpDatabase) UpdateArtifact(artifact *model.Artifact) *DatabaseError {
-	_, err := db.dbmap.Update(artifact)
+	artifact.DateUpdated = time.Now()
+	_, err := db.exec.Update(artifact)
 	if !gorp.NonFatalError(err) {
-		return WrapInternalDatabaseError(err)
+		return wrapUpdateError(err)
 	}
 
 	return nil
@@ -136,7 +372,7 @@ var listLogChunksTimer = stats.NewTimingStat("list_logchunks")
 func (db *GorpDatabase) ListLogChunksInArtifact(artifactID int64, byteBegin int64, byteEnd int64) ([]model.LogChunk, *DatabaseError) {
 	defer listLogChunksTimer.AddTimeSince(time.Now())
 	logChunks := []model.LogChunk{}
-	if _, err := db.dbmap.Select(&logChunks,
+	if _, err := db.exec.Select(&logChunks,
 		`SELECT * FROM logchunk
 		 WHERE artifactid = :artifactid AND byteoffset < :limit AND size + byteoffset >= :offset
 		 ORDER BY byteoffset ASC`,
@@ -153,7 +389,7 @@ var deleteLogChunksTimer = stats.NewTimingStat("delete_logchunks")
 // Returns (number of deleted rows, err)
 func (db *GorpDatabase) DeleteLogChunksForArtifact(artifactID int64) (int64, *DatabaseError) {
 	defer deleteLogChunksTimer.AddTimeSince(time.Now())
-	res, err := db.dbmap.Exec("DELETE FROM logchunk WHERE artifactid = $1", artifactID)
+	res, err := db.exec.Exec("DELETE FROM logchunk WHERE artifactid = $1", artifactID)
 	if err != nil && !gorp.NonFatalError(err) {
 		rows, _ := res.RowsAffected()
 		return rows, WrapInternalDatabaseError(err)
@@ -172,8 +408,10 @@ var getArtifactTimer = stats.NewTimingStat("get_artifact")
 func (db *GorpDatabase) GetArtifactByName(bucketId string, artifactName string) (*model.Artifact, *DatabaseError) {
 	defer getArtifactTimer.AddTimeSince(time.Now())
 	var artifact model.Artifact
-	if err := db.dbmap.SelectOne(&artifact, "SELECT * FROM artifact WHERE bucketid = :bucketid AND name = :artifactname",
-		map[string]string{"bucketid": bucketId, "artifactname": artifactName}); err != nil && !gorp.NonFatalError(err) {
+	if err := db.exec.SelectOne(&artifact, "SELECT * FROM artifact WHERE bucketid = :bucketid AND name = :artifactname",
+		map[string]string{"bucketid": bucketId, "artifactname": artifactName}); err == sql.ErrNoRows {
+		return nil, NewEntityNotFoundError("Artifact %s/%s not found", bucketId, artifactName)
+	} else if err != nil && !gorp.NonFatalError(err) {
 		return nil, WrapInternalDatabaseError(err)
 	}
 
@@ -187,12 +425,275 @@ var getLastLogChunkTimer = stats.NewTimingStat("get_last_logchunk")
 func (db *GorpDatabase) GetLastLogChunkSeenForArtifact(artifactID int64) (*model.LogChunk, *DatabaseError) {
 	defer getLastLogChunkTimer.AddTimeSince(time.Now())
 	var logChunk model.LogChunk
-	if err := db.dbmap.SelectOne(&logChunk, "SELECT * FROM logchunk WHERE artifactid = :artifactid ORDER BY byteoffset DESC LIMIT 1",
+	if err := db.exec.SelectOne(&logChunk, "SELECT * FROM logchunk WHERE artifactid = :artifactid ORDER BY byteoffset DESC LIMIT 1",
 		map[string]interface{}{"artifactid": artifactID}); err != nil && !gorp.NonFatalError(err) {
 		return nil, WrapInternalDatabaseError(err)
 	}
 	return &logChunk, nil
 }
 
+var listArtifactsPastDeadlineTimer = stats.NewTimingStat("list_artifacts_past_deadline")
+
+// ListArtifactsPastDeadline returns all artifacts which are still open for writes (APPENDING or
+// WAITING_FOR_UPLOAD), but whose deadline (DeadlineMins after creation) is before given time.
+func (db *GorpDatabase) ListArtifactsPastDeadline(now time.Time) ([]model.Artifact, *DatabaseError) {
+	defer listArtifactsPastDeadlineTimer.AddTimeSince(time.Now())
+	artifacts := []model.Artifact{}
+	if _, err := db.exec.Select(&artifacts,
+		`SELECT * FROM artifact
+		 WHERE state IN (:appending, :waitingforupload)
+		 AND datecreated + CAST(deadlinemins AS INTEGER) * INTERVAL '1 minute' < :now`,
+		map[string]interface{}{
+			"appending":        model.APPENDING,
+			"waitingforupload": model.WAITING_FOR_UPLOAD,
+			"now":              now,
+		}); err != nil && !gorp.NonFatalError(err) {
+		return nil, WrapInternalDatabaseError(err)
+	}
+
+	return artifacts, nil
+}
+
+var listBucketsPastDeadlineTimer = stats.NewTimingStat("list_buckets_past_deadline")
+
+// ListBucketsPastDeadline returns all open buckets with a deadline (DeadlineMins after creation)
+// before given time. Buckets without a deadline (DeadlineMins = 0) are never returned.
+func (db *GorpDatabase) ListBucketsPastDeadline(now time.Time) ([]model.Bucket, *DatabaseError) {
+	defer listBucketsPastDeadlineTimer.AddTimeSince(time.Now())
+	buckets := []model.Bucket{}
+	if _, err := db.exec.Select(&buckets,
+		`SELECT * FROM bucket
+		 WHERE state = :open
+		 AND deadlinemins > 0
+		 AND datecreated + deadlinemins * INTERVAL '1 minute' < :now`,
+		map[string]interface{}{
+			"open": model.OPEN,
+			"now":  now,
+		}); err != nil && !gorp.NonFatalError(err) {
+		return nil, WrapInternalDatabaseError(err)
+	}
+
+	return buckets, nil
+}
+
+var listArtifactsInStateTimer = stats.NewTimingStat("list_artifacts_in_state")
+
+// ListArtifactsInState returns up to limit artifacts in given state, in order of creation.
+func (db *GorpDatabase) ListArtifactsInState(state model.ArtifactState, limit int) ([]model.Artifact, *DatabaseError) {
+	defer listArtifactsInStateTimer.AddTimeSince(time.Now())
+	artifacts := []model.Artifact{}
+	if _, err := db.exec.Select(&artifacts,
+		"SELECT * FROM artifact WHERE state = :state ORDER BY datecreated ASC LIMIT :limit",
+		map[string]interface{}{"state": state, "limit": limit}); err != nil && !gorp.NonFatalError(err) {
+		return nil, WrapInternalDatabaseError(err)
+	}
+
+	return artifacts, nil
+}
+
+var casArtifactStateTimer = stats.NewTimingStat("cas_artifact_state")
+
+// CompareAndSwapArtifactState updates the state of an artifact only if it is currently in
+// expectedState. This is used to claim an artifact for processing when multiple workers (possibly
+// on different servers) are competing for it. The artifact version is incremented, so that
+// concurrent updates of the artifact fail with CONFLICT.
+func (db *GorpDatabase) CompareAndSwapArtifactState(artifactID int64, expectedState model.ArtifactState, newState model.ArtifactState) (int64, *DatabaseError) {
+	defer casArtifactStateTimer.AddTimeSince(time.Now())
+	version, err := db.exec.SelectNullInt(
+		"UPDATE artifact SET state = $1, dateupdated = $2, version = version + 1 WHERE id = $3 AND state = $4 RETURNING version",
+		newState, time.Now(), artifactID, expectedState)
+	if err != nil && !gorp.NonFatalError(err) {
+		return 0, WrapInternalDatabaseError(err)
+	}
+
+	if !version.Valid {
+		// Either the artifact is gone, or someone else changed its state first.
+		count, err := db.exec.SelectInt("SELECT COUNT(*) FROM artifact WHERE id = $1", artifactID)
+		if err != nil {
+			return 0, WrapInternalDatabaseError(err)
+		}
+		if count == 0 {
+			return 0, NewEntityNotFoundError("Artifact %d not found", artifactID)
+		}
+		return 0, NewConflictError("Artifact %d is not in state %s", artifactID, expectedState)
+	}
+
+	return version.Int64, nil
+}
+
+var listStaleArtifactsTimer = stats.NewTimingStat("list_stale_artifacts")
+
+// ListStaleArtifacts returns all artifacts in given state which were last updated before given time.
+func (db *GorpDatabase) ListStaleArtifacts(state model.ArtifactState, updatedBefore time.Time) ([]model.Artifact, *DatabaseError) {
+	defer listStaleArtifactsTimer.AddTimeSince(time.Now())
+	artifacts := []model.Artifact{}
+	if _, err := db.exec.Select(&artifacts,
+		"SELECT * FROM artifact WHERE state = :state AND dateupdated < :updatedbefore",
+		map[string]interface{}{"state": state, "updatedbefore": updatedBefore}); err != nil && !gorp.NonFatalError(err) {
+		return nil, WrapInternalDatabaseError(err)
+	}
+
+	return artifacts, nil
+}
+
+var deleteArtifactTimer = stats.NewTimingStat("delete_artifact")
+
+func (db *GorpDatabase) DeleteArtifact(artifactID int64) *DatabaseError {
+	defer deleteArtifactTimer.AddTimeSince(time.Now())
+	return db.deleteOne("DELETE FROM artifact WHERE id = $1", artifactID, "Artifact")
+}
+
+var deleteBucketTimer = stats.NewTimingStat("delete_bucket")
+
+func (db *GorpDatabase) DeleteBucket(bucketID string) *DatabaseError {
+	defer deleteBucketTimer.AddTimeSince(time.Now())
+	return db.deleteOne("DELETE FROM bucket WHERE id = $1", bucketID, "Bucket")
+}
+
+// deleteOne runs a DELETE query for the row with given id, and returns ENTITY_NOT_FOUND if there
+// was no such row.
+func (db *GorpDatabase) deleteOne(query string, id interface{}, entity string) *DatabaseError {
+	res, err := db.exec.Exec(query, id)
+	if err != nil && !gorp.NonFatalError(err) {
+		return WrapInternalDatabaseError(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil && !gorp.NonFatalError(err) {
+		return WrapInternalDatabaseError(err)
+	}
+
+	if rows == 0 {
+		return NewEntityNotFoundError("%s %v not found", entity, id)
+	}
+
+	return nil
+}
+
+var listExpiredBucketsTimer = stats.NewTimingStat("list_expired_buckets")
+
+// ListExpiredBuckets returns up to limit closed (or timed out) buckets of given owner which were
+// created before createdBefore, in order of creation, starting after given cursor if any.
+func (db *GorpDatabase) ListExpiredBuckets(owner string, createdBefore time.Time, after *BucketCursor, limit int) ([]model.Bucket, *DatabaseError) {
+	return db.listExpiredBuckets("owner = :owner", map[string]interface{}{"owner": owner}, createdBefore, after, limit)
+}
+
+// ListExpiredBucketsOfOtherOwners returns up to limit closed (or timed out) buckets, not owned by
+// any of excludedOwners, which were created before createdBefore, in order of creation, starting
+// after given cursor if any.
+func (db *GorpDatabase) ListExpiredBucketsOfOtherOwners(excludedOwners []string, createdBefore time.Time, after *BucketCursor, limit int) ([]model.Bucket, *DatabaseError) {
+	if len(excludedOwners) == 0 {
+		return db.listExpiredBuckets("", map[string]interface{}{}, createdBefore, after, limit)
+	}
+
+	params := map[string]interface{}{}
+	placeholders := make([]string, len(excludedOwners))
+	for i, owner := range excludedOwners {
+		name := fmt.Sprintf("owner%d", i)
+		placeholders[i] = ":" + name
+		params[name] = owner
+	}
+	return db.listExpiredBuckets(fmt.Sprintf("owner NOT IN (%s)", strings.Join(placeholders, ", ")), params, createdBefore, after, limit)
+}
+
+// listExpiredBuckets lists expired buckets matching ownerCondition (if not empty), whose parameters
+// are in params.
+func (db *GorpDatabase) listExpiredBuckets(ownerCondition string, params map[string]interface{}, createdBefore time.Time, after *BucketCursor, limit int) ([]model.Bucket, *DatabaseError) {
+	defer listExpiredBucketsTimer.AddTimeSince(time.Now())
+	conditions := []string{"state <> :open", "datecreated < :createdbefore"}
+	params["open"] = model.OPEN
+	params["createdbefore"] = createdBefore
+	params["limit"] = limit
+
+	if ownerCondition != "" {
+		conditions = append(conditions, ownerCondition)
+	}
+	if after != nil {
+		conditions = append(conditions, "(datecreated, id) > (:cursordatecreated, :cursorid)")
+		params["cursordatecreated"] = after.DateCreated
+		params["cursorid"] = after.Id
+	}
+
+	buckets := []model.Bucket{}
+	if _, err := db.exec.Select(&buckets,
+		fmt.Sprintf("SELECT * FROM bucket WHERE %s ORDER BY datecreated ASC, id ASC LIMIT :limit", strings.Join(conditions, " AND ")),
+		params); err != nil && !gorp.NonFatalError(err) {
+		return nil, WrapInternalDatabaseError(err)
+	}
+
+	return buckets, nil
+}
+
 // Ensure GorpDatabase implements Database
 var _ Database = new(GorpDatabase)
+
+var insertAPITokenTimer = stats.NewTimingStat("insert_api_token")
+
+// InsertAPIToken inserts an API token along with the owners granted to it.
+func (db *GorpDatabase) InsertAPIToken(token *model.APIToken) *DatabaseError {
+	defer insertAPITokenTimer.AddTimeSince(time.Now())
+	if token.TokenHash == "" {
+		return NewValidationError("APIToken.TokenHash not set")
+	}
+
+	err := db.inTx(func(tx *GorpDatabase) error {
+		if err := tx.exec.Insert(token); err != nil {
+			return WrapInternalDatabaseError(err)
+		}
+
+		for _, owner := range token.Owners {
+			if err := tx.exec.Insert(&model.APITokenOwner{TokenId: token.Id, Owner: owner}); err != nil {
+				return WrapInternalDatabaseError(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err.(*DatabaseError)
+	}
+	return nil
+}
+
+var getAPITokenTimer = stats.NewTimingStat("get_api_token")
+
+// GetAPITokenByHash returns the API token with given hash, along with the owners granted to it.
+func (db *GorpDatabase) GetAPITokenByHash(tokenHash string) (*model.APIToken, *DatabaseError) {
+	defer getAPITokenTimer.AddTimeSince(time.Now())
+	tokens := []model.APIToken{}
+	if _, err := db.exec.Select(&tokens, "SELECT * FROM apitoken WHERE tokenhash = :tokenhash",
+		map[string]interface{}{"tokenhash": tokenHash}); err != nil && !gorp.NonFatalError(err) {
+		return nil, WrapInternalDatabaseError(err)
+	}
+
+	if len(tokens) == 0 {
+		return nil, NewEntityNotFoundError("API token not found")
+	}
+
+	token := &tokens[0]
+	owners := []model.APITokenOwner{}
+	if _, err := db.exec.Select(&owners, "SELECT * FROM apitokenowner WHERE tokenid = :tokenid",
+		map[string]interface{}{"tokenid": token.Id}); err != nil && !gorp.NonFatalError(err) {
+		return nil, WrapInternalDatabaseError(err)
+	}
+
+	token.Owners = make([]string, len(owners))
+	for i, owner := range owners {
+		token.Owners[i] = owner.Owner
+	}
+
+	return token, nil
+}
+
+var listArtifactEventsTimer = stats.NewTimingStat("list_artifact_events")
+
+// ListArtifactEvents returns the state transitions of an artifact, in the order they happened.
+func (db *GorpDatabase) ListArtifactEvents(artifactID int64) ([]model.ArtifactEvent, *DatabaseError) {
+	defer listArtifactEventsTimer.AddTimeSince(time.Now())
+	events := []model.ArtifactEvent{}
+	if _, err := db.exec.Select(&events, "SELECT * FROM artifact_event WHERE artifactid = :artifactid ORDER BY id",
+		map[string]interface{}{"artifactid": artifactID}); err != nil && !gorp.NonFatalError(err) {
+		return nil, WrapInternalDatabaseError(err)
+	}
+
+	return events, nil
+}
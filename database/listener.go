@@ -0,0 +1,105 @@
+package database
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dropbox/changes-artifacts/common/stats"
+	"github.com/lib/pq"
+)
+
+// ArtifactChangedChannel is the Postgres notification channel on which the id of an artifact is
+// published whenever its state or size changes (as "<id>:<version>"), or it is deleted (see
+// migrations/15_artifact_notify.sql and migrations/16_artifact_notify_version.sql).
+const ArtifactChangedChannel = "artifact_changed"
+
+// Bounds of the delay between attempts to reconnect a lost listener connection.
+const minListenerReconnectInterval = 1 * time.Second
+const maxListenerReconnectInterval = 1 * time.Minute
+
+// Interval between checks that the listener connection is still alive, when no notifications arrive.
+const listenerPingInterval = 1 * time.Minute
+
+var artifactNotificationsCounter = stats.NewStat("artifact_notifications")
+var artifactNotificationsMissedCounter = stats.NewStat("artifact_notifications_missed")
+
+// ArtifactListener receives notifications of artifact changes made through any server sharing the
+// database, using a dedicated connection which LISTENs on ArtifactChangedChannel.
+type ArtifactListener struct {
+	listener *pq.Listener
+	changed  func(artifactID int64, version int64)
+	missed   func()
+}
+
+// NewArtifactListener starts listening for artifact changes. changed is called with the id of each
+// changed artifact and its new version, or 0 if the artifact was deleted. Notifications sent while
+// the connection is down are lost, so missed is called whenever it is reestablished, after which
+// any artifact may have changed.
+func NewArtifactListener(connstr string, changed func(artifactID int64, version int64), missed func()) (*ArtifactListener, error) {
+	listener := pq.NewListener(connstr, minListenerReconnectInterval, maxListenerReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Error on artifact listener connection: %s", err)
+		}
+	})
+	if err := listener.Listen(ArtifactChangedChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	l := &ArtifactListener{listener: listener, changed: changed, missed: missed}
+	go l.run()
+	return l, nil
+}
+
+// Close stops listening for artifact changes.
+func (l *ArtifactListener) Close() error {
+	return l.listener.Close()
+}
+
+func (l *ArtifactListener) run() {
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case n, ok := <-l.listener.Notify:
+			if !ok {
+				// Listener was closed.
+				return
+			}
+			l.handle(n)
+		case <-ping.C:
+			// Detects dead connections, which are then reconnected.
+			go l.listener.Ping()
+		}
+	}
+}
+
+// handle dispatches a notification. A nil notification is sent by the listener after reconnecting.
+func (l *ArtifactListener) handle(n *pq.Notification) {
+	if n == nil {
+		artifactNotificationsMissedCounter.Add(1)
+		l.missed()
+		return
+	}
+
+	var version int64
+	id := n.Extra
+	if i := strings.IndexByte(n.Extra, ':'); i >= 0 {
+		id = n.Extra[:i]
+		var err error
+		if version, err = strconv.ParseInt(n.Extra[i+1:], 10, 64); err != nil {
+			log.Printf("Invalid artifact change notification %q", n.Extra)
+			return
+		}
+	}
+	artifactID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		log.Printf("Invalid artifact change notification %q", n.Extra)
+		return
+	}
+	artifactNotificationsCounter.Add(1)
+	l.changed(artifactID, version)
+}
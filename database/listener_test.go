@@ -0,0 +1,35 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactListenerHandle(t *testing.T) {
+	var changed []int64
+	missed := 0
+	l := &ArtifactListener{
+		changed: func(artifactID int64, version int64) { changed = append(changed, artifactID, version) },
+		missed:  func() { missed++ },
+	}
+
+	l.handle(&pq.Notification{Channel: ArtifactChangedChannel, Extra: "42:7"})
+	require.Equal(t, []int64{42, 7}, changed)
+	require.Equal(t, 0, missed)
+
+	// Deleted artifacts have no version.
+	l.handle(&pq.Notification{Channel: ArtifactChangedChannel, Extra: "43"})
+	require.Equal(t, []int64{42, 7, 43, 0}, changed)
+
+	// Malformed notifications are ignored.
+	l.handle(&pq.Notification{Channel: ArtifactChangedChannel, Extra: "foo"})
+	l.handle(&pq.Notification{Channel: ArtifactChangedChannel, Extra: "42:foo"})
+	require.Equal(t, []int64{42, 7, 43, 0}, changed)
+
+	// Reconnected
+	l.handle(nil)
+	require.Equal(t, []int64{42, 7, 43, 0}, changed)
+	require.Equal(t, 1, missed)
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+
+	"github.com/dropbox/changes-artifacts/common/stats"
+	"github.com/dropbox/changes-artifacts/model"
+)
+
+// DefaultLogChunkCacheBytes is the default size of the log chunk cache => 64 MB
+const DefaultLogChunkCacheBytes = 64 * 1024 * 1024
+
+var logChunkCacheHitsCounter = stats.NewStat("logchunk_cache_hits")
+var logChunkCacheMissesCounter = stats.NewStat("logchunk_cache_misses")
+
+type logChunkKey struct {
+	artifactID int64
+	byteOffset int64
+}
+
+// LogChunkCache is a size-bounded LRU cache of log chunks, keyed by artifact id and byte offset.
+// Log chunks never change once inserted, so cached chunks never need to be invalidated.
+type LogChunkCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List // Of *model.LogChunk, most recently used first.
+	entries  map[logChunkKey]*list.Element
+	// Sorted byte offsets of the cached log chunks of each artifact, to find the log chunk holding
+	// any byte of an artifact.
+	offsets map[int64][]int64
+}
+
+// NewLogChunkCache creates a cache holding at most maxBytes of log chunk content. A cache of size
+// zero caches nothing.
+func NewLogChunkCache(maxBytes int64) *LogChunkCache {
+	return &LogChunkCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[logChunkKey]*list.Element),
+		offsets:  make(map[int64][]int64),
+	}
+}
+
+// Add caches given log chunks, evicting least recently used log chunks if needed.
+func (c *LogChunkCache) Add(logChunks ...model.LogChunk) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, logChunk := range logChunks {
+		logChunk := logChunk
+		key := logChunkKey{logChunk.ArtifactId, logChunk.ByteOffset}
+		if elem, ok := c.entries[key]; ok {
+			c.lru.MoveToFront(elem)
+			continue
+		}
+
+		if logChunk.Size > c.maxBytes {
+			continue
+		}
+		for c.bytes+logChunk.Size > c.maxBytes {
+			c.evictOldest()
+		}
+		c.entries[key] = c.lru.PushFront(&logChunk)
+		c.bytes += logChunk.Size
+
+		offsets := c.offsets[logChunk.ArtifactId]
+		i := sort.Search(len(offsets), func(i int) bool { return offsets[i] > logChunk.ByteOffset })
+		offsets = append(offsets, 0)
+		copy(offsets[i+1:], offsets[i:])
+		offsets[i] = logChunk.ByteOffset
+		c.offsets[logChunk.ArtifactId] = offsets
+	}
+}
+
+func (c *LogChunkCache) evictOldest() {
+	elem := c.lru.Back()
+	logChunk := c.lru.Remove(elem).(*model.LogChunk)
+	delete(c.entries, logChunkKey{logChunk.ArtifactId, logChunk.ByteOffset})
+	c.bytes -= logChunk.Size
+
+	offsets := c.offsets[logChunk.ArtifactId]
+	i := sort.Search(len(offsets), func(i int) bool { return offsets[i] >= logChunk.ByteOffset })
+	offsets = append(offsets[:i], offsets[i+1:]...)
+	if len(offsets) == 0 {
+		delete(c.offsets, logChunk.ArtifactId)
+	} else {
+		c.offsets[logChunk.ArtifactId] = offsets
+	}
+}
+
+// Get returns the cached log chunk of the artifact which holds the byte at byteOffset, or nil.
+func (c *LogChunkCache) Get(artifactID int64, byteOffset int64) *model.LogChunk {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Last log chunk starting at or before byteOffset.
+	offsets := c.offsets[artifactID]
+	i := sort.Search(len(offsets), func(i int) bool { return offsets[i] > byteOffset }) - 1
+	if i < 0 {
+		return nil
+	}
+
+	elem := c.entries[logChunkKey{artifactID, offsets[i]}]
+	logChunk := elem.Value.(*model.LogChunk)
+	if byteOffset >= logChunk.ByteOffset+logChunk.Size {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return logChunk
+}
+
+// ListLogChunksInArtifact behaves like Database.ListLogChunksInArtifact, but returns cached log
+// chunks if they cover the whole range, and caches log chunks read from db otherwise (through add,
+// which may defer caching them).
+func (c *LogChunkCache) ListLogChunksInArtifact(db Database, add func(...model.LogChunk), artifactID int64, byteBegin int64, byteEnd int64) ([]model.LogChunk, *DatabaseError) {
+	var logChunks []model.LogChunk
+	for offset := byteBegin; offset < byteEnd; {
+		logChunk := c.Get(artifactID, offset)
+		if logChunk == nil {
+			logChunks = nil
+			break
+		}
+		logChunks = append(logChunks, *logChunk)
+		offset = logChunk.ByteOffset + logChunk.Size
+	}
+
+	if logChunks != nil {
+		logChunkCacheHitsCounter.Add(1)
+		return logChunks, nil
+	}
+
+	logChunkCacheMissesCounter.Add(1)
+	logChunks, err := db.ListLogChunksInArtifact(artifactID, byteBegin, byteEnd)
+	if err != nil {
+		return nil, err
+	}
+	add(logChunks...)
+	return logChunks, nil
+}
+
+// LogChunkCachingDatabase is a Database which serves log chunks from a LogChunkCache when
+// possible, and caches log chunks inserted or read through it. Log chunks inserted or read in a
+// transaction are only cached once the transaction commits.
+//
+// Only the same (short) ranges of artifacts being followed are read over and over, so readers of
+// entire artifacts (such as merges) should use the underlying Database, so as not to evict
+// everything else.
+type LogChunkCachingDatabase struct {
+	Database
+	cache *LogChunkCache
+	add   func(...model.LogChunk)
+}
+
+// NewLogChunkCachingDatabase wraps db with cache.
+func NewLogChunkCachingDatabase(db Database, cache *LogChunkCache) *LogChunkCachingDatabase {
+	return &LogChunkCachingDatabase{Database: db, cache: cache, add: cache.Add}
+}
+
+func (db *LogChunkCachingDatabase) WithTx(f func(tx Database) error) error {
+	var added []model.LogChunk
+	err := db.Database.WithTx(func(tx Database) error {
+		return f(&LogChunkCachingDatabase{Database: tx, cache: db.cache, add: func(logChunks ...model.LogChunk) {
+			added = append(added, logChunks...)
+		}})
+	})
+	if err != nil {
+		return err
+	}
+
+	db.add(added...)
+	return nil
+}
+
+func (db *LogChunkCachingDatabase) InsertLogChunk(logChunk *model.LogChunk) *DatabaseError {
+	if err := db.Database.InsertLogChunk(logChunk); err != nil {
+		return err
+	}
+	// Followers of the artifact are about to read the new chunk.
+	db.add(*logChunk)
+	return nil
+}
+
+func (db *LogChunkCachingDatabase) InsertLogChunks(logChunks []model.LogChunk) *DatabaseError {
+	if err := db.Database.InsertLogChunks(logChunks); err != nil {
+		return err
+	}
+	db.add(logChunks...)
+	return nil
+}
+
+func (db *LogChunkCachingDatabase) ListLogChunksInArtifact(artifactID int64, byteBegin int64, byteEnd int64) ([]model.LogChunk, *DatabaseError) {
+	return db.cache.ListLogChunksInArtifact(db.Database, db.add, artifactID, byteBegin, byteEnd)
+}
+
+var _ Database = (*LogChunkCachingDatabase)(nil)
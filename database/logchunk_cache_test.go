@@ -0,0 +1,139 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func testLogChunk(artifactID int64, byteOffset int64, content string) model.LogChunk {
+	return model.LogChunk{ArtifactId: artifactID, ByteOffset: byteOffset, Size: int64(len(content)), ContentBytes: []byte(content)}
+}
+
+func TestLogChunkCacheEviction(t *testing.T) {
+	c := NewLogChunkCache(10)
+
+	c.Add(testLogChunk(1, 0, "0123"), testLogChunk(1, 4, "4567"))
+	require.NotNil(t, c.Get(1, 0))
+	require.NotNil(t, c.Get(1, 4))
+	require.Nil(t, c.Get(1, 8))
+	require.Nil(t, c.Get(2, 0))
+
+	// Least recently used chunk (1, 0) is evicted to make room.
+	c.Get(1, 4)
+	c.Add(testLogChunk(2, 0, "abcd"))
+	require.Nil(t, c.Get(1, 0))
+	require.NotNil(t, c.Get(1, 4))
+	require.NotNil(t, c.Get(2, 0))
+	require.Equal(t, int64(8), c.bytes)
+	require.Equal(t, map[int64][]int64{1: {4}, 2: {0}}, c.offsets)
+
+	// Chunks larger than the cache are not cached.
+	c.Add(testLogChunk(3, 0, "0123456789a"))
+	require.Nil(t, c.Get(3, 0))
+	require.Equal(t, int64(8), c.bytes)
+
+	// Nothing is cached by an empty cache.
+	c = NewLogChunkCache(0)
+	c.Add(testLogChunk(1, 0, "0123"))
+	require.Nil(t, c.Get(1, 0))
+}
+
+func TestLogChunkCacheGet(t *testing.T) {
+	c := NewLogChunkCache(DefaultLogChunkCacheBytes)
+	c.Add(testLogChunk(1, 4, "4567"), testLogChunk(1, 0, "0123"), testLogChunk(1, 10, "ab"))
+
+	// Chunks are found from any offset within them.
+	require.Equal(t, int64(0), c.Get(1, 0).ByteOffset)
+	require.Equal(t, int64(0), c.Get(1, 3).ByteOffset)
+	require.Equal(t, int64(4), c.Get(1, 6).ByteOffset)
+	require.Equal(t, int64(10), c.Get(1, 11).ByteOffset)
+
+	// Gaps between cached chunks, and offsets past them
+	require.Nil(t, c.Get(1, 8))
+	require.Nil(t, c.Get(1, 12))
+	require.Nil(t, c.Get(1, -1))
+}
+
+func TestLogChunkCacheListLogChunksInArtifact(t *testing.T) {
+	mockdb := &MockDatabase{}
+	c := NewLogChunkCache(DefaultLogChunkCacheBytes)
+	c.Add(testLogChunk(1, 0, "0123"), testLogChunk(1, 4, "4567"))
+
+	// Range covered by cached chunks, even if it begins within a chunk.
+	chunks, err := c.ListLogChunksInArtifact(mockdb, c.Add, 1, 0, 6)
+	require.Nil(t, err)
+	require.Equal(t, []model.LogChunk{testLogChunk(1, 0, "0123"), testLogChunk(1, 4, "4567")}, chunks)
+	chunks, err = c.ListLogChunksInArtifact(mockdb, c.Add, 1, 2, 5)
+	require.Nil(t, err)
+	require.Equal(t, []model.LogChunk{testLogChunk(1, 0, "0123"), testLogChunk(1, 4, "4567")}, chunks)
+
+	// Range extending beyond cached chunks is read from the DB, and cached.
+	mockdb.On("ListLogChunksInArtifact", int64(1), int64(4), int64(10)).Return([]model.LogChunk{
+		testLogChunk(1, 4, "4567"), testLogChunk(1, 8, "89"),
+	}, nil).Once()
+	chunks, err = c.ListLogChunksInArtifact(mockdb, c.Add, 1, 4, 10)
+	require.Nil(t, err)
+	require.Len(t, chunks, 2)
+	require.NotNil(t, c.Get(1, 8))
+	mockdb.AssertExpectations(t)
+
+	// DB errors are returned as is.
+	mockdb.On("ListLogChunksInArtifact", int64(2), int64(0), int64(4)).Return(nil, MockDatabaseError()).Once()
+	_, err = c.ListLogChunksInArtifact(mockdb, c.Add, 2, 0, 4)
+	require.NotNil(t, err)
+	mockdb.AssertExpectations(t)
+}
+
+func TestLogChunkCachingDatabase(t *testing.T) {
+	mockdb := &MockDatabase{}
+	cache := NewLogChunkCache(DefaultLogChunkCacheBytes)
+	db := NewLogChunkCachingDatabase(mockdb, cache)
+
+	// Inserted chunks are cached, and read from the cache.
+	mockdb.On("InsertLogChunk", mock.AnythingOfType("*model.LogChunk")).Return(nil).Once()
+	logChunk := testLogChunk(1, 0, "0123")
+	require.Nil(t, db.InsertLogChunk(&logChunk))
+	mockdb.On("InsertLogChunks", mock.AnythingOfType("[]model.LogChunk")).Return(nil).Once()
+	require.Nil(t, db.InsertLogChunks([]model.LogChunk{testLogChunk(1, 4, "45"), testLogChunk(1, 6, "67")}))
+	chunks, err := db.ListLogChunksInArtifact(1, 2, 8)
+	require.Nil(t, err)
+	require.Len(t, chunks, 3)
+
+	// Failed inserts are not cached.
+	mockdb.On("InsertLogChunk", mock.AnythingOfType("*model.LogChunk")).Return(MockDatabaseError()).Once()
+	logChunk = testLogChunk(1, 8, "89")
+	require.NotNil(t, db.InsertLogChunk(&logChunk))
+	require.Nil(t, cache.Get(1, 8))
+
+	// Chunks inserted or read in a transaction are cached once it commits...
+	mockdb.On("WithTx", mock.AnythingOfType("func(database.Database) error")).Return(func(f func(Database) error) error {
+		return f(mockdb)
+	})
+	mockdb.On("InsertLogChunk", mock.AnythingOfType("*model.LogChunk")).Return(nil).Once()
+	mockdb.On("ListLogChunksInArtifact", int64(2), int64(0), int64(2)).Return([]model.LogChunk{testLogChunk(2, 0, "ab")}, nil).Once()
+	require.NoError(t, db.WithTx(func(tx Database) error {
+		require.Nil(t, tx.InsertLogChunk(&logChunk))
+		_, err := tx.ListLogChunksInArtifact(2, 0, 2)
+		require.Nil(t, err)
+		require.Nil(t, cache.Get(1, 8))
+		require.Nil(t, cache.Get(2, 0))
+		return nil
+	}))
+	require.NotNil(t, cache.Get(1, 8))
+	require.NotNil(t, cache.Get(2, 0))
+
+	// ... and not at all if it is rolled back.
+	mockdb.On("InsertLogChunk", mock.AnythingOfType("*model.LogChunk")).Return(nil).Once()
+	logChunk = testLogChunk(1, 10, "ab")
+	require.Error(t, db.WithTx(func(tx Database) error {
+		require.Nil(t, tx.InsertLogChunk(&logChunk))
+		return errors.New("rollback")
+	}))
+	require.Nil(t, cache.Get(1, 10))
+
+	mockdb.AssertExpectations(t)
+}
@@ -4,6 +4,7 @@ import "github.com/stretchr/testify/mock"
 
 import "github.com/dropbox/changes-artifacts/model"
 import _ "github.com/vektra/mockery"
+import "time"
 
 type MockDatabase struct {
 	mock.Mock
@@ -54,6 +55,20 @@ func (_m *MockDatabase) InsertLogChunk(_a0 *model.LogChunk) *DatabaseError {
 
 	return r0
 }
+func (_m *MockDatabase) InsertLogChunks(logChunks []model.LogChunk) *DatabaseError {
+	ret := _m.Called(logChunks)
+
+	var r0 *DatabaseError
+	if rf, ok := ret.Get(0).(func([]model.LogChunk) *DatabaseError); ok {
+		r0 = rf(logChunks)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*DatabaseError)
+		}
+	}
+
+	return r0
+}
 func (_m *MockDatabase) UpdateBucket(_a0 *model.Bucket) *DatabaseError {
 	ret := _m.Called(_a0)
 
@@ -68,12 +83,12 @@ func (_m *MockDatabase) UpdateBucket(_a0 *model.Bucket) *DatabaseError {
 
 	return r0
 }
-func (_m *MockDatabase) ListBuckets() ([]model.Bucket, *DatabaseError) {
-	ret := _m.Called()
+func (_m *MockDatabase) ListBuckets(filter BucketFilter) ([]model.Bucket, *DatabaseError) {
+	ret := _m.Called(filter)
 
 	var r0 []model.Bucket
-	if rf, ok := ret.Get(0).(func() []model.Bucket); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(BucketFilter) []model.Bucket); ok {
+		r0 = rf(filter)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]model.Bucket)
@@ -81,8 +96,8 @@ func (_m *MockDatabase) ListBuckets() ([]model.Bucket, *DatabaseError) {
 	}
 
 	var r1 *DatabaseError
-	if rf, ok := ret.Get(1).(func() *DatabaseError); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(BucketFilter) *DatabaseError); ok {
+		r1 = rf(filter)
 	} else {
 		if ret.Get(1) != nil {
 			r1 = ret.Get(1).(*DatabaseError)
@@ -241,3 +256,285 @@ func (_m *MockDatabase) GetLastLogChunkSeenForArtifact(_a0 int64) (*model.LogChu
 
 	return r0, r1
 }
+func (_m *MockDatabase) ListArtifactsPastDeadline(now time.Time) ([]model.Artifact, *DatabaseError) {
+	ret := _m.Called(now)
+
+	var r0 []model.Artifact
+	if rf, ok := ret.Get(0).(func(time.Time) []model.Artifact); ok {
+		r0 = rf(now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Artifact)
+		}
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func(time.Time) *DatabaseError); ok {
+		r1 = rf(now)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) ListBucketsPastDeadline(now time.Time) ([]model.Bucket, *DatabaseError) {
+	ret := _m.Called(now)
+
+	var r0 []model.Bucket
+	if rf, ok := ret.Get(0).(func(time.Time) []model.Bucket); ok {
+		r0 = rf(now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Bucket)
+		}
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func(time.Time) *DatabaseError); ok {
+		r1 = rf(now)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) ListArtifactsInState(state model.ArtifactState, limit int) ([]model.Artifact, *DatabaseError) {
+	ret := _m.Called(state, limit)
+
+	var r0 []model.Artifact
+	if rf, ok := ret.Get(0).(func(model.ArtifactState, int) []model.Artifact); ok {
+		r0 = rf(state, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Artifact)
+		}
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func(model.ArtifactState, int) *DatabaseError); ok {
+		r1 = rf(state, limit)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) CompareAndSwapArtifactState(artifactID int64, expectedState model.ArtifactState, newState model.ArtifactState) (int64, *DatabaseError) {
+	ret := _m.Called(artifactID, expectedState, newState)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(int64, model.ArtifactState, model.ArtifactState) int64); ok {
+		r0 = rf(artifactID, expectedState, newState)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func(int64, model.ArtifactState, model.ArtifactState) *DatabaseError); ok {
+		r1 = rf(artifactID, expectedState, newState)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) ListStaleArtifacts(state model.ArtifactState, updatedBefore time.Time) ([]model.Artifact, *DatabaseError) {
+	ret := _m.Called(state, updatedBefore)
+
+	var r0 []model.Artifact
+	if rf, ok := ret.Get(0).(func(model.ArtifactState, time.Time) []model.Artifact); ok {
+		r0 = rf(state, updatedBefore)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Artifact)
+		}
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func(model.ArtifactState, time.Time) *DatabaseError); ok {
+		r1 = rf(state, updatedBefore)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) DeleteArtifact(artifactID int64) *DatabaseError {
+	ret := _m.Called(artifactID)
+
+	var r0 *DatabaseError
+	if rf, ok := ret.Get(0).(func(int64) *DatabaseError); ok {
+		r0 = rf(artifactID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*DatabaseError)
+		}
+	}
+
+	return r0
+}
+func (_m *MockDatabase) DeleteBucket(bucketID string) *DatabaseError {
+	ret := _m.Called(bucketID)
+
+	var r0 *DatabaseError
+	if rf, ok := ret.Get(0).(func(string) *DatabaseError); ok {
+		r0 = rf(bucketID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*DatabaseError)
+		}
+	}
+
+	return r0
+}
+func (_m *MockDatabase) ListExpiredBuckets(owner string, createdBefore time.Time, after *BucketCursor, limit int) ([]model.Bucket, *DatabaseError) {
+	ret := _m.Called(owner, createdBefore, after, limit)
+
+	var r0 []model.Bucket
+	if rf, ok := ret.Get(0).(func(string, time.Time, *BucketCursor, int) []model.Bucket); ok {
+		r0 = rf(owner, createdBefore, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Bucket)
+		}
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func(string, time.Time, *BucketCursor, int) *DatabaseError); ok {
+		r1 = rf(owner, createdBefore, after, limit)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) ListExpiredBucketsOfOtherOwners(excludedOwners []string, createdBefore time.Time, after *BucketCursor, limit int) ([]model.Bucket, *DatabaseError) {
+	ret := _m.Called(excludedOwners, createdBefore, after, limit)
+
+	var r0 []model.Bucket
+	if rf, ok := ret.Get(0).(func([]string, time.Time, *BucketCursor, int) []model.Bucket); ok {
+		r0 = rf(excludedOwners, createdBefore, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Bucket)
+		}
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func([]string, time.Time, *BucketCursor, int) *DatabaseError); ok {
+		r1 = rf(excludedOwners, createdBefore, after, limit)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) ListArtifacts(filter ArtifactFilter) ([]model.Artifact, *DatabaseError) {
+	ret := _m.Called(filter)
+
+	var r0 []model.Artifact
+	if rf, ok := ret.Get(0).(func(ArtifactFilter) []model.Artifact); ok {
+		r0 = rf(filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Artifact)
+		}
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func(ArtifactFilter) *DatabaseError); ok {
+		r1 = rf(filter)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) InsertAPIToken(token *model.APIToken) *DatabaseError {
+	ret := _m.Called(token)
+
+	var r0 *DatabaseError
+	if rf, ok := ret.Get(0).(func(*model.APIToken) *DatabaseError); ok {
+		r0 = rf(token)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*DatabaseError)
+		}
+	}
+
+	return r0
+}
+func (_m *MockDatabase) GetAPITokenByHash(tokenHash string) (*model.APIToken, *DatabaseError) {
+	ret := _m.Called(tokenHash)
+
+	var r0 *model.APIToken
+	if rf, ok := ret.Get(0).(func(string) *model.APIToken); ok {
+		r0 = rf(tokenHash)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.APIToken)
+		}
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func(string) *DatabaseError); ok {
+		r1 = rf(tokenHash)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) ListArtifactEvents(artifactID int64) ([]model.ArtifactEvent, *DatabaseError) {
+	ret := _m.Called(artifactID)
+
+	var r0 []model.ArtifactEvent
+	if rf, ok := ret.Get(0).(func(int64) []model.ArtifactEvent); ok {
+		r0 = rf(artifactID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.ArtifactEvent)
+		}
+	}
+
+	var r1 *DatabaseError
+	if rf, ok := ret.Get(1).(func(int64) *DatabaseError); ok {
+		r1 = rf(artifactID)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*DatabaseError)
+		}
+	}
+
+	return r0, r1
+}
+func (_m *MockDatabase) WithTx(f func(Database) error) error {
+	ret := _m.Called(f)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(func(Database) error) error); ok {
+		r0 = rf(f)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,90 @@
+package database
+
+import "github.com/dropbox/changes-artifacts/model"
+
+// NotifyingDatabase is a Database which calls notify with the id of every artifact whose state,
+// size or log chunks are changed through it, along with the resulting artifact version (or 0 if
+// unknown). Changes made in a transaction are only notified once the transaction commits, once per
+// artifact, and not at all if it is rolled back.
+//
+// This makes changes visible to the server that made them even if notifications from the database
+// (see ArtifactListener) are delayed or unavailable.
+type NotifyingDatabase struct {
+	Database
+	notify func(artifactID int64, version int64)
+}
+
+// NewNotifyingDatabase wraps db, calling notify after each artifact change.
+func NewNotifyingDatabase(db Database, notify func(artifactID int64, version int64)) *NotifyingDatabase {
+	return &NotifyingDatabase{Database: db, notify: notify}
+}
+
+func (db *NotifyingDatabase) WithTx(f func(tx Database) error) error {
+	var changed []int64
+	versions := make(map[int64]int64)
+	err := db.Database.WithTx(func(tx Database) error {
+		return f(NewNotifyingDatabase(tx, func(artifactID int64, version int64) {
+			if latest, ok := versions[artifactID]; !ok {
+				changed = append(changed, artifactID)
+				versions[artifactID] = version
+			} else if version > latest {
+				versions[artifactID] = version
+			}
+		}))
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, artifactID := range changed {
+		db.notify(artifactID, versions[artifactID])
+	}
+	return nil
+}
+
+func (db *NotifyingDatabase) InsertLogChunk(logChunk *model.LogChunk) *DatabaseError {
+	if err := db.Database.InsertLogChunk(logChunk); err != nil {
+		return err
+	}
+	db.notify(logChunk.ArtifactId, 0)
+	return nil
+}
+
+func (db *NotifyingDatabase) InsertLogChunks(logChunks []model.LogChunk) *DatabaseError {
+	if err := db.Database.InsertLogChunks(logChunks); err != nil {
+		return err
+	}
+	for i, logChunk := range logChunks {
+		if i == 0 || logChunk.ArtifactId != logChunks[i-1].ArtifactId {
+			db.notify(logChunk.ArtifactId, 0)
+		}
+	}
+	return nil
+}
+
+func (db *NotifyingDatabase) UpdateArtifact(artifact *model.Artifact) *DatabaseError {
+	if err := db.Database.UpdateArtifact(artifact); err != nil {
+		return err
+	}
+	db.notify(artifact.Id, artifact.Version)
+	return nil
+}
+
+func (db *NotifyingDatabase) CompareAndSwapArtifactState(artifactID int64, expectedState model.ArtifactState, newState model.ArtifactState) (int64, *DatabaseError) {
+	version, err := db.Database.CompareAndSwapArtifactState(artifactID, expectedState, newState)
+	if err != nil {
+		return version, err
+	}
+	db.notify(artifactID, version)
+	return version, nil
+}
+
+func (db *NotifyingDatabase) DeleteArtifact(artifactID int64) *DatabaseError {
+	if err := db.Database.DeleteArtifact(artifactID); err != nil {
+		return err
+	}
+	db.notify(artifactID, 0)
+	return nil
+}
+
+var _ Database = (*NotifyingDatabase)(nil)
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dropbox/changes-artifacts/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyingDatabase(t *testing.T) {
+	mockdb := &MockDatabase{}
+	var notified []int64
+	db := NewNotifyingDatabase(mockdb, func(artifactID int64, version int64) {
+		notified = append(notified, artifactID, version)
+	})
+
+	mockdb.On("UpdateArtifact", mock.AnythingOfType("*model.Artifact")).Return(nil).Once()
+	require.Nil(t, db.UpdateArtifact(&model.Artifact{Id: 1, Version: 5}))
+	require.Equal(t, []int64{1, 5}, notified)
+
+	// Failed changes are not notified.
+	mockdb.On("InsertLogChunk", mock.AnythingOfType("*model.LogChunk")).Return(MockDatabaseError()).Once()
+	require.NotNil(t, db.InsertLogChunk(&model.LogChunk{ArtifactId: 2}))
+	require.Equal(t, []int64{1, 5}, notified)
+
+	mockdb.On("InsertLogChunks", mock.AnythingOfType("[]model.LogChunk")).Return(nil).Once()
+	require.Nil(t, db.InsertLogChunks([]model.LogChunk{{ArtifactId: 2}, {ArtifactId: 2}, {ArtifactId: 3}}))
+	require.Equal(t, []int64{1, 5, 2, 0, 3, 0}, notified)
+
+	// Changes made in a transaction are notified once per artifact, with the latest known version,
+	// once it commits...
+	notified = nil
+	mockdb.On("WithTx", mock.AnythingOfType("func(database.Database) error")).Return(func(f func(Database) error) error {
+		return f(mockdb)
+	})
+	mockdb.On("InsertLogChunk", mock.AnythingOfType("*model.LogChunk")).Return(nil)
+	mockdb.On("CompareAndSwapArtifactState", int64(4), model.APPEND_COMPLETE, model.UPLOADING).Return(int64(2), nil)
+	require.NoError(t, db.WithTx(func(tx Database) error {
+		require.Nil(t, tx.InsertLogChunk(&model.LogChunk{ArtifactId: 4}))
+		_, err := tx.CompareAndSwapArtifactState(4, model.APPEND_COMPLETE, model.UPLOADING)
+		require.Nil(t, err)
+		require.Empty(t, notified)
+		return nil
+	}))
+	require.Equal(t, []int64{4, 2}, notified)
+
+	// ... and not at all if it is rolled back.
+	notified = nil
+	require.Error(t, db.WithTx(func(tx Database) error {
+		tx.CompareAndSwapArtifactState(4, model.APPEND_COMPLETE, model.UPLOADING)
+		return errors.New("rollback")
+	}))
+	require.Empty(t, notified)
+	mockdb.AssertExpectations(t)
+}
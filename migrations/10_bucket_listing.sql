@@ -0,0 +1,16 @@
+-- +migrate Up
+-- Indexes for paginated bucket listings, which are ordered by (datecreated, id), optionally
+-- filtered by owner or state.
+CREATE INDEX bucket_datecreated_id ON bucket (datecreated, id);
+CREATE INDEX bucket_owner_datecreated_id ON bucket (owner, datecreated, id);
+CREATE INDEX bucket_state_datecreated_id ON bucket (state, datecreated, id);
+-- Superseded by the indexes above.
+DROP INDEX bucket_owner_datecreated;
+DROP INDEX bucket_state_datecreated;
+
+-- +migrate Down
+CREATE INDEX bucket_state_datecreated ON bucket (state, datecreated);
+CREATE INDEX bucket_owner_datecreated ON bucket (owner, datecreated);
+DROP INDEX bucket_state_datecreated_id;
+DROP INDEX bucket_owner_datecreated_id;
+DROP INDEX bucket_datecreated_id;
@@ -0,0 +1,16 @@
+-- +migrate Up
+-- Indexes for paginated artifact listings within a bucket, ordered by id, size or datecreated
+-- (ordering by name uses the (bucketid, name) unique index).
+CREATE INDEX artifact_bucketid_id ON artifact (bucketid, id);
+CREATE INDEX artifact_bucketid_size_id ON artifact (bucketid, size, id);
+CREATE INDEX artifact_bucketid_datecreated_id ON artifact (bucketid, datecreated, id);
+-- Used for name and relativepath prefix matching with LIKE.
+CREATE INDEX artifact_bucketid_name_pattern ON artifact (bucketid, name text_pattern_ops);
+CREATE INDEX artifact_bucketid_relativepath_pattern ON artifact (bucketid, relativepath text_pattern_ops);
+
+-- +migrate Down
+DROP INDEX artifact_bucketid_relativepath_pattern;
+DROP INDEX artifact_bucketid_name_pattern;
+DROP INDEX artifact_bucketid_datecreated_id;
+DROP INDEX artifact_bucketid_size_id;
+DROP INDEX artifact_bucketid_id;
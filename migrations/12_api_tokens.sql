@@ -0,0 +1,16 @@
+-- +migrate Up
+CREATE TABLE apitoken (
+  id BIGSERIAL NOT NULL PRIMARY KEY,
+  tokenhash TEXT NOT NULL UNIQUE,
+  description TEXT NOT NULL DEFAULT '',
+  datecreated TIMESTAMP WITH TIME ZONE
+);
+CREATE TABLE apitokenowner (
+  tokenid BIGINT NOT NULL REFERENCES apitoken (id) ON DELETE CASCADE,
+  owner TEXT NOT NULL,
+  PRIMARY KEY (tokenid, owner)
+);
+
+-- +migrate Down
+DROP TABLE apitokenowner;
+DROP TABLE apitoken;
@@ -0,0 +1,38 @@
+-- +migrate Up
+-- Audit log of artifact state transitions, maintained by triggers on the artifact table so that no
+-- transition can be missed.
+CREATE TABLE artifact_event (
+  id BIGSERIAL NOT NULL PRIMARY KEY,
+  artifactid BIGINT NOT NULL REFERENCES artifact (id) ON DELETE CASCADE,
+  fromstate TEXT NOT NULL,
+  tostate TEXT NOT NULL,
+  datecreated TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX artifact_event_artifactid_id ON artifact_event (artifactid, id);
+
+-- +migrate StatementBegin
+CREATE FUNCTION record_artifact_event() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    -- Created from UNKNOWN_ARTIFACT_STATE.
+    INSERT INTO artifact_event (artifactid, fromstate, tostate, datecreated)
+      VALUES (NEW.id, '0', NEW.state, clock_timestamp());
+  ELSE
+    INSERT INTO artifact_event (artifactid, fromstate, tostate, datecreated)
+      VALUES (NEW.id, OLD.state, NEW.state, clock_timestamp());
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER artifact_created AFTER INSERT ON artifact
+  FOR EACH ROW EXECUTE PROCEDURE record_artifact_event();
+CREATE TRIGGER artifact_state_changed AFTER UPDATE OF state ON artifact
+  FOR EACH ROW WHEN (OLD.state IS DISTINCT FROM NEW.state) EXECUTE PROCEDURE record_artifact_event();
+
+-- +migrate Down
+DROP TRIGGER artifact_state_changed ON artifact;
+DROP TRIGGER artifact_created ON artifact;
+DROP FUNCTION record_artifact_event();
+DROP TABLE artifact_event;
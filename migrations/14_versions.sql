@@ -0,0 +1,9 @@
+-- +migrate Up
+-- Version numbers for optimistic concurrency control. Updates only succeed if the row still has
+-- the version that was read.
+ALTER TABLE bucket ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE artifact ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+-- +migrate Down
+ALTER TABLE artifact DROP COLUMN version;
+ALTER TABLE bucket DROP COLUMN version;
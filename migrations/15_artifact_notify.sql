@@ -0,0 +1,23 @@
+-- +migrate Up
+-- Notify servers listening on the artifact_changed channel of changes to the state or size (that is,
+-- appended content) of an artifact. Notifications are only delivered once the change commits, and
+-- carry the artifact id.
+-- +migrate StatementBegin
+CREATE FUNCTION notify_artifact_changed() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('artifact_changed', OLD.id::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER artifact_changed AFTER UPDATE OF state, size ON artifact
+  FOR EACH ROW WHEN (OLD.state IS DISTINCT FROM NEW.state OR OLD.size IS DISTINCT FROM NEW.size)
+  EXECUTE PROCEDURE notify_artifact_changed();
+CREATE TRIGGER artifact_deleted AFTER DELETE ON artifact
+  FOR EACH ROW EXECUTE PROCEDURE notify_artifact_changed();
+
+-- +migrate Down
+DROP TRIGGER artifact_deleted ON artifact;
+DROP TRIGGER artifact_changed ON artifact;
+DROP FUNCTION notify_artifact_changed();
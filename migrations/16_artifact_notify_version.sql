@@ -0,0 +1,26 @@
+-- +migrate Up
+-- Include the new artifact version in artifact_changed notifications of updates (as
+-- "<id>:<version>"), so that servers can tell notifications of changes they already know about.
+-- Notifications of deleted artifacts still carry only the artifact id.
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION notify_artifact_changed() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('artifact_changed', OLD.id::text);
+  ELSE
+    PERFORM pg_notify('artifact_changed', NEW.id::text || ':' || NEW.version::text);
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+-- +migrate Down
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION notify_artifact_changed() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('artifact_changed', OLD.id::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
@@ -0,0 +1,5 @@
+-- +migrate Up
+CREATE INDEX artifact_state_datecreated ON artifact (state, datecreated);
+
+-- +migrate Down
+DROP INDEX artifact_state_datecreated;
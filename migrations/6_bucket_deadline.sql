@@ -0,0 +1,8 @@
+-- +migrate Up
+-- Existing buckets get no deadline (0) so that they are not timed out en masse.
+ALTER TABLE bucket ADD COLUMN deadlinemins INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX bucket_state_datecreated ON bucket (state, datecreated);
+
+-- +migrate Down
+DROP INDEX bucket_state_datecreated;
+ALTER TABLE bucket DROP COLUMN deadlinemins;
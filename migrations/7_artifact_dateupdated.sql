@@ -0,0 +1,12 @@
+-- +migrate Up
+-- dateupdated is used to detect artifacts which are stuck in a transient state (for example, after
+-- a server crash in the middle of an upload).
+ALTER TABLE artifact ADD COLUMN dateupdated TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+UPDATE artifact SET dateupdated = datecreated WHERE datecreated IS NOT NULL;
+ALTER TABLE artifact ADD COLUMN errorreason TEXT NOT NULL DEFAULT '';
+CREATE INDEX artifact_state_dateupdated ON artifact (state, dateupdated);
+
+-- +migrate Down
+DROP INDEX artifact_state_dateupdated;
+ALTER TABLE artifact DROP COLUMN errorreason;
+ALTER TABLE artifact DROP COLUMN dateupdated;
@@ -0,0 +1,7 @@
+-- +migrate Up
+-- Hex encoded SHA-256 digest of artifact content, computed while uploading. Empty for artifacts
+-- uploaded before digests were recorded.
+ALTER TABLE artifact ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
+
+-- +migrate Down
+ALTER TABLE artifact DROP COLUMN sha256;
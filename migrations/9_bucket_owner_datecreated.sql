@@ -0,0 +1,6 @@
+-- +migrate Up
+-- Used to find buckets which have expired under an owner's retention policy.
+CREATE INDEX bucket_owner_datecreated ON bucket (owner, datecreated);
+
+-- +migrate Down
+DROP INDEX bucket_owner_datecreated;
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// AllOwners, as one of the owners of an APIToken, grants access to buckets of every owner.
+const AllOwners = "*"
+
+// APIToken authenticates requests to the REST API. A token may create and modify buckets (and
+// their artifacts) of the owners it has been granted. Only a hash of the token is stored, the token
+// itself is only known to whoever it was issued to.
+type APIToken struct {
+	// Auto-generated unique id.
+	Id int64 `json:"id"`
+	// Hex encoded SHA-256 digest of the token.
+	TokenHash string `json:"-"`
+	// Human readable description of who the token was issued to.
+	Description string    `json:"description"`
+	DateCreated time.Time `json:"dateCreated"`
+	// Bucket owners granted to this token, stored as APITokenOwner rows.
+	Owners []string `json:"owners" db:"-"`
+}
+
+// AllowsOwner returns true if the token may create or modify buckets of given owner.
+func (t *APIToken) AllowsOwner(owner string) bool {
+	for _, o := range t.Owners {
+		if o == owner || o == AllOwners {
+			return true
+		}
+	}
+	return false
+}
+
+// APITokenOwner grants a bucket owner to an APIToken.
+type APITokenOwner struct {
+	TokenId int64
+	Owner   string
+}
@@ -57,6 +57,15 @@ type Artifact struct {
 	State        ArtifactState `json:"state"`
 	DeadlineMins uint          `json:"deadlineMins"`
 	RelativePath string        `json:"relativePath"`
+	// Time of last update to the artifact. Used to detect artifacts stuck in a transient state.
+	DateUpdated time.Time `json:"dateUpdated"`
+	// Human readable reason for an artifact being in ERROR state, if known.
+	ErrorReason string `json:"errorReason"`
+	// Hex encoded SHA-256 digest of the artifact content. Only set once the artifact has been
+	// uploaded.
+	Sha256 string `json:"sha256"`
+	// Incremented on every update. Updates of a stale copy of the artifact are rejected.
+	Version int64 `json:"version"`
 }
 
 func (a *Artifact) DefaultS3URL() string {
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// artifactTransitions is the artifact state machine. It lists the states an artifact may move to
+// from each state. States without an entry are terminal.
+var artifactTransitions = map[ArtifactState][]ArtifactState{
+	// Artifact creation.
+	UNKNOWN_ARTIFACT_STATE: {APPENDING, WAITING_FOR_UPLOAD},
+
+	// Closed (with or without content), or expired before receiving any content.
+	APPENDING: {APPEND_COMPLETE, CLOSED_WITHOUT_DATA, DEADLINE_EXCEEDED},
+
+	// Claimed for merging, or found to be empty.
+	APPEND_COMPLETE: {UPLOADING, CLOSED_WITHOUT_DATA},
+
+	// Upload started, closed without upload or expired.
+	WAITING_FOR_UPLOAD: {UPLOADING, CLOSED_WITHOUT_DATA, DEADLINE_EXCEEDED},
+
+	// Upload completed or failed. Interrupted merges go back to APPEND_COMPLETE to be retried.
+	UPLOADING: {UPLOADED, ERROR, APPEND_COMPLETE},
+}
+
+// IllegalTransitionError is returned when an artifact is moved to a state which can't be reached
+// from its current state.
+type IllegalTransitionError struct {
+	From ArtifactState
+	To   ArtifactState
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("Illegal artifact state transition from %s to %s", e.From, e.To)
+}
+
+// ValidateArtifactTransition returns an *IllegalTransitionError if an artifact may not move from
+// state from to state to.
+func ValidateArtifactTransition(from ArtifactState, to ArtifactState) error {
+	for _, s := range artifactTransitions[from] {
+		if s == to {
+			return nil
+		}
+	}
+	return &IllegalTransitionError{From: from, To: to}
+}
+
+// IsTerminal returns true if an artifact can't move out of state s.
+func (s ArtifactState) IsTerminal() bool {
+	return len(artifactTransitions[s]) == 0
+}
+
+// TransitionTo moves the artifact to given state if the state machine allows it. Otherwise, an
+// *IllegalTransitionError is returned and the artifact is left untouched.
+func (a *Artifact) TransitionTo(state ArtifactState) error {
+	if err := ValidateArtifactTransition(a.State, state); err != nil {
+		return err
+	}
+	a.State = state
+	return nil
+}
+
+// ArtifactEvent records an artifact state transition. Events are recorded by the database itself
+// whenever the state of an artifact changes, including its creation (from UNKNOWN_ARTIFACT_STATE).
+type ArtifactEvent struct {
+	// Auto-generated unique id.
+	Id          int64         `json:"id"`
+	ArtifactId  int64         `json:"artifactId"`
+	FromState   ArtifactState `json:"fromState"`
+	ToState     ArtifactState `json:"toState"`
+	DateCreated time.Time     `json:"dateCreated"`
+}
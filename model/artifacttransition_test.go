@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateArtifactTransition(t *testing.T) {
+	require.NoError(t, ValidateArtifactTransition(UNKNOWN_ARTIFACT_STATE, APPENDING))
+	require.NoError(t, ValidateArtifactTransition(APPENDING, APPEND_COMPLETE))
+	require.NoError(t, ValidateArtifactTransition(APPEND_COMPLETE, UPLOADING))
+	require.NoError(t, ValidateArtifactTransition(UPLOADING, UPLOADED))
+	require.NoError(t, ValidateArtifactTransition(WAITING_FOR_UPLOAD, DEADLINE_EXCEEDED))
+
+	err := ValidateArtifactTransition(UPLOADED, APPENDING)
+	require.Equal(t, &IllegalTransitionError{From: UPLOADED, To: APPENDING}, err)
+	require.Error(t, ValidateArtifactTransition(APPENDING, UPLOADED))
+	require.Error(t, ValidateArtifactTransition(APPENDING, APPENDING))
+
+	// Nothing leaves a terminal state.
+	for _, s := range []ArtifactState{UPLOADED, ERROR, DEADLINE_EXCEEDED, CLOSED_WITHOUT_DATA} {
+		require.True(t, s.IsTerminal(), "%s", s)
+		require.Error(t, ValidateArtifactTransition(s, APPENDING))
+	}
+	require.False(t, APPENDING.IsTerminal())
+}
+
+func TestArtifactTransitionTo(t *testing.T) {
+	artifact := &Artifact{State: APPENDING}
+	require.NoError(t, artifact.TransitionTo(APPEND_COMPLETE))
+	require.Equal(t, APPEND_COMPLETE, artifact.State)
+
+	// Illegal transitions leave the artifact untouched.
+	require.Error(t, artifact.TransitionTo(WAITING_FOR_UPLOAD))
+	require.Equal(t, APPEND_COMPLETE, artifact.State)
+}
@@ -17,7 +17,6 @@ const (
 	CLOSED
 
 	// Similar to `CLOSED`. Was forcibly closed because it was not explicitly closed before deadline.
-	// TODO This isn't implemented yet. Implement it.
 	TIMEDOUT
 )
 
@@ -30,4 +29,9 @@ type Bucket struct {
 	// A characteristic string signifying what service owns the bucket.
 	Owner string      `json:"owner"`
 	State BucketState `json:"state"`
+	// Number of minutes after creation by which the bucket must be closed. Buckets which are still
+	// open after their deadline are closed automatically and marked TIMEDOUT. Zero means no deadline.
+	DeadlineMins uint `json:"deadlineMins"`
+	// Incremented on every update. Updates of a stale copy of the bucket are rejected.
+	Version int64 `json:"version"`
 }
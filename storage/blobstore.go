@@ -0,0 +1,78 @@
+// Package storage abstracts the blob store used to hold artifact contents once they are complete
+// (uploaded streamed artifacts and merged chunked artifacts).
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotExist is returned when the requested blob does not exist.
+var ErrNotExist = errors.New("Blob does not exist")
+
+// ErrSignedURLNotSupported is returned by stores which cannot hand out direct URLs to blobs.
+var ErrSignedURLNotSupported = errors.New("Signed URLs are not supported by this blob store")
+
+// BlobStore stores immutable blobs of content, identified by name. Names are slash separated
+// paths, as generated by model.Artifact.DefaultS3URL().
+type BlobStore interface {
+	// Put stores exactly size bytes read from content as blob name, replacing any existing blob.
+	Put(name string, content io.Reader, size int64) error
+
+	// Get returns a reader for the entire contents of blob name. Caller must close the reader.
+	Get(name string) (io.ReadCloser, error)
+
+	// GetRange returns a reader for bytes begin through end (both inclusive) of blob name. Caller
+	// must close the reader.
+	GetRange(name string, begin int64, end int64) (io.ReadCloser, error)
+
+	// Delete removes blob name. Deleting a blob which does not exist is not an error.
+	Delete(name string) error
+
+	// SignedURL returns a URL through which blob name can be fetched directly (without going
+	// through the artifact server) until expires.
+	SignedURL(name string, expires time.Time) (string, error)
+}
+
+// BlobVersion identifies the content of a blob at one point in time. Blob names are reused when
+// artifacts are deleted and created again (possibly through another server), so cached content is
+// looked up by ID rather than by name.
+type BlobVersion struct {
+	Name string
+	// ID is unique to this content of blob Name, e.g. derived from the identity of the artifact
+	// holding it. Blob versions without an ID are never cached.
+	ID   string
+	Size int64
+}
+
+// VersionedBlobStore is implemented by blob stores which make use of the version of blobs being
+// read, such as CachingBlobStore.
+type VersionedBlobStore interface {
+	BlobStore
+
+	// GetVersion returns a reader for the entire contents of blob version v. Caller must close the
+	// reader.
+	GetVersion(v BlobVersion) (io.ReadCloser, error)
+
+	// GetVersionRange returns a reader for bytes begin through end (both inclusive) of blob version
+	// v. Caller must close the reader.
+	GetVersionRange(v BlobVersion, begin int64, end int64) (io.ReadCloser, error)
+}
+
+// GetVersion reads blob version v from store, using its version if store supports it.
+func GetVersion(store BlobStore, v BlobVersion) (io.ReadCloser, error) {
+	if vs, ok := store.(VersionedBlobStore); ok {
+		return vs.GetVersion(v)
+	}
+	return store.Get(v.Name)
+}
+
+// GetVersionRange reads bytes begin through end of blob version v from store, using its version if
+// store supports it.
+func GetVersionRange(store BlobStore, v BlobVersion, begin int64, end int64) (io.ReadCloser, error) {
+	if vs, ok := store.(VersionedBlobStore); ok {
+		return vs.GetVersionRange(v, begin, end)
+	}
+	return store.GetRange(v.Name, begin, end)
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/amz.v1/aws"
+	"gopkg.in/amz.v1/s3"
+	"gopkg.in/amz.v1/s3/s3test"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testBlobStore verifies behaviour common to all BlobStore implementations.
+func testBlobStore(t *testing.T, store BlobStore) {
+	const name = "/bucketName/artifactName"
+
+	readAndClose := func(rc io.ReadCloser, err error) string {
+		require.NoError(t, err)
+		defer rc.Close()
+
+		content, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	_, err := store.Get(name)
+	require.Equal(t, ErrNotExist, err)
+	_, err = store.GetRange(name, 0, 1)
+	require.Equal(t, ErrNotExist, err)
+
+	// Short read from content
+	require.Error(t, store.Put("/bucketName/shortArtifact", bytes.NewBufferString("01234"), 10))
+
+	require.NoError(t, store.Put(name, bytes.NewBufferString("0123456789"), 10))
+	require.Equal(t, "0123456789", readAndClose(store.Get(name)))
+	require.Equal(t, "0", readAndClose(store.GetRange(name, 0, 0)))
+	require.Equal(t, "2345", readAndClose(store.GetRange(name, 2, 5)))
+	require.Equal(t, "789", readAndClose(store.GetRange(name, 7, 9)))
+
+	// Overwrite existing blob
+	require.NoError(t, store.Put(name, bytes.NewBufferString("abc"), 3))
+	require.Equal(t, "abc", readAndClose(store.Get(name)))
+
+	require.NoError(t, store.Delete(name))
+	_, err = store.Get(name)
+	require.Equal(t, ErrNotExist, err)
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts-blobstore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalBlobStore(dir)
+	require.NoError(t, err)
+
+	testBlobStore(t, store)
+
+	// Failed writes leave neither partial blobs nor temporary files behind.
+	require.Error(t, store.Put("/bucketName/failedArtifact", bytes.NewBufferString("01234"), 10))
+	files, err := ioutil.ReadDir(dir + "/bucketName")
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	// Deleting missing blob is not an error.
+	require.NoError(t, store.Delete("/bucketName/missingArtifact"))
+
+	_, err = store.SignedURL("/bucketName/artifactName", time.Now())
+	require.Equal(t, ErrSignedURLNotSupported, err)
+
+	// Blob names can't escape the root directory.
+	require.NoError(t, store.Put("/../../escapedArtifact", bytes.NewBufferString("0"), 1))
+	_, err = os.Stat(dir + "/escapedArtifact")
+	require.NoError(t, err)
+}
+
+// testS3Bucket creates a bucket on a test S3 server. If proxy is not nil, requests to S3 go through
+// it, so that tests can observe or interfere with them.
+func testS3Bucket(t *testing.T, proxy func(http.ResponseWriter, *http.Request, http.Handler)) (*s3.Bucket, func()) {
+	s3Server, err := s3test.NewServer(&s3test.Config{Send409Conflict: true})
+	require.NoError(t, err)
+
+	endpoint := s3Server.URL()
+	cleanup := s3Server.Quit
+	if proxy != nil {
+		target, err := url.Parse(s3Server.URL())
+		require.NoError(t, err)
+		reverseProxy := httputil.NewSingleHostReverseProxy(target)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxy(w, r, reverseProxy)
+		}))
+		endpoint = ts.URL
+		cleanup = func() {
+			ts.Close()
+			s3Server.Quit()
+		}
+	}
+
+	bucket := s3.New(aws.Auth{AccessKey: "abc", SecretKey: "123"}, aws.Region{
+		Name:                 "fake-artifacts-test-region",
+		S3Endpoint:           endpoint,
+		S3LocationConstraint: true,
+		Sign:                 aws.SignV2,
+	}).Bucket("fake-artifacts-store-bucket")
+	require.NoError(t, bucket.PutBucket(s3.Private))
+
+	return bucket, cleanup
+}
+
+func TestS3BlobStore(t *testing.T) {
+	bucket, cleanup := testS3Bucket(t, nil)
+	defer cleanup()
+
+	testBlobStore(t, NewS3BlobStore(bucket))
+}
+
+func testMultipartS3BlobStore(bucket *s3.Bucket) *S3BlobStore {
+	store := NewS3BlobStore(bucket)
+	store.MultipartThreshold = 8
+	store.PartSize = 4
+	return store
+}
+
+func TestS3BlobStoreMultipart(t *testing.T) {
+	bucket, cleanup := testS3Bucket(t, nil)
+	defer cleanup()
+
+	testBlobStore(t, testMultipartS3BlobStore(bucket))
+
+	// Failed upload is aborted.
+	multis, _, err := bucket.ListMulti("/bucketName/shortArtifact", "")
+	require.NoError(t, err)
+	require.Empty(t, multis)
+}
+
+func TestS3BlobStoreMultipartRetriesParts(t *testing.T) {
+	var lock sync.Mutex
+	failedParts := make(map[string]bool)
+
+	// Fail the first attempt to upload each part.
+	bucket, cleanup := testS3Bucket(t, func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		lock.Lock()
+		fail := false
+		if part := r.URL.Query().Get("partNumber"); r.Method == "PUT" && part != "" {
+			fail = !failedParts[part]
+			failedParts[part] = true
+		}
+		lock.Unlock()
+
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	defer cleanup()
+
+	store := testMultipartS3BlobStore(bucket)
+	require.NoError(t, store.Put("/bucketName/artifactName", bytes.NewBufferString("0123456789"), 10))
+
+	content, err := bucket.Get("/bucketName/artifactName")
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(content))
+	require.Len(t, failedParts, 3)
+
+	// Give up after MaxPartAttempts.
+	store.MaxPartAttempts = 1
+	lock.Lock()
+	failedParts = make(map[string]bool)
+	lock.Unlock()
+	require.Error(t, store.Put("/bucketName/failedArtifact", bytes.NewBufferString("0123456789"), 10))
+}
+
+func TestS3BlobStoreMultipartResumesUpload(t *testing.T) {
+	var lock sync.Mutex
+	var uploadedParts []string
+
+	bucket, cleanup := testS3Bucket(t, func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		if part := r.URL.Query().Get("partNumber"); r.Method == "PUT" && part != "" {
+			lock.Lock()
+			uploadedParts = append(uploadedParts, part)
+			lock.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+	defer cleanup()
+
+	// Interrupted upload, with a correct first part and a corrupt second part.
+	multi, err := bucket.InitMulti("/bucketName/artifactName", contentType, s3.PublicRead)
+	require.NoError(t, err)
+	_, err = multi.PutPart(1, bytes.NewReader([]byte("0123")))
+	require.NoError(t, err)
+	_, err = multi.PutPart(2, bytes.NewReader([]byte("xxxx")))
+	require.NoError(t, err)
+	uploadedParts = nil
+
+	store := testMultipartS3BlobStore(bucket)
+	require.NoError(t, store.Put("/bucketName/artifactName", bytes.NewBufferString("0123456789"), 10))
+	require.Equal(t, []string{"2", "3"}, uploadedParts)
+
+	content, err := bucket.Get("/bucketName/artifactName")
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(content))
+}
@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dropbox/changes-artifacts/common/stats"
+)
+
+var contentCacheHitsCounter = stats.NewStat("content_cache_hits")
+var contentCacheMissesCounter = stats.NewStat("content_cache_misses")
+var contentCacheFillFailedCounter = stats.NewStat("content_cache_fill_failed")
+
+// Prefix of files being written to the cache directory. They are removed on startup, in case a
+// previous process died while filling the cache.
+const cacheTempPrefix = ".tmp-"
+
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+// CachingBlobStore keeps local copies of blob versions read from another BlobStore (typically S3)
+// in a directory, up to a maximum total size, evicting the least recently read blobs first.
+//
+// Blobs are cached by version (see BlobVersion), never by name, so a blob replaced or deleted
+// through any server is never served from the cache; stale versions simply age out. Reads by name
+// go straight to the underlying store.
+//
+// A blob version which isn't cached is cached while it is read in full. A ranged read of a blob
+// version which isn't cached is served by the underlying store, while the blob is copied to the
+// cache in the background, so that the first read of a large blob doesn't have to wait for all of
+// it. Blobs larger than the cache are never copied.
+type CachingBlobStore struct {
+	BlobStore
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	bytes   int64
+	lru     *list.List // Of *cacheEntry, most recently used first.
+	entries map[string]*list.Element
+	filling map[string]bool
+	fills   sync.WaitGroup
+}
+
+// NewCachingBlobStore wraps store with a cache of at most maxBytes in dir. Blobs cached in dir by a
+// previous process are reused.
+func NewCachingBlobStore(store BlobStore, dir string, maxBytes int64) (*CachingBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &CachingBlobStore{
+		BlobStore: store,
+		dir:       dir,
+		maxBytes:  maxBytes,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+		filling:   make(map[string]bool),
+	}
+	if err := c.loadEntries(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadEntries indexes blobs already in the cache directory. Cached blobs are touched whenever they
+// are read, so modification times give their LRU order.
+func (c *CachingBlobStore) loadEntries() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	sort.Sort(byModTime(files))
+
+	for _, file := range files {
+		path := filepath.Join(c.dir, file.Name())
+		if strings.HasPrefix(file.Name(), cacheTempPrefix) {
+			os.Remove(path)
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		c.entries[file.Name()] = c.lru.PushFront(&cacheEntry{path: path, size: file.Size()})
+		c.bytes += file.Size()
+	}
+
+	c.evict()
+	return nil
+}
+
+type byModTime []os.FileInfo
+
+func (f byModTime) Len() int           { return len(f) }
+func (f byModTime) Less(i, j int) bool { return f[i].ModTime().Before(f[j].ModTime()) }
+func (f byModTime) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// cacheKey maps a blob version id to the name of its file in the cache directory.
+func cacheKey(id string) string {
+	digest := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(digest[:])
+}
+
+// evict removes least recently used blobs until the cache fits in maxBytes. Must be called with
+// c.mu held.
+func (c *CachingBlobStore) evict() {
+	for c.bytes > c.maxBytes {
+		c.removeEntry(c.lru.Back())
+	}
+}
+
+// removeEntry drops a cached blob. Must be called with c.mu held.
+func (c *CachingBlobStore) removeEntry(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, filepath.Base(entry.path))
+	c.bytes -= entry.size
+	// Readers which have the file open can still read it until they close it.
+	os.Remove(entry.path)
+}
+
+// open returns the cached copy of blob version v, or nil if there is none. On a miss, fill is true
+// if the caller should cache the blob: it fits in the cache and isn't being cached already. The
+// caller must then call doneFilling once it is done.
+func (c *CachingBlobStore) open(v BlobVersion) (f *os.File, fill bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(v.ID)
+	if elem, ok := c.entries[key]; ok {
+		path := elem.Value.(*cacheEntry).path
+		f, err := os.Open(path)
+		if err == nil {
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			c.lru.MoveToFront(elem)
+			contentCacheHitsCounter.Add(1)
+			return f, false
+		}
+		// Removed from under us, cache it again.
+		c.removeEntry(elem)
+	}
+
+	contentCacheMissesCounter.Add(1)
+	if v.Size > c.maxBytes || c.filling[key] {
+		return nil, false
+	}
+	c.filling[key] = true
+	return nil, true
+}
+
+func (c *CachingBlobStore) doneFilling(v BlobVersion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.filling, cacheKey(v.ID))
+}
+
+// add moves file tmp, holding the complete content of blob version v, into the cache.
+func (c *CachingBlobStore) add(v BlobVersion, tmp string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(v.ID)
+	if _, ok := c.entries[key]; ok {
+		return nil
+	}
+	path := filepath.Join(c.dir, key)
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{path: path, size: v.Size})
+	c.bytes += v.Size
+	c.evict()
+	return nil
+}
+
+// fill copies blob version v from the underlying store into the cache.
+func (c *CachingBlobStore) fill(v BlobVersion) {
+	defer c.fills.Done()
+
+	rc, err := c.BlobStore.Get(v.Name)
+	if err != nil {
+		c.doneFilling(v)
+		log.Printf("Error caching blob %s: %s", v.Name, err)
+		contentCacheFillFailedCounter.Add(1)
+		return
+	}
+	rc = c.newCachingReader(v, rc)
+	defer rc.Close()
+	io.Copy(ioutil.Discard, rc)
+}
+
+// GetVersion reads blob version v from the cache if possible, and caches it otherwise. See
+// VersionedBlobStore.GetVersion.
+func (c *CachingBlobStore) GetVersion(v BlobVersion) (io.ReadCloser, error) {
+	if v.ID == "" {
+		return c.BlobStore.Get(v.Name)
+	}
+
+	f, fill := c.open(v)
+	if f != nil {
+		return f, nil
+	}
+
+	rc, err := c.BlobStore.Get(v.Name)
+	if !fill {
+		return rc, err
+	}
+	if err != nil {
+		c.doneFilling(v)
+		return nil, err
+	}
+	return c.newCachingReader(v, rc), nil
+}
+
+// GetVersionRange reads bytes begin through end of blob version v from the cache if possible. See
+// VersionedBlobStore.GetVersionRange.
+func (c *CachingBlobStore) GetVersionRange(v BlobVersion, begin int64, end int64) (io.ReadCloser, error) {
+	if v.ID == "" {
+		return c.BlobStore.GetRange(v.Name, begin, end)
+	}
+	if begin == 0 && end >= v.Size-1 {
+		// The entire blob is read, which fills the cache without reading it again.
+		return c.GetVersion(v)
+	}
+
+	f, fill := c.open(v)
+	if f == nil {
+		if fill {
+			c.fills.Add(1)
+			go c.fill(v)
+		}
+		return c.BlobStore.GetRange(v.Name, begin, end)
+	}
+
+	if _, err := f.Seek(begin, os.SEEK_SET); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &limitedFile{Reader: io.LimitReader(f, end-begin+1), f: f}, nil
+}
+
+var errCachedSizeMismatch = errors.New("Size of blob does not match its version")
+
+// cachingReader passes a blob read from the underlying store through to the caller, while copying
+// it to a temporary file which is added to the cache once the whole blob has been read.
+type cachingReader struct {
+	io.ReadCloser
+	c    *CachingBlobStore
+	v    BlobVersion
+	tmp  *os.File
+	read int64
+}
+
+// newCachingReader returns a reader for rc, which holds blob version v, that caches v as it is read.
+// c.filling must be set for v.
+func (c *CachingBlobStore) newCachingReader(v BlobVersion, rc io.ReadCloser) io.ReadCloser {
+	tmp, err := ioutil.TempFile(c.dir, cacheTempPrefix)
+	if err != nil {
+		c.doneFilling(v)
+		log.Printf("Error caching blob %s: %s", v.Name, err)
+		contentCacheFillFailedCounter.Add(1)
+		return rc
+	}
+	return &cachingReader{ReadCloser: rc, c: c, v: v, tmp: tmp}
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.tmp == nil {
+		return n, err
+	}
+
+	if n > 0 {
+		if _, werr := r.tmp.Write(p[:n]); werr != nil {
+			r.finish(werr)
+			return n, err
+		}
+		r.read += int64(n)
+	}
+
+	if err == io.EOF {
+		if r.read != r.v.Size {
+			r.finish(errCachedSizeMismatch)
+		} else {
+			r.finish(r.tmp.Close())
+		}
+	} else if err != nil {
+		r.finish(nil)
+	}
+	return n, err
+}
+
+// finish stops copying the blob, adding it to the cache if it was read in full without error.
+func (r *cachingReader) finish(err error) {
+	tmp := r.tmp
+	r.tmp = nil
+	defer r.c.doneFilling(r.v)
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	if err == nil && r.read == r.v.Size {
+		err = r.c.add(r.v, tmp.Name())
+	}
+	if err != nil {
+		log.Printf("Error caching blob %s: %s", r.v.Name, err)
+		contentCacheFillFailedCounter.Add(1)
+	}
+}
+
+// Close stops reading the blob. It is only cached if it was read in full.
+func (r *cachingReader) Close() error {
+	if r.tmp != nil {
+		r.finish(nil)
+	}
+	return r.ReadCloser.Close()
+}
+
+// limitedFile reads part of a file, closing the file when done.
+type limitedFile struct {
+	io.Reader
+	f *os.File
+}
+
+func (l *limitedFile) Close() error {
+	return l.f.Close()
+}
+
+var _ VersionedBlobStore = (*CachingBlobStore)(nil)
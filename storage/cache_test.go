@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingBlobStore counts reads from a BlobStore.
+type countingBlobStore struct {
+	BlobStore
+	reads int64
+}
+
+func (c *countingBlobStore) Get(name string) (io.ReadCloser, error) {
+	atomic.AddInt64(&c.reads, 1)
+	return c.BlobStore.Get(name)
+}
+
+func (c *countingBlobStore) GetRange(name string, begin int64, end int64) (io.ReadCloser, error) {
+	atomic.AddInt64(&c.reads, 1)
+	return c.BlobStore.GetRange(name, begin, end)
+}
+
+func (c *countingBlobStore) numReads() int64 {
+	return atomic.LoadInt64(&c.reads)
+}
+
+func testCachingBlobStore(t *testing.T, maxBytes int64) (*CachingBlobStore, *countingBlobStore, func()) {
+	dir, err := ioutil.TempDir("", "artifacts-cache-test")
+	require.NoError(t, err)
+
+	local, err := NewLocalBlobStore(dir + "/store")
+	require.NoError(t, err)
+	inner := &countingBlobStore{BlobStore: local}
+
+	cache, err := NewCachingBlobStore(inner, dir+"/cache", maxBytes)
+	require.NoError(t, err)
+	return cache, inner, func() { os.RemoveAll(dir) }
+}
+
+func TestCachingBlobStore(t *testing.T) {
+	cache, _, cleanup := testCachingBlobStore(t, 100)
+	defer cleanup()
+
+	testBlobStore(t, cache)
+	cache.fills.Wait()
+	require.Empty(t, cache.entries)
+}
+
+func TestCachingBlobStoreHits(t *testing.T) {
+	cache, inner, cleanup := testCachingBlobStore(t, 15)
+	defer cleanup()
+
+	readAll := func(rc io.ReadCloser, err error) string {
+		require.NoError(t, err)
+		defer rc.Close()
+
+		content, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	require.NoError(t, cache.Put("/b/a1", bytes.NewBufferString("0123456789"), 10))
+	require.NoError(t, cache.Put("/b/a2", bytes.NewBufferString("abcdefghij"), 10))
+	a1 := BlobVersion{Name: "/b/a1", ID: "a1-1", Size: 10}
+	a2 := BlobVersion{Name: "/b/a2", ID: "a2-1", Size: 10}
+
+	// First ranged read is served by the underlying store, while the blob is cached.
+	require.Equal(t, "2345", readAll(cache.GetVersionRange(a1, 2, 5)))
+	require.Equal(t, int64(1), inner.numReads())
+	cache.fills.Wait()
+	require.Equal(t, int64(2), inner.numReads())
+
+	// Later reads are served from the cache.
+	require.Equal(t, "789", readAll(cache.GetVersionRange(a1, 7, 9)))
+	require.Equal(t, "0123456789", readAll(cache.GetVersion(a1)))
+	require.Equal(t, int64(2), inner.numReads())
+
+	// Reads by name are never served from the cache.
+	require.Equal(t, "0123456789", readAll(cache.Get("/b/a1")))
+	require.Equal(t, int64(3), inner.numReads())
+
+	// A blob which is only partially read isn't cached.
+	rc, err := cache.GetVersion(a2)
+	require.NoError(t, err)
+	_, err = rc.Read(make([]byte, 3))
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, int64(4), inner.numReads())
+
+	// A blob which is read in full is cached without reading it again. Least recently used blob is
+	// evicted to make room.
+	require.Equal(t, "abcdefghij", readAll(cache.GetVersionRange(a2, 0, 9)))
+	cache.fills.Wait()
+	require.Equal(t, "abcdefghij", readAll(cache.GetVersion(a2)))
+	require.Equal(t, int64(5), inner.numReads())
+	require.Len(t, cache.entries, 1)
+	require.Equal(t, int64(10), cache.bytes)
+
+	// Cached blobs are reused after a restart.
+	cache, err = NewCachingBlobStore(inner, cache.dir, 15)
+	require.NoError(t, err)
+	require.Equal(t, "cdef", readAll(cache.GetVersionRange(a2, 2, 5)))
+	require.Equal(t, int64(5), inner.numReads())
+
+	// A replaced blob is a new version, which is not served from the cache.
+	require.NoError(t, cache.Put("/b/a2", bytes.NewBufferString("xyz"), 3))
+	a2 = BlobVersion{Name: "/b/a2", ID: "a2-2", Size: 3}
+	require.Equal(t, "xyz", readAll(cache.GetVersion(a2)))
+	require.Equal(t, "xyz", readAll(cache.GetVersion(a2)))
+	require.Equal(t, int64(6), inner.numReads())
+
+	// Blobs larger than the cache are never copied to it.
+	require.NoError(t, cache.Put("/b/large", bytes.NewBufferString("0123456789abcdef"), 16))
+	large := BlobVersion{Name: "/b/large", ID: "large-1", Size: 16}
+	require.Equal(t, "2345", readAll(cache.GetVersionRange(large, 2, 5)))
+	cache.fills.Wait()
+	require.Equal(t, "0123456789abcdef", readAll(cache.GetVersion(large)))
+	require.Equal(t, int64(8), inner.numReads())
+
+	// Blobs whose size doesn't match their version, or without a version id, are not cached.
+	mismatched := BlobVersion{Name: "/b/a1", ID: "a1-2", Size: 9}
+	require.Equal(t, "0123456789", readAll(cache.GetVersion(mismatched)))
+	unversioned := BlobVersion{Name: "/b/a1", Size: 10}
+	require.Equal(t, "0123456789", readAll(cache.GetVersion(unversioned)))
+	require.Equal(t, int64(10), inner.numReads())
+
+	require.Equal(t, int64(13), cache.bytes)
+	files, err := ioutil.ReadDir(cache.dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// LocalBlobStore stores blobs as files under a root directory on the local filesystem. Blob names
+// are mapped to paths relative to the root directory.
+type LocalBlobStore struct {
+	root string
+}
+
+// NewLocalBlobStore creates a BlobStore which stores blobs under root. root is created if it does
+// not exist.
+func NewLocalBlobStore(root string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalBlobStore{root: root}, nil
+}
+
+// pathFor maps a blob name to a file under the root directory. The name is cleaned first so that
+// blobs can never escape the root directory.
+func (l *LocalBlobStore) pathFor(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// Put writes content to a temporary file next to its final location, and renames it into place
+// once it is complete. Readers never see a partially written blob, and a failed Put leaves any
+// existing blob untouched.
+func (l *LocalBlobStore) Put(name string, content io.Reader, size int64) error {
+	p := l.pathFor(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tmp-"+filepath.Base(p)+"-")
+	if err != nil {
+		return err
+	}
+
+	cleanupAndReturn := func(err error) error {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if n, err := io.CopyN(f, content, size); err != nil {
+		return cleanupAndReturn(fmt.Errorf("Error writing blob %s (%d/%d bytes written): %s", name, n, size, err))
+	}
+
+	if err := f.Sync(); err != nil {
+		return cleanupAndReturn(err)
+	}
+
+	if err := f.Close(); err != nil {
+		return cleanupAndReturn(err)
+	}
+
+	// TempFile creates files readable only by the owner.
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return cleanupAndReturn(err)
+	}
+
+	if err := os.Rename(f.Name(), p); err != nil {
+		return cleanupAndReturn(err)
+	}
+
+	return nil
+}
+
+func (l *LocalBlobStore) Get(name string) (io.ReadCloser, error) {
+	f, err := os.Open(l.pathFor(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (l *LocalBlobStore) GetRange(name string, begin int64, end int64) (io.ReadCloser, error) {
+	f, err := os.Open(l.pathFor(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(begin, os.SEEK_SET); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return readCloser{io.LimitReader(f, end-begin+1), f}, nil
+}
+
+func (l *LocalBlobStore) Delete(name string) error {
+	if err := os.Remove(l.pathFor(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SignedURL is not supported, since blobs are only accessible through the artifact server.
+func (l *LocalBlobStore) SignedURL(name string, expires time.Time) (string, error) {
+	return "", ErrSignedURLNotSupported
+}
+
+// Ensure LocalBlobStore implements BlobStore
+var _ BlobStore = new(LocalBlobStore)
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"gopkg.in/amz.v1/s3"
+)
+
+const contentType = "binary/octet-stream"
+
+// S3BlobStore stores blobs as objects in an S3 bucket.
+type S3BlobStore struct {
+	bucket *s3.Bucket
+
+	// Blobs of at least MultipartThreshold bytes are uploaded using multipart upload, in parts of
+	// PartSize bytes. Each part is attempted up to MaxPartAttempts times. A MultipartThreshold of 0
+	// disables multipart uploads.
+	MultipartThreshold int64
+	PartSize           int64
+	MaxPartAttempts    int
+}
+
+// NewS3BlobStore creates a BlobStore backed by given S3 bucket.
+func NewS3BlobStore(bucket *s3.Bucket) *S3BlobStore {
+	return &S3BlobStore{
+		bucket:             bucket,
+		MultipartThreshold: DefaultMultipartThreshold,
+		PartSize:           DefaultPartSize,
+		MaxPartAttempts:    DefaultMaxPartAttempts,
+	}
+}
+
+func (s *S3BlobStore) Put(name string, content io.Reader, size int64) error {
+	if s.MultipartThreshold > 0 && size >= s.MultipartThreshold {
+		return s.putMultipart(name, content, size)
+	}
+	return s.bucket.PutReader(name, content, size, contentType, s3.PublicRead)
+}
+
+func (s *S3BlobStore) Get(name string) (io.ReadCloser, error) {
+	rc, err := s.bucket.GetReader(name)
+	if s3err, ok := err.(*s3.Error); ok && s3err.StatusCode == http.StatusNotFound {
+		return nil, ErrNotExist
+	}
+	return rc, err
+}
+
+// GetRange fetches the requested byte range through a signed URL, since the S3 client does not
+// support ranged reads.
+func (s *S3BlobStore) GetRange(name string, begin int64, end int64) (io.ReadCloser, error) {
+	url := s.bucket.SignedURL(name, time.Now().Add(30*time.Minute))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", begin, end))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// Range was ignored and the whole object is being sent. Skip to the requested range.
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, begin); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return readCloser{io.LimitReader(resp.Body, end-begin+1), resp.Body}, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotExist
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("Bad status code %d recieved from S3", resp.StatusCode)
+	}
+}
+
+func (s *S3BlobStore) Delete(name string) error {
+	return s.bucket.Del(name)
+}
+
+func (s *S3BlobStore) SignedURL(name string, expires time.Time) (string, error) {
+	return s.bucket.SignedURL(name, expires), nil
+}
+
+// readCloser combines a (possibly wrapped) reader with the Closer of the underlying stream.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Ensure S3BlobStore implements BlobStore
+var _ BlobStore = new(S3BlobStore)
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"log"
+
+	"gopkg.in/amz.v1/s3"
+)
+
+// DefaultMultipartThreshold is the default size at or above which objects are uploaded to S3 using
+// multipart upload.
+const DefaultMultipartThreshold = 64 * 1024 * 1024
+
+// DefaultPartSize is the default size of each part in a multipart upload. S3 requires all parts
+// except the last one to be at least 5 MB.
+const DefaultPartSize = 16 * 1024 * 1024
+
+// DefaultMaxPartAttempts is the default maximum number of attempts to upload a single part.
+const DefaultMaxPartAttempts = 3
+
+// putMultipart uploads content to S3 part by part. Only one part is held in memory at a time, and
+// each part is retried independently, so a flaky request doesn't restart the whole upload.
+//
+// If an earlier upload of the same object was interrupted without being cleaned up (for example,
+// if the server was restarted in the middle of an upload), it is resumed: parts which were already
+// uploaded with identical content are not sent again. If the upload fails, it is aborted so that
+// S3 doesn't keep the incomplete parts around.
+func (s *S3BlobStore) putMultipart(name string, content io.Reader, size int64) error {
+	multi, err := s.bucket.Multi(name, contentType, s3.PublicRead)
+	if err != nil {
+		return fmt.Errorf("Error starting multipart upload of %s: %s", name, err)
+	}
+
+	abortAndReturn := func(err error) error {
+		if abortErr := multi.Abort(); abortErr != nil {
+			log.Printf("Error aborting multipart upload of %s: %s", name, abortErr)
+		}
+		return err
+	}
+
+	existingParts, err := multi.ListParts()
+	if err != nil {
+		return abortAndReturn(fmt.Errorf("Error listing parts of multipart upload of %s: %s", name, err))
+	}
+
+	uploaded := make(map[int]s3.Part)
+	for _, part := range existingParts {
+		uploaded[part.N] = part
+	}
+
+	buf := make([]byte, s.PartSize)
+	var parts []s3.Part
+	for n, offset := 1, int64(0); offset < size; n++ {
+		partSize := s.PartSize
+		if size-offset < partSize {
+			partSize = size - offset
+		}
+
+		if _, err := io.ReadFull(content, buf[:partSize]); err != nil {
+			return abortAndReturn(fmt.Errorf("Error reading part %d of %s: %s", n, name, err))
+		}
+
+		part, err := s.putPart(multi, n, buf[:partSize], uploaded[n])
+		if err != nil {
+			return abortAndReturn(err)
+		}
+
+		parts = append(parts, part)
+		offset += partSize
+	}
+
+	if err := multi.Complete(parts); err != nil {
+		return abortAndReturn(fmt.Errorf("Error completing multipart upload of %s: %s", name, err))
+	}
+
+	return nil
+}
+
+// putPart uploads data as part n of a multipart upload, unless existing (the part with the same
+// number from an interrupted upload) already has the same content.
+func (s *S3BlobStore) putPart(multi *s3.Multi, n int, data []byte, existing s3.Part) (s3.Part, error) {
+	// S3 uses the (quoted) MD5 of the part contents as its ETag.
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(data))
+	if existing.ETag == etag && existing.Size == int64(len(data)) {
+		return existing, nil
+	}
+
+	var err error
+	for attempts := 1; attempts <= s.MaxPartAttempts; attempts++ {
+		var part s3.Part
+		if part, err = multi.PutPart(n, bytes.NewReader(data)); err == nil {
+			return part, nil
+		}
+		log.Printf("[Attempt %d/%d] Error uploading part %d of %s: %s", attempts, s.MaxPartAttempts, n, multi.Key, err)
+	}
+
+	return s3.Part{}, fmt.Errorf("Error uploading part %d of %s: %s", n, multi.Key, err)
+}